@@ -4,8 +4,29 @@
 
 const API_BASE_URL = "https://algocdk.onrender.com";
 
-// Helper: Make requests with auth header if token exists
-async function apiRequest(endpoint, method = "GET", data = null, isForm = false) {
+// Helper: Trade the stored refresh token for a new access token
+async function refreshSession() {
+  const refreshToken = localStorage.getItem("refresh_token");
+  if (!refreshToken) return false;
+
+  const res = await fetch(`${API_BASE_URL}/api/auth/refresh`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ refresh_token: refreshToken }),
+  });
+  if (!res.ok) {
+    localStorage.removeItem("token");
+    localStorage.removeItem("refresh_token");
+    return false;
+  }
+  const data = await res.json();
+  localStorage.setItem("token", data.token);
+  localStorage.setItem("refresh_token", data.refresh_token);
+  return true;
+}
+
+// Helper: Make requests with auth header if token exists, refreshing it once when it has expired
+async function apiRequest(endpoint, method = "GET", data = null, isForm = false, retried = false) {
   const token = localStorage.getItem("token");
   const headers = token ? { "Authorization": `Bearer ${token}` } : {};
 
@@ -21,6 +42,9 @@ async function apiRequest(endpoint, method = "GET", data = null, isForm = false)
   }
 
   const res = await fetch(`${API_BASE_URL}${endpoint}`, options);
+  if (res.status === 401 && token && !retried && (await refreshSession())) {
+    return apiRequest(endpoint, method, data, isForm, true);
+  }
   if (!res.ok) throw new Error(`Request failed: ${res.status}`);
   return await res.json();
 }
@@ -36,6 +60,62 @@ export async function register(userData) {
   return apiRequest("/api/auth/register", "POST", userData);
 }
 
+export async function logout() {
+  const refreshToken = localStorage.getItem("refresh_token");
+  localStorage.removeItem("token");
+  localStorage.removeItem("refresh_token");
+  if (refreshToken) await apiRequest("/api/auth/logout", "POST", { refresh_token: refreshToken });
+}
+
+export async function getSessions() {
+  return apiRequest("/api/user/sessions");
+}
+
+export async function revokeSession(sessionId) {
+  return apiRequest(`/api/user/sessions/${sessionId}`, "DELETE");
+}
+
+// Second login step when login returns two_factor_required
+export async function verifyLoginChallenge(challengeToken, code, recoveryCode) {
+  return apiRequest("/api/auth/2fa/verify", "POST", { challenge_token: challengeToken, code, recovery_code: recoveryCode });
+}
+
+export async function getTwoFactorStatus() {
+  return apiRequest("/api/user/2fa");
+}
+
+export async function setupTwoFactor() {
+  return apiRequest("/api/user/2fa/setup", "POST");
+}
+
+export async function enableTwoFactor(code) {
+  return apiRequest("/api/user/2fa/enable", "POST", { code });
+}
+
+export async function disableTwoFactor(code, recoveryCode) {
+  return apiRequest("/api/user/2fa/disable", "POST", { code, recovery_code: recoveryCode });
+}
+
+export async function regenerateRecoveryCodes(code) {
+  return apiRequest("/api/user/2fa/recovery-codes", "POST", { code });
+}
+
+/* =====================
+   API KEYS
+===================== */
+export async function listApiKeys() {
+  return apiRequest("/api/user/api-keys");
+}
+
+// The returned api_key.key is shown once; store it before leaving the page
+export async function createApiKey(name, scopes, expiresInDays) {
+  return apiRequest("/api/user/api-keys", "POST", { name, scopes, expires_in_days: expiresInDays });
+}
+
+export async function revokeApiKey(keyId) {
+  return apiRequest(`/api/user/api-keys/${keyId}`, "DELETE");
+}
+
 /* =====================
    USER ACTIONS
 ===================== */
@@ -144,14 +224,48 @@ export async function updateAdmin(adminId, adminData) {
   return apiRequest(`/api/superadmin/update-admin/${adminId}`, "PUT", adminData);
 }
 
-export async function toggleAdminStatus(adminId) {
-  return apiRequest(`/api/superadmin/toggle-admin/${adminId}`, "PATCH");
+export async function toggleAdminStatus(adminId, reason) {
+  return apiRequest(`/api/superadmin/toggle-admin/${adminId}`, "PATCH", reason ? { reason } : null);
+}
+
+// status: active, suspended, banned or deleted; until (ISO time) only for suspensions
+export async function setAccountStatus(userId, status, reason, until) {
+  return apiRequest(`/api/superadmin/users/${userId}/status`, "PUT", { status, reason, until });
 }
 
 export async function deleteAdmin(adminId) {
   return apiRequest(`/api/superadmin/delete-admin/${adminId}`, "DELETE");
 }
 
+export async function getTwoFactorPolicies() {
+  return apiRequest("/api/superadmin/2fa-policy");
+}
+
+export async function setTwoFactorPolicy(role, required) {
+  return apiRequest(`/api/superadmin/2fa-policy/${role}`, "PUT", { required });
+}
+
+export async function getLockedAccounts() {
+  return apiRequest("/api/superadmin/locked-accounts");
+}
+
+export async function unlockAccount(userId) {
+  return apiRequest(`/api/superadmin/locked-accounts/${userId}/unlock`, "POST");
+}
+
+/* =====================
+   SUPERADMIN AUDIT LOG
+===================== */
+// filters: actor_id, action ("user.*" matches a prefix), target_type, target_id, request_id, q, from, to, page, limit
+export async function getAuditLog(filters = {}) {
+  const query = new URLSearchParams(filters).toString();
+  return apiRequest(`/api/superadmin/audit-log${query ? `?${query}` : ""}`);
+}
+
+export async function verifyAuditLog() {
+  return apiRequest("/api/superadmin/audit-log/verify");
+}
+
 /* =====================
    SUPERADMIN BOTS
 ===================== */
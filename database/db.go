@@ -1,6 +1,7 @@
 package database
 
 import (
+	"Api/internal/config"
 	"Api/models"
 	"log"
 	"os"
@@ -12,20 +13,19 @@ import (
 var DB *gorm.DB
 
 func InitDB() {
-	dsn := os.Getenv("DATABASE_URL") // Render environment variable
-
-	if dsn == "" {
-		log.Fatal("❌ DATABASE_URL is not set — did you add it on Render?")
-	}
-
 	var err error
-	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	DB, err = gorm.Open(postgres.Open(config.Get().DatabaseURL), &gorm.Config{})
 	if err != nil {
 		log.Fatal("❌ Failed to connect to PostgreSQL: ", err)
 	}
 
 	log.Println("✅ PostgreSQL connected successfully")
 
+	// Accounts from before email verification are treated as verified
+	grandfatherEmails := !DB.Migrator().HasColumn(&models.Person{}, "email_verified_at")
+	// Admins suspended through the old upgrade_request_status flag move to account statuses
+	convertSuspensions := !DB.Migrator().HasColumn(&models.Person{}, "status")
+
 	// Auto migrate models
 	err = DB.AutoMigrate(
 		&models.Person{},
@@ -37,12 +37,60 @@ func InitDB() {
 		&models.SalesHistory{},
 		&models.UserBot{},
 		&models.Sale{},
+		&models.RentalPlan{},
+		&models.Subscription{},
+		&models.LedgerAccount{},
+		&models.JournalEntry{},
+		&models.JournalLine{},
+		&models.ReconciliationReport{},
+		&models.ReconciliationItem{},
+		&models.Payout{},
+		&models.Coupon{},
+		&models.Invoice{},
+		&models.ExchangeRate{},
+		&models.TaxRule{},
+		&models.Dispute{},
+		&models.Wallet{},
+		&models.WalletEntry{},
+		&models.WalletHold{},
+		&models.WebhookEvent{},
+		&models.Session{},
+		&models.LoginChallenge{},
+		&models.RecoveryCode{},
+		&models.TwoFactorPolicy{},
+		&models.LoginAttempt{},
+		&models.APIKey{},
+		&models.AuditEntry{},
 	)
 
 	if err != nil {
 		log.Fatal("❌ Auto migration failed: ", err)
 	}
 
+	if err := migrateRentPrices(); err != nil {
+		log.Fatal("❌ Rental plan migration failed: ", err)
+	}
+
+	if err := migrateRoles(); err != nil {
+		log.Fatal("❌ Role migration failed: ", err)
+	}
+
+	if err := migrateAuditLog(); err != nil {
+		log.Fatal("❌ Audit log migration failed: ", err)
+	}
+
+	if convertSuspensions {
+		if err := migrateAdminSuspensions(); err != nil {
+			log.Fatal("❌ Account status migration failed: ", err)
+		}
+	}
+
+	if grandfatherEmails {
+		if err := DB.Exec("UPDATE people SET email_verified_at = created_at WHERE email_verified_at IS NULL").Error; err != nil {
+			log.Fatal("❌ Email verification migration failed: ", err)
+		}
+	}
+
 	log.Println("✅ Tables migrated successfully")
 
 	// Ensure uploads folder exists (still valid)
@@ -50,3 +98,86 @@ func InitDB() {
 		os.Mkdir("uploads", os.ModePerm)
 	}
 }
+
+// migrateRentPrices turns the old single bots.rent_price into a 30-day "monthly"
+// rental plan and points existing subscriptions at it. It runs once: the column
+// is dropped afterwards.
+func migrateRentPrices() error {
+	if !DB.Migrator().HasColumn("bots", "rent_price") {
+		return nil
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`INSERT INTO rental_plans (bot_id, name, duration_days, price, is_active, created_at, updated_at)
+			SELECT id, 'monthly', 30, rent_price, true, NOW(), NOW() FROM bots
+			WHERE rent_price > 0 AND NOT EXISTS (SELECT 1 FROM rental_plans WHERE rental_plans.bot_id = bots.id)`).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`UPDATE subscriptions SET rental_plan_id = (
+			SELECT MIN(id) FROM rental_plans WHERE rental_plans.bot_id = subscriptions.bot_id)
+			WHERE rental_plan_id IS NULL OR rental_plan_id = 0`).Error; err != nil {
+			return err
+		}
+		log.Println("✅ Migrated bots.rent_price to rental plans")
+		return tx.Migrator().DropColumn("bots", "rent_price")
+	})
+}
+
+// migrateRoles rewrites the role spellings older code stored ("User", "ADMIN",
+// "Super Admin", ...) to the normalized ones. Unknown roles become "user".
+func migrateRoles() error {
+	var roles []string
+	if err := DB.Model(&models.Person{}).Distinct().Pluck("role", &roles).Error; err != nil {
+		return err
+	}
+	for _, old := range roles {
+		role := models.NormalizeRole(old)
+		if string(role) == old {
+			continue
+		}
+		res := DB.Model(&models.Person{}).Where("role = ?", old).Update("role", role)
+		if res.Error != nil {
+			return res.Error
+		}
+		log.Printf("✅ Normalized role %q to %q on %d users", old, role, res.RowsAffected)
+	}
+	return DB.Model(&models.Person{}).Where("role IS NULL").Update("role", models.RoleUser).Error
+}
+
+// migrateAdminSuspensions carries "Suspended" admins over to the suspended
+// account status and gives the upgrade_request_status column back its meaning
+func migrateAdminSuspensions() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Exec(`UPDATE people SET status = 'suspended', status_reason = 'suspended by a superadmin', status_changed_at = NOW()
+			WHERE upgrade_request_status = 'Suspended'`)
+		if res.Error != nil {
+			return res.Error
+		}
+		log.Printf("✅ Moved %d suspended admins to account statuses", res.RowsAffected)
+		return tx.Exec(`UPDATE people SET upgrade_request_status = CASE WHEN role = 'admin' THEN 'approved' END
+			WHERE upgrade_request_status IN ('Suspended', 'Active')`).Error
+	})
+}
+
+// migrateAuditLog makes audit_entries append-only for the application's database
+// user: updates, deletes and truncates raise an error.
+func migrateAuditLog() error {
+	for _, stmt := range []string{
+		`CREATE OR REPLACE FUNCTION audit_entries_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_entries is append-only';
+		END;
+		$$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS audit_entries_no_change ON audit_entries`,
+		`CREATE TRIGGER audit_entries_no_change BEFORE UPDATE OR DELETE ON audit_entries
+			FOR EACH ROW EXECUTE FUNCTION audit_entries_append_only()`,
+		`DROP TRIGGER IF EXISTS audit_entries_no_truncate ON audit_entries`,
+		`CREATE TRIGGER audit_entries_no_truncate BEFORE TRUNCATE ON audit_entries
+			FOR EACH STATEMENT EXECUTE FUNCTION audit_entries_append_only()`,
+	} {
+		if err := DB.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"Api/database"
+	"Api/models"
+	"Api/services"
+)
+
+// accountInactive answers 403 when user's account is suspended, banned or
+// deleted. It reports whether it wrote a response.
+func accountInactive(ctx *gin.Context, user *models.Person) bool {
+	var inactive *services.AccountStatusError
+	if !errors.As(services.CheckAccountActive(user), &inactive) {
+		return false
+	}
+	respondAccountInactive(ctx, inactive)
+	return true
+}
+
+func respondAccountInactive(ctx *gin.Context, err *services.AccountStatusError) {
+	ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "code": "account_" + string(err.Status), "account_status": err})
+}
+
+// notifyAccountStatus tells a connected user about their new account status
+func notifyAccountStatus(user *models.Person) {
+	switch user.Status {
+	case models.AccountActive:
+		Hub.SendToUser(user.ID, "✅ Your account is active again.")
+	default:
+		Hub.SendToUser(user.ID, fmt.Sprintf("⛔ Your account has been %s: %s", user.Status, user.StatusReason))
+	}
+}
+
+// PUT /api/superadmin/users/:id/status
+// Body: {"status": "suspended", "reason": "chargebacks under review", "until": "2026-11-01T00:00:00Z"}.
+// until is optional and only applies to suspensions; "active" lifts any status.
+func SetAccountStatusHandler(ctx *gin.Context) {
+	var payload struct {
+		Status string     `json:"status" binding:"required"`
+		Reason string     `json:"reason"`
+		Until  *time.Time `json:"until"`
+	}
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "status is required, until must be an RFC 3339 time"})
+		return
+	}
+	status, ok := models.ParseAccountStatus(payload.Status)
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "status must be active, suspended, banned or deleted"})
+		return
+	}
+	userID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+	user, ok := updateAccountStatus(ctx, uint(userID), status, payload.Reason, payload.Until)
+	if !ok {
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "account status updated", "user": user})
+}
+
+// updateAccountStatus applies the change for a superadmin, records it for the
+// audit log and notifies the user. On failure it writes the response.
+func updateAccountStatus(ctx *gin.Context, userID uint, status models.AccountStatus, reason string, until *time.Time) (*models.Person, bool) {
+	var before models.Person
+	if err := database.DB.First(&before, userID).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return nil, false
+	}
+	auditTarget(ctx, "user.status", "user", before.ID, &before)
+
+	user, err := services.SetAccountStatus(userID, status, reason, until, ctx.GetUint("user_id"))
+	switch {
+	case errors.Is(err, services.ErrStatusReasonRequired), errors.Is(err, services.ErrStatusUntilInvalid), errors.Is(err, services.ErrOwnAccountStatus):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update account status"})
+		return nil, false
+	}
+	auditResult(ctx, user.ID, user)
+	notifyAccountStatus(user)
+	return user, true
+}
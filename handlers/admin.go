@@ -7,21 +7,28 @@ import (
 	"net/http"
 )
 
-// UpdateAdminBankDetails allows admins to update their bank details
+// UpdateAdminBankDetails allows admins to update their bank details and
+// choose whether payouts go to the bank or to M-Pesa
 func UpdateAdminBankDetails(ctx *gin.Context) {
 	var input struct {
 		BankCode      string `json:"bank_code"`
 		AccountNumber string `json:"account_number"`
 		AccountName   string `json:"account_name"`
+		PayoutMethod  string `json:"payout_method"` // "bank" or "mpesa"
+		MpesaPhone    string `json:"mpesa_phone"`
 	}
 	if err := ctx.ShouldBindJSON(&input); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
 		return
 	}
 
-	adminID := ctx.GetUint("admin_id") // Assuming middleware provides admin_id
+	if input.PayoutMethod != "" && input.PayoutMethod != "bank" && input.PayoutMethod != "mpesa" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "payout_method must be 'bank' or 'mpesa'"})
+		return
+	}
+
 	var admin models.Admin
-	if err := database.DB.First(&admin, adminID).Error; err != nil {
+	if err := database.DB.Where("person_id = ?", ctx.GetUint("user_id")).First(&admin).Error; err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{"message": "Admin not found"})
 		return
 	}
@@ -29,6 +36,16 @@ func UpdateAdminBankDetails(ctx *gin.Context) {
 	admin.BankCode = input.BankCode
 	admin.AccountNumber = input.AccountNumber
 	admin.AccountName = input.AccountName
+	if input.PayoutMethod != "" {
+		admin.PayoutMethod = input.PayoutMethod
+	}
+	if input.MpesaPhone != "" {
+		admin.PayoutPhone = input.MpesaPhone
+	}
+	if admin.PayoutMethod == "mpesa" && admin.PayoutPhone == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "mpesa_phone is required for M-Pesa payouts"})
+		return
+	}
 	if err := database.DB.Save(&admin).Error; err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to update bank details"})
 		return
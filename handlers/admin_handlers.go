@@ -2,7 +2,10 @@ package handlers
 
 import (
 	"Api/database"
+	"Api/internal/config"
 	"Api/models"
+	"Api/services"
+	"encoding/json"
 	"fmt"
 	"mime/multipart"
 	"os"
@@ -10,7 +13,6 @@ import (
 
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -38,12 +40,6 @@ func AdminDashboardHandler(ctx *gin.Context) {
 		return
 	}
 
-	roleLower := strings.ToLower(admin.Role)
-	if roleLower != "admin" && roleLower != "superadmin" {
-		ctx.JSON(http.StatusForbidden, gin.H{"message": "Access denied"})
-		return
-	}
-
 	// Fetch all bots created by this admin
 	var bots []models.Bot
 	if err := database.DB.Where("owner_id = ?", admin.ID).Find(&bots).Error; err != nil {
@@ -60,7 +56,7 @@ func AdminDashboardHandler(ctx *gin.Context) {
 			"name":       bot.Name,
 			"strategy":   bot.Strategy,
 			"price":      bot.Price,
-			"link":       "https://yourfrontend.com/bots/" + strconv.FormatUint(uint64(bot.ID), 10),
+			"link":       config.Get().FrontendURL + "/bots/" + strconv.FormatUint(uint64(bot.ID), 10),
 			"image":      bot.Image,
 			"html_file":  bot.HTMLFile,
 			"created_at": bot.CreatedAt.Format(time.RFC3339),
@@ -93,18 +89,12 @@ func GetAdminBotsHandler(ctx *gin.Context) {
 		return
 	}
 
-	roleInterface, _ := ctx.Get("role")
 	userID, ok := userIDInterface.(uint)
 	if !ok {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Invalid user_id"})
 		return
 	}
-
-	role, ok := roleInterface.(string)
-	if !ok || (strings.ToLower(role) != "admin" && strings.ToLower(role) != "superadmin") {
-		ctx.JSON(http.StatusForbidden, gin.H{"message": "Access denied"})
-		return
-	}
+	role := ctx.GetString("role")
 
 	// Fetch bots created by this admin
 	var bots []models.Bot
@@ -138,7 +128,7 @@ func GetAdminBotsHandler(ctx *gin.Context) {
 		}
 
 		// Bot link (frontend route)
-		botLink := "/bots/" + string(bot.ID)
+		botLink := "/bots/" + strconv.FormatUint(uint64(bot.ID), 10)
 
 		botData = append(botData, gin.H{
 			"id":     bot.ID,
@@ -180,6 +170,14 @@ func UpdateBotHandler(c *gin.Context) {
 	if name != "" {
 		bot.Name = name
 	}
+	if currency := c.PostForm("currency"); currency != "" {
+		normalized, err := services.NormalizeCurrency(currency, "")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		bot.Currency = normalized
+	}
 	if strategy != "" {
 		bot.Strategy = strategy
 	}
@@ -424,14 +422,33 @@ func CreateBotHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid price"})
 		return
 	}
+	// Prices, rental plans included, are in the bot's currency
+	currency, err := services.NormalizeCurrency(c.PostForm("currency"), "KES")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	var rentPrice float64
-	if rentPriceStr != "" {
-		rentPrice, err = strconv.ParseFloat(rentPriceStr, 64)
+	// Rental plans come as a JSON array; a bare rent_price still creates a monthly plan
+	var plans []services.RentalPlanInput
+	if plansJSON := c.PostForm("rental_plans"); plansJSON != "" {
+		if err := json.Unmarshal([]byte(plansJSON), &plans); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rental_plans"})
+			return
+		}
+	} else if rentPriceStr != "" {
+		rentPrice, err := strconv.ParseFloat(rentPriceStr, 64)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rent_price"})
 			return
 		}
+		plans = append(plans, services.RentalPlanInput{Name: "monthly", Price: rentPrice})
+	}
+	for i := range plans {
+		if err := plans[i].Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 	}
 
 	now := time.Now()
@@ -480,7 +497,7 @@ func CreateBotHandler(c *gin.Context) {
 		HTMLFile:         htmlPath,
 		Image:            imagePath,
 		Price:            price,
-		RentPrice:        rentPrice,
+		Currency:         currency,
 		Strategy:         strategy,
 		OwnerID:          userID,
 		CreatedAt:        now,
@@ -492,18 +509,28 @@ func CreateBotHandler(c *gin.Context) {
 		Version:          version,
 	}
 
+	for _, p := range plans {
+		bot.RentalPlans = append(bot.RentalPlans, models.RentalPlan{
+			Name:         p.Name,
+			DurationDays: p.DurationDays,
+			Price:        p.Price,
+			IsActive:     true,
+		})
+	}
+
 	if err := database.DB.Create(&bot).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save bot"})
 		return
 	}
 
 	// Generate bot link for frontend
-	botLink := fmt.Sprintf("https://yourfrontend.com/bots/%d", bot.ID)
+	botLink := fmt.Sprintf("%s/bots/%d", config.Get().FrontendURL, bot.ID)
 
 	c.JSON(http.StatusCreated, gin.H{
-		"message":  "Bot created successfully",
-		"bot_id":   bot.ID,
-		"bot_link": botLink,
+		"message":      "Bot created successfully",
+		"bot_id":       bot.ID,
+		"bot_link":     botLink,
+		"rental_plans": bot.RentalPlans,
 	})
 }
 
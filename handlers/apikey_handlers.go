@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"Api/models"
+	"Api/services"
+)
+
+func apiKeyJSON(k *models.APIKey) gin.H {
+	return gin.H{
+		"id":           k.ID,
+		"name":         k.Name,
+		"prefix":       k.Prefix,
+		"scopes":       k.ScopeList(),
+		"expires_at":   k.ExpiresAt,
+		"last_used_at": k.LastUsedAt,
+		"last_used_ip": k.LastUsedIP,
+		"revoked_at":   k.RevokedAt,
+		"created_at":   k.CreatedAt,
+		"active":       k.RevokedAt == nil && time.Now().Before(k.ExpiresAt),
+	}
+}
+
+// GET /api/user/api-keys
+func ListAPIKeysHandler(ctx *gin.Context) {
+	keys, err := services.ListAPIKeys(ctx.GetUint("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch API keys"})
+		return
+	}
+	list := make([]gin.H, 0, len(keys))
+	for i := range keys {
+		list = append(list, apiKeyJSON(&keys[i]))
+	}
+	ctx.JSON(http.StatusOK, gin.H{"api_keys": list})
+}
+
+// POST /api/user/api-keys
+// Body: {"name": "CI", "scopes": ["bots:write"], "expires_in_days": 90}. The key
+// is in the response once and cannot be shown again.
+func CreateAPIKeyHandler(ctx *gin.Context) {
+	var payload struct {
+		Name          string   `json:"name" binding:"required"`
+		Scopes        []string `json:"scopes" binding:"required"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := ctx.ShouldBindJSON(&payload); err != nil || strings.TrimSpace(payload.Name) == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "name and scopes are required"})
+		return
+	}
+	if payload.ExpiresInDays < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "expires_in_days cannot be negative"})
+		return
+	}
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+
+	key, plaintext, err := services.CreateAPIKey(user, payload.Name, payload.Scopes,
+		time.Duration(payload.ExpiresInDays)*24*time.Hour)
+	if err != nil {
+		var notAllowed *services.ScopeNotAllowedError
+		switch {
+		case errors.As(err, &notAllowed), errors.Is(err, services.ErrAPIKeyNoScopes), errors.Is(err, services.ErrAPIKeyTTLTooLong):
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrTooManyAPIKeys):
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create API key"})
+		}
+		return
+	}
+
+	resp := apiKeyJSON(key)
+	resp["key"] = plaintext
+	ctx.JSON(http.StatusCreated, gin.H{"message": "API key created, copy it now as it will not be shown again", "api_key": resp})
+}
+
+// DELETE /api/user/api-keys/:id
+func RevokeAPIKeyHandler(ctx *gin.Context) {
+	keyID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid API key id"})
+		return
+	}
+	if err := services.RevokeAPIKey(ctx.GetUint("user_id"), uint(keyID)); err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke API key"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
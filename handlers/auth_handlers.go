@@ -1 +1,165 @@
 package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"Api/database"
+	"Api/internal/config"
+	"Api/models"
+	"Api/services"
+)
+
+// POST /api/auth/refresh
+// Body: {"refresh_token": "..."}. The refresh token rotates; keep the new one.
+func RefreshTokenHandler(ctx *gin.Context) {
+	var payload struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "refresh_token is required"})
+		return
+	}
+
+	tokens, err := services.RefreshSession(payload.RefreshToken, ctx.Request.UserAgent(), ctx.ClientIP())
+	var inactive *services.AccountStatusError
+	if errors.As(err, &inactive) {
+		respondAccountInactive(ctx, inactive)
+		return
+	}
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReused) {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh session"})
+		return
+	}
+	ctx.JSON(http.StatusOK, tokens)
+}
+
+// POST /api/auth/logout
+// Body: {"refresh_token": "..."}; signs this device out.
+func LogoutHandler(ctx *gin.Context) {
+	var payload struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "refresh_token is required"})
+		return
+	}
+	if err := services.EndSession(payload.RefreshToken); err != nil && !errors.Is(err, services.ErrInvalidRefreshToken) {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sign out"})
+		return
+	}
+	// An unknown or already revoked token is as signed out as it gets
+	ctx.JSON(http.StatusOK, gin.H{"message": "signed out"})
+}
+
+// GET /api/user/sessions
+// The user's signed-in devices; "current" marks the one making the request.
+func ListSessionsHandler(ctx *gin.Context) {
+	sessions, err := services.ListSessions(ctx.GetUint("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch sessions"})
+		return
+	}
+	current := ctx.GetUint("session_id")
+	list := make([]gin.H, 0, len(sessions))
+	for _, s := range sessions {
+		list = append(list, gin.H{
+			"id":           s.ID,
+			"user_agent":   s.UserAgent,
+			"ip":           s.IP,
+			"created_at":   s.CreatedAt,
+			"last_used_at": s.LastUsedAt,
+			"expires_at":   s.ExpiresAt,
+			"current":      s.ID == current,
+		})
+	}
+	ctx.JSON(http.StatusOK, gin.H{"sessions": list})
+}
+
+// DELETE /api/user/sessions/:id
+func RevokeSessionHandler(ctx *gin.Context) {
+	sessionID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid session id"})
+		return
+	}
+	if err := services.RevokeSession(ctx.GetUint("user_id"), uint(sessionID), "revoked by user"); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke session"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+}
+
+// POST /api/user/sessions/revoke-others
+// Signs out every device except the one making the request.
+func RevokeOtherSessionsHandler(ctx *gin.Context) {
+	n, err := services.RevokeOtherSessions(ctx.GetUint("user_id"), ctx.GetUint("session_id"), "revoked by user")
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "other sessions revoked", "revoked": n})
+}
+
+// GET /api/auth/verify-email?token=...
+// The link in the verification email; redirects to the sign-in page with the outcome.
+func VerifyEmailLinkHandler(ctx *gin.Context) {
+	target := config.Get().FrontendURL + "/auth?verified=1"
+	if _, err := services.VerifyEmail(ctx.Query("token")); err != nil {
+		target = config.Get().FrontendURL + "/auth?verify_error=" + url.QueryEscape(err.Error())
+	}
+	ctx.Redirect(http.StatusFound, target)
+}
+
+// POST /api/auth/verify-email
+// Body: {"token": "..."}, for clients that handle the link themselves.
+func VerifyEmailHandler(ctx *gin.Context) {
+	var payload struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return
+	}
+	user, err := services.VerifyEmail(payload.Token)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidVerificationToken) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify email"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "email verified", "email_verified_at": user.EmailVerifiedAt})
+}
+
+// POST /api/user/verify-email/resend
+// Mails a new verification link to the signed-in user.
+func ResendVerificationHandler(ctx *gin.Context) {
+	var user models.Person
+	if err := database.DB.First(&user, ctx.GetUint("user_id")).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if err := services.SendVerificationEmail(&user); err != nil {
+		if errors.Is(err, services.ErrEmailAlreadyVerified) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send verification email"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "verification email sent to " + user.Email})
+}
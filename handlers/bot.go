@@ -3,14 +3,22 @@ package handlers
 import (
 	"Api/database"
 	"Api/models"
+	"Api/services"
 	"github.com/gin-gonic/gin"
 	"net/http"
 )
 
+// GET /api/bots/:id?currency=NGN
+// With currency the prices are also shown converted at the stored rate.
 func GetBotDetails(ctx *gin.Context) {
 	botID := ctx.Param("id")
 	var bot models.Bot
-	if err := database.DB.First(&bot, botID).Error; err != nil {
+	if err := database.DB.Preload("RentalPlans", "is_active = ?", true).First(&bot, botID).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "Bot not found"})
+		return
+	}
+	// Hidden like in the marketplace while the creator is not active
+	if services.CheckSellerActive(&bot) != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{"message": "Bot not found"})
 		return
 	}
@@ -21,16 +29,34 @@ func GetBotDetails(ctx *gin.Context) {
 		return
 	}
 
+	data := map[string]interface{}{
+		"id":           bot.ID,
+		"admin_id":     admin.ID,
+		"price":        bot.Price,
+		"currency":     services.BotCurrency(&bot),
+		"rental_plans": bot.RentalPlans,
+		"payment_type": bot.SubscriptionType,
+		"name":         bot.Name,
+		"description":  bot.Description,
+	}
+	if currency := ctx.Query("currency"); currency != "" {
+		data["display"] = displayPrices(&bot, currency)
+	}
+
 	ctx.JSON(http.StatusOK, gin.H{
 		"message": "Bot details retrieved",
-		"data": map[string]interface{}{
-			"id":           bot.ID,
-			"admin_id":     admin.ID,
-			"price":        bot.Price,
-			"rent_price":   bot.RentPrice,
-			"payment_type": bot.SubscriptionType,
-			"name":         bot.Name,
-			"description":  bot.Description,
-		},
+		"data":    data,
 	})
 }
+
+// displayPrices converts a bot's price and active rental plans into the buyer's
+// currency. Prices stay in the bot's currency when there is no rate for it.
+func displayPrices(bot *models.Bot, currency string) gin.H {
+	price, shown := services.PriceIn(bot.Price, services.BotCurrency(bot), currency)
+	plans := make([]gin.H, 0, len(bot.RentalPlans))
+	for _, plan := range bot.RentalPlans {
+		planPrice, _ := services.PriceIn(plan.Price, services.BotCurrency(bot), shown)
+		plans = append(plans, gin.H{"id": plan.ID, "name": plan.Name, "price": planPrice})
+	}
+	return gin.H{"currency": shown, "price": price, "rental_plans": plans}
+}
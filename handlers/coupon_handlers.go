@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"Api/database"
+	"Api/models"
+	"Api/services"
+)
+
+// POST /api/user/coupons/validate
+// Body: {"code": "LAUNCH20", "bot_id": 1, "payment_type": "rent", "plan_id": 2}
+// Previews the discounted price; the coupon is checked again at checkout.
+func ValidateCouponHandler(c *gin.Context) {
+	var input struct {
+		Code        string `json:"code" binding:"required"`
+		BotID       uint   `json:"bot_id" binding:"required"`
+		PaymentType string `json:"payment_type" binding:"required"`
+		PlanID      uint   `json:"plan_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+
+	var bot models.Bot
+	if err := database.DB.First(&bot, input.BotID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Bot not found"})
+		return
+	}
+	price := bot.Price
+	switch input.PaymentType {
+	case "purchase":
+	case "rent":
+		plan, err := services.RentalPlanFor(bot.ID, input.PlanID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		price = plan.Price
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid payment type"})
+		return
+	}
+
+	quote, err := services.ApplyCoupon(input.Code, c.GetUint("user_id"), &bot, input.PaymentType, price)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	var buyer models.Person
+	database.DB.First(&buyer, c.GetUint("user_id"))
+	quote.AddTax(&buyer)
+	c.JSON(http.StatusOK, gin.H{"message": "Coupon applied", "quote": quote})
+}
+
+// GET /api/admin/coupons
+func ListCreatorCouponsHandler(c *gin.Context) {
+	var coupons []models.Coupon
+	if err := database.DB.Where("creator_id = ?", c.GetUint("user_id")).Order("created_at DESC").Find(&coupons).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch coupons"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"coupons": coupons})
+}
+
+// POST /api/admin/coupons
+// Creator coupons apply to all of the creator's bots, or to one of them with bot_id.
+func CreateCreatorCouponHandler(c *gin.Context) {
+	input, ok := bindCreatorCoupon(c)
+	if !ok {
+		return
+	}
+	coupon := models.Coupon{IsActive: true, CreatedBy: c.GetUint("user_id")}
+	saveCoupon(c, &coupon, input)
+}
+
+// PUT /api/admin/coupons/:id
+func UpdateCreatorCouponHandler(c *gin.Context) {
+	var coupon models.Coupon
+	if err := database.DB.Where("id = ? AND creator_id = ?", c.Param("id"), c.GetUint("user_id")).First(&coupon).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "coupon not found"})
+		return
+	}
+	input, ok := bindCreatorCoupon(c)
+	if !ok {
+		return
+	}
+	saveCoupon(c, &coupon, input)
+}
+
+// DELETE /api/admin/coupons/:id
+// Coupons are deactivated rather than deleted because transactions point at them.
+func DeleteCreatorCouponHandler(c *gin.Context) {
+	deactivateCoupon(c, database.DB.Where("id = ? AND creator_id = ?", c.Param("id"), c.GetUint("user_id")))
+}
+
+// GET /api/superadmin/coupons
+func ListAllCouponsHandler(c *gin.Context) {
+	q := database.DB.Order("created_at DESC")
+	if c.Query("platform") == "true" {
+		q = q.Where("creator_id IS NULL AND bot_id IS NULL")
+	}
+	var coupons []models.Coupon
+	if err := q.Find(&coupons).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch coupons"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"coupons": coupons})
+}
+
+// POST /api/superadmin/coupons
+// Without bot_id or creator_id the coupon applies platform-wide.
+func CreateCouponHandler(c *gin.Context) {
+	input, ok := bindCoupon(c)
+	if !ok {
+		return
+	}
+	coupon := models.Coupon{IsActive: true, CreatedBy: c.GetUint("user_id")}
+	saveCoupon(c, &coupon, input)
+}
+
+// PUT /api/superadmin/coupons/:id
+func UpdateCouponHandler(c *gin.Context) {
+	var coupon models.Coupon
+	if err := database.DB.First(&coupon, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "coupon not found"})
+		return
+	}
+	input, ok := bindCoupon(c)
+	if !ok {
+		return
+	}
+	saveCoupon(c, &coupon, input)
+}
+
+// DELETE /api/superadmin/coupons/:id
+func DeleteCouponHandler(c *gin.Context) {
+	deactivateCoupon(c, database.DB.Where("id = ?", c.Param("id")))
+}
+
+func bindCoupon(c *gin.Context) (*services.CouponInput, bool) {
+	var input services.CouponInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return nil, false
+	}
+	if err := input.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &input, true
+}
+
+// bindCreatorCoupon binds a coupon and scopes it to the calling creator
+func bindCreatorCoupon(c *gin.Context) (*services.CouponInput, bool) {
+	input, ok := bindCoupon(c)
+	if !ok {
+		return nil, false
+	}
+	creatorID := c.GetUint("user_id")
+	if input.BotID != nil {
+		var bot models.Bot
+		if err := database.DB.First(&bot, *input.BotID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "bot not found"})
+			return nil, false
+		}
+		if bot.OwnerID != creatorID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not your bot"})
+			return nil, false
+		}
+	}
+	input.CreatorID = &creatorID
+	return input, true
+}
+
+func saveCoupon(c *gin.Context, coupon *models.Coupon, input *services.CouponInput) {
+	var clash int64
+	database.DB.Model(&models.Coupon{}).Where("code = ? AND id <> ?", input.Code, coupon.ID).Count(&clash)
+	if clash > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "coupon code already exists"})
+		return
+	}
+
+	input.Apply(coupon)
+	coupon.UpdatedAt = time.Now()
+	if err := database.DB.Save(coupon).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save coupon"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "coupon saved", "coupon": coupon})
+}
+
+func deactivateCoupon(c *gin.Context, q *gorm.DB) {
+	res := q.Model(&models.Coupon{}).Updates(map[string]interface{}{"is_active": false, "updated_at": time.Now()})
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove coupon"})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "coupon not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "coupon deactivated"})
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"Api/database"
+	"Api/models"
+	"Api/services"
+)
+
+// POST /api/user/transactions/:id/dispute
+// Multipart form: reason (required), evidence, and an optional attachment file.
+func OpenDisputeHandler(ctx *gin.Context) {
+	userID := ctx.GetUint("user_id")
+	transactionID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Invalid transaction id"})
+		return
+	}
+	reason := strings.TrimSpace(ctx.PostForm("reason"))
+	if reason == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "A reason is required"})
+		return
+	}
+
+	var attachmentPath string
+	if file, err := ctx.FormFile("attachment"); err == nil {
+		now := time.Now()
+		folder := fmt.Sprintf("uploads/user_%d/%d/%02d/%02d/disputes", userID, now.Year(), now.Month(), now.Day())
+		if err := os.MkdirAll(folder, os.ModePerm); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to save attachment"})
+			return
+		}
+		attachmentPath = filepath.Join(folder, fmt.Sprintf("%d_%s", now.UnixNano(), filepath.Base(file.Filename)))
+		if err := ctx.SaveUploadedFile(file, attachmentPath); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to save attachment"})
+			return
+		}
+	}
+
+	dispute, err := services.OpenBuyerDispute(uint(transactionID), userID, reason, ctx.PostForm("evidence"), attachmentPath)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrTransactionNotFound):
+			ctx.JSON(http.StatusNotFound, gin.H{"message": "Transaction not found"})
+		case errors.Is(err, services.ErrNotInEscrow), errors.Is(err, services.ErrDisputeWindowClosed):
+			ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		case errors.Is(err, services.ErrDisputeExists):
+			ctx.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to open dispute"})
+		}
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{"message": "Dispute opened", "dispute": dispute})
+}
+
+// GET /api/user/disputes
+func GetUserDisputes(ctx *gin.Context) {
+	var disputes []models.Dispute
+	if err := database.DB.Where("buyer_id = ?", ctx.GetUint("user_id")).Order("created_at DESC").Find(&disputes).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching disputes"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"disputes": disputes})
+}
+
+// GET /api/admin/disputes
+// Disputes raised against the creator's sales.
+func GetCreatorDisputes(ctx *gin.Context) {
+	var disputes []models.Dispute
+	if err := database.DB.Where("creator_id = ?", ctx.GetUint("user_id")).Order("created_at DESC").Find(&disputes).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching disputes", "details": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"disputes": disputes})
+}
+
+// GET /api/superadmin/disputes?status=open
+func GetAllDisputes(ctx *gin.Context) {
+	q := database.DB.Order("created_at DESC").Limit(200)
+	if status := ctx.Query("status"); status != "" {
+		q = q.Where("status = ?", status)
+	}
+	var disputes []models.Dispute
+	if err := q.Find(&disputes).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching disputes", "details": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"disputes": disputes})
+}
+
+// POST /api/superadmin/disputes/:id/resolve
+// Body: {"outcome": "release" | "refund", "ruling": "..."}
+func ResolveDisputeHandler(ctx *gin.Context) {
+	disputeID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid dispute id"})
+		return
+	}
+	var input struct {
+		Outcome string `json:"outcome" binding:"required"`
+		Ruling  string `json:"ruling" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+	if input.Outcome != "release" && input.Outcome != "refund" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "outcome must be 'release' or 'refund'"})
+		return
+	}
+
+	dispute, err := services.ResolveBuyerDispute(uint(disputeID), ctx.GetUint("user_id"), input.Outcome == "refund", input.Ruling)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrDisputeNotFound):
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "dispute not found"})
+		case errors.Is(err, services.ErrDisputeClosed):
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve dispute", "details": err.Error()})
+		}
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "Dispute resolved", "dispute": dispute})
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"Api/database"
+	"Api/models"
+	"Api/services"
+)
+
+// GET /api/exchange-rates
+// The stored rates, as units of each currency per US dollar.
+func GetExchangeRates(ctx *gin.Context) {
+	var rates []models.ExchangeRate
+	if err := database.DB.Order("currency").Find(&rates).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching exchange rates"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"base":       "USD",
+		"currencies": services.SupportedCurrencies,
+		"rates":      rates,
+	})
+}
+
+// PUT /api/superadmin/exchange-rates/:currency
+// Body: {"units_per_usd": 129.5}
+func SetExchangeRate(ctx *gin.Context) {
+	currency, err := services.NormalizeCurrency(ctx.Param("currency"), "")
+	if err != nil || currency == "USD" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "unsupported currency"})
+		return
+	}
+	var input struct {
+		UnitsPerUSD float64 `json:"units_per_usd" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	updatedBy := ctx.GetUint("user_id")
+	if err := services.SetExchangeRate(currency, input.UnitsPerUSD, "manual", &updatedBy); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "Exchange rate saved", "currency": currency, "units_per_usd": input.UnitsPerUSD})
+}
+
+// POST /api/superadmin/exchange-rates/import
+// Pulls fresh rates from EXCHANGE_RATES_URL.
+func ImportExchangeRates(ctx *gin.Context) {
+	if err := services.ImportExchangeRates(); err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": "Failed to import exchange rates", "details": err.Error()})
+		return
+	}
+	GetExchangeRates(ctx)
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"Api/database"
+	"Api/invoice"
+	"Api/models"
+)
+
+// GET /api/user/invoices
+// Receipts for everything the logged-in user has paid for.
+func GetUserInvoices(ctx *gin.Context) {
+	var invoices []models.Invoice
+	if err := database.DB.Where("user_id = ?", ctx.GetUint("user_id")).Order("issued_at DESC").Find(&invoices).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching invoices"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"invoices": invoices})
+}
+
+// GET /api/admin/invoices
+// Receipts for the logged-in creator's sales.
+func GetCreatorInvoices(ctx *gin.Context) {
+	var invoices []models.Invoice
+	if err := database.DB.Where("creator_id = ?", ctx.GetUint("user_id")).Order("issued_at DESC").Find(&invoices).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching invoices"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"invoices": invoices})
+}
+
+// GET /api/user/invoices/:id?format=pdf|html|json
+// Downloads a receipt as PDF by default. Both the buyer and the creator may fetch it.
+func DownloadInvoice(ctx *gin.Context) {
+	userID := ctx.GetUint("user_id")
+	var inv models.Invoice
+	if err := database.DB.Where("id = ? AND (user_id = ? OR creator_id = ?)", ctx.Param("id"), userID, userID).
+		First(&inv).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "Invoice not found"})
+		return
+	}
+
+	switch ctx.DefaultQuery("format", "pdf") {
+	case "json":
+		ctx.JSON(http.StatusOK, gin.H{"invoice": inv})
+	case "html":
+		body, err := invoice.RenderHTML(&inv)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to render invoice"})
+			return
+		}
+		ctx.Data(http.StatusOK, "text/html; charset=utf-8", body)
+	case "pdf":
+		ctx.Header("Content-Disposition", `attachment; filename="`+invoice.Filename(&inv, "pdf")+`"`)
+		ctx.Data(http.StatusOK, "application/pdf", invoice.RenderPDF(&inv))
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "format must be pdf, html or json"})
+	}
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"Api/database"
+	"Api/ledger"
+	"Api/models"
+	"Api/payments"
+	"Api/services"
+)
+
+// GET /api/superadmin/ledger/balances
+// Returns every ledger account with its balance, plus the trial balance totals.
+func GetLedgerBalances(ctx *gin.Context) {
+	var filter func(*gorm.DB) *gorm.DB
+	if code := ctx.Query("code"); code != "" {
+		filter = func(q *gorm.DB) *gorm.DB { return q.Where("code = ?", code) }
+	}
+	balances, err := ledger.Balances(filter)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching balances", "details": err.Error()})
+		return
+	}
+
+	var debits, credits int64
+	for _, b := range balances {
+		debits += b.Debits
+		credits += b.Credits
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"balances":      balances,
+		"total_debits":  debits,
+		"total_credits": credits,
+		"balanced":      debits == credits,
+	})
+}
+
+// GET /api/superadmin/ledger/entries?transaction_id=
+func GetLedgerEntries(ctx *gin.Context) {
+	q := database.DB.Preload("Lines").Order("created_at DESC").Limit(200)
+	if transactionID := ctx.Query("transaction_id"); transactionID != "" {
+		q = q.Where("transaction_id = ?", transactionID)
+	}
+	if kind := ctx.Query("kind"); kind != "" {
+		q = q.Where("kind = ?", kind)
+	}
+	var entries []models.JournalEntry
+	if err := q.Find(&entries).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching journal entries", "details": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+// GET /api/admin/balance?currency=KES
+// What the platform owes the logged-in creator, in subunits and in major units.
+func GetCreatorBalance(ctx *gin.Context) {
+	currency, err := services.NormalizeCurrency(ctx.Query("currency"), ledger.DefaultCurrency)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	balance, err := ledger.CreatorBalance(ctx.GetUint("user_id"), currency)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching balance", "details": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"currency":      currency,
+		"balance_minor": balance,
+		"balance":       payments.FromMinor(balance),
+	})
+}
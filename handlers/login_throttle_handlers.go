@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"Api/database"
+	"Api/internal/config"
+	"Api/models"
+	"Api/services"
+	"Api/utils"
+)
+
+var (
+	missingUserHashOnce sync.Once
+	missingUserHash     string
+)
+
+// loginThrottled answers 429 while the client IP or the email has to wait
+func loginThrottled(ctx *gin.Context, email string) bool {
+	err := services.CheckLoginAllowed(ctx.ClientIP(), email)
+	var throttled *services.LoginThrottledError
+	if !errors.As(err, &throttled) {
+		return false
+	}
+	respondLoginThrottled(ctx, throttled)
+	return true
+}
+
+func respondLoginThrottled(ctx *gin.Context, throttled *services.LoginThrottledError) {
+	seconds := int((throttled.RetryAfter + time.Second - 1) / time.Second)
+	ctx.Header("Retry-After", strconv.Itoa(seconds))
+	ctx.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       throttled.Error(),
+		"retry_after": seconds,
+		"locked":      throttled.Locked,
+	})
+}
+
+// rejectLogin counts the failure and gives the same answer for an unknown email
+// and a wrong password. For an unknown email pass the password typed, so it is
+// checked against a stand-in hash and the response takes as long either way.
+func rejectLogin(ctx *gin.Context, email, password string) {
+	if password != "" {
+		missingUserHashOnce.Do(func() {
+			missingUserHash, _ = utils.HashPassword("no account has this password")
+		})
+		utils.CheckPasswordHash(password, missingUserHash)
+	}
+	services.RecordLoginFailure(ctx.ClientIP(), email)
+	ctx.JSON(http.StatusUnauthorized, gin.H{"error": "invalid email or password"})
+}
+
+// GET /api/auth/unlock?token=...
+// The link in the lockout email; redirects to the sign-in page with the outcome.
+func UnlockAccountLinkHandler(ctx *gin.Context) {
+	target := config.Get().FrontendURL + "/auth?unlocked=1"
+	if _, err := services.UnlockAccountWithToken(ctx.Query("token")); err != nil {
+		target = config.Get().FrontendURL + "/auth?unlock_error=" + url.QueryEscape(err.Error())
+	}
+	ctx.Redirect(http.StatusFound, target)
+}
+
+// GET /api/superadmin/locked-accounts
+func GetLockedAccountsHandler(ctx *gin.Context) {
+	accounts, err := services.LockedAccounts()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch locked accounts"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"locked_accounts": accounts, "count": len(accounts)})
+}
+
+// POST /api/superadmin/locked-accounts/:id/unlock
+func UnlockAccountHandler(ctx *gin.Context) {
+	var user models.Person
+	if err := database.DB.First(&user, ctx.Param("id")).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if err := services.UnlockAccount(&user); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unlock account"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "account unlocked", "user_id": user.ID})
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"Api/database"
+	"Api/ledger"
+	"Api/models"
+	"Api/payments"
+	"Api/services"
+)
+
+// GET /api/admin/payouts?currency=KES
+// The creator's payout history, with what they can withdraw right now.
+func GetCreatorPayouts(ctx *gin.Context) {
+	creatorID := ctx.GetUint("user_id")
+	currency, err := services.NormalizeCurrency(ctx.Query("currency"), ledger.DefaultCurrency)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	available, err := services.AvailableForPayout(creatorID, currency)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching balance", "details": err.Error()})
+		return
+	}
+	var payouts []models.Payout
+	if err := database.DB.Where("creator_id = ?", creatorID).Order("created_at DESC").Find(&payouts).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching payouts", "details": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"currency":        currency,
+		"available_minor": available,
+		"available":       payments.FromMinor(available),
+		"minimum":         payments.FromMinor(services.PayoutMinimum(currency)),
+		"payouts":         payouts,
+	})
+}
+
+// POST /api/admin/payouts
+// Body: {"amount": 2500, "currency": "KES"}; currency defaults to KES
+func RequestPayout(ctx *gin.Context) {
+	var input struct {
+		Amount   float64 `json:"amount" binding:"required"`
+		Currency string  `json:"currency"`
+	}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+	currency, err := services.NormalizeCurrency(input.Currency, ledger.DefaultCurrency)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	payout, err := services.RequestPayout(ctx.GetUint("user_id"), payments.ToMinor(input.Amount), currency)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrPayoutBelowMinimum):
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "minimum": payments.FromMinor(services.PayoutMinimum(currency))})
+		case errors.Is(err, services.ErrInsufficientBalance), errors.Is(err, services.ErrPayoutDetailsMissing):
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request payout", "details": err.Error()})
+		}
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{"message": "Payout requested", "payout": payout})
+}
+
+// GET /api/superadmin/payouts?status=requested
+func GetAllPayouts(ctx *gin.Context) {
+	q := database.DB.Order("created_at DESC").Limit(200)
+	if status := ctx.Query("status"); status != "" {
+		q = q.Where("status = ?", status)
+	}
+	if creatorID := ctx.Query("creator_id"); creatorID != "" {
+		q = q.Where("creator_id = ?", creatorID)
+	}
+	var payouts []models.Payout
+	if err := q.Find(&payouts).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching payouts", "details": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"payouts": payouts})
+}
+
+// POST /api/superadmin/payouts/:id/approve
+func ApprovePayout(ctx *gin.Context) {
+	payoutID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid payout id"})
+		return
+	}
+
+	reviewerID := ctx.GetUint("user_id")
+	payout, err := services.ApprovePayout(uint(payoutID), reviewerID)
+	if errors.Is(err, services.ErrTransferUnconfirmed) {
+		log.Printf("Superadmin %d approved payout %s, transfer unconfirmed: %v", reviewerID, payout.Reference, err)
+		ctx.JSON(http.StatusAccepted, gin.H{"message": services.ErrTransferUnconfirmed.Error(), "payout": payout})
+		return
+	}
+	if err != nil {
+		respondPayoutError(ctx, err, "transfer failed")
+		return
+	}
+	if payout.Status == "failed" {
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": "transfer failed", "details": payout.FailureReason, "payout": payout})
+		return
+	}
+	log.Printf("Superadmin %d approved payout %s", reviewerID, payout.Reference)
+	ctx.JSON(http.StatusOK, gin.H{"message": "Payout approved", "payout": payout})
+}
+
+// POST /api/superadmin/payouts/:id/reject
+// Body: {"reason": "..."}
+func RejectPayout(ctx *gin.Context) {
+	payoutID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid payout id"})
+		return
+	}
+	var input struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	payout, err := services.RejectPayout(uint(payoutID), ctx.GetUint("user_id"), input.Reason)
+	if err != nil {
+		respondPayoutError(ctx, err, "Failed to reject payout")
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "Payout rejected", "payout": payout})
+}
+
+func respondPayoutError(ctx *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrPayoutNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "payout not found"})
+	case errors.Is(err, services.ErrPayoutState):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": fallback, "details": err.Error()})
+	}
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"Api/database"
+	"Api/models"
+	"Api/payments"
+	"Api/services"
+)
+
+// GET /api/superadmin/reconciliations
+func GetReconciliationReports(ctx *gin.Context) {
+	var reports []models.ReconciliationReport
+	if err := database.DB.Order("created_at DESC").Limit(100).Find(&reports).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching reports", "details": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"reports": reports})
+}
+
+// GET /api/superadmin/reconciliations/:id
+func GetReconciliationReport(ctx *gin.Context) {
+	var report models.ReconciliationReport
+	if err := database.DB.Preload("Items").First(&report, ctx.Param("id")).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "report not found"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"report": report})
+}
+
+// GET /api/superadmin/reconciliations/:id/download
+// Streams the report items as CSV.
+func DownloadReconciliationReport(ctx *gin.Context) {
+	var report models.ReconciliationReport
+	if err := database.DB.Preload("Items").First(&report, ctx.Param("id")).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "report not found"})
+		return
+	}
+
+	filename := fmt.Sprintf("reconciliation_%s_%s_%d.csv", report.Channel, report.From.Format("2006-01-02"), report.ID)
+	ctx.Header("Content-Type", "text/csv")
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	w := csv.NewWriter(ctx.Writer)
+	w.Write([]string{"reference", "transaction_id", "issue", "action", "local_status", "provider_status",
+		"local_amount", "provider_amount", "local_currency", "provider_currency", "detail"})
+	for _, item := range report.Items {
+		transactionID := ""
+		if item.TransactionID != nil {
+			transactionID = strconv.FormatUint(uint64(*item.TransactionID), 10)
+		}
+		w.Write([]string{
+			item.Reference,
+			transactionID,
+			item.Issue,
+			item.Action,
+			item.LocalStatus,
+			item.ProviderStatus,
+			fmt.Sprintf("%.2f", payments.FromMinor(item.LocalAmount)),
+			fmt.Sprintf("%.2f", payments.FromMinor(item.ProviderAmount)),
+			item.LocalCurrency,
+			item.ProviderCurrency,
+			item.Detail,
+		})
+	}
+	w.Flush()
+}
+
+// POST /api/superadmin/reconciliations
+// Runs a reconciliation now, by default for yesterday.
+func RunReconciliation(ctx *gin.Context) {
+	var input struct {
+		Channel string `json:"channel"`
+		From    string `json:"from"` // YYYY-MM-DD
+		To      string `json:"to"`   // YYYY-MM-DD, exclusive
+	}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	from := to.AddDate(0, 0, -1)
+	var err error
+	if input.From != "" {
+		if from, err = time.ParseInLocation("2006-01-02", input.From, now.Location()); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid from date"})
+			return
+		}
+	}
+	if input.To != "" {
+		if to, err = time.ParseInLocation("2006-01-02", input.To, now.Location()); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid to date"})
+			return
+		}
+	}
+	if !from.Before(to) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	provider := payments.Default()
+	if input.Channel != "" {
+		if provider, err = payments.Get(input.Channel); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	report, err := services.Reconcile(provider, from, to)
+	if report == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "reconciliation failed", "details": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"report": report})
+}
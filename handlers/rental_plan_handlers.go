@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"Api/database"
+	"Api/models"
+	"Api/services"
+)
+
+// GET /api/bots/:id/plans
+func ListRentalPlansHandler(c *gin.Context) {
+	var plans []models.RentalPlan
+	if err := database.DB.Where("bot_id = ? AND is_active = ?", c.Param("id"), true).Order("duration_days").Find(&plans).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch rental plans"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"plans": plans})
+}
+
+// POST /api/admin/bots/:id/plans
+func CreateRentalPlanHandler(c *gin.Context) {
+	bot, ok := ownedBot(c)
+	if !ok {
+		return
+	}
+
+	var input services.RentalPlanInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+	if err := input.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan := models.RentalPlan{
+		BotID:        bot.ID,
+		Name:         input.Name,
+		DurationDays: input.DurationDays,
+		Price:        input.Price,
+		IsActive:     true,
+	}
+	if err := database.DB.Create(&plan).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save rental plan"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "rental plan created", "plan": plan})
+}
+
+// PUT /api/admin/bots/:id/plans/:plan_id
+// Changes apply to new rentals and renewals; periods already paid for are kept.
+func UpdateRentalPlanHandler(c *gin.Context) {
+	bot, ok := ownedBot(c)
+	if !ok {
+		return
+	}
+
+	var plan models.RentalPlan
+	if err := database.DB.Where("id = ? AND bot_id = ?", c.Param("plan_id"), bot.ID).First(&plan).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rental plan not found"})
+		return
+	}
+
+	var input services.RentalPlanInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+	if err := input.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan.Name = input.Name
+	plan.DurationDays = input.DurationDays
+	plan.Price = input.Price
+	plan.IsActive = true
+	plan.UpdatedAt = time.Now()
+	if err := database.DB.Save(&plan).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update rental plan"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "rental plan updated", "plan": plan})
+}
+
+// DELETE /api/admin/bots/:id/plans/:plan_id
+// Plans are retired rather than deleted because transactions and subscriptions point at them.
+func DeleteRentalPlanHandler(c *gin.Context) {
+	bot, ok := ownedBot(c)
+	if !ok {
+		return
+	}
+
+	res := database.DB.Model(&models.RentalPlan{}).
+		Where("id = ? AND bot_id = ?", c.Param("plan_id"), bot.ID).
+		Updates(map[string]interface{}{"is_active": false, "updated_at": time.Now()})
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove rental plan"})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rental plan not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "rental plan removed"})
+}
+
+// ownedBot loads the bot in the :id param and checks the caller owns it
+func ownedBot(c *gin.Context) (*models.Bot, bool) {
+	var bot models.Bot
+	if err := database.DB.First(&bot, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "bot not found"})
+		return nil, false
+	}
+	if bot.OwnerID != c.GetUint("user_id") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not your bot"})
+		return nil, false
+	}
+	return &bot, true
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"Api/database"
+	"Api/models"
+	"Api/services"
+)
+
+// GET /api/user/subscriptions
+func GetUserSubscriptions(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var subscriptions []models.Subscription
+	if err := database.DB.Preload("Bot").Where("user_id = ?", userID).Order("created_at DESC").Find(&subscriptions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to fetch subscriptions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Subscriptions retrieved successfully",
+		"data":    subscriptions,
+	})
+}
+
+// POST /api/user/subscriptions/:id/cancel
+func CancelSubscription(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid subscription ID"})
+		return
+	}
+
+	sub, err := services.CancelSubscription(uint(id), userID)
+	switch {
+	case errors.Is(err, services.ErrSubscriptionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"message": "Subscription not found"})
+		return
+	case errors.Is(err, services.ErrSubscriptionClosed):
+		c.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to cancel subscription"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Subscription cancelled, your bot stays active until the end of the paid period",
+		"data":    sub,
+	})
+}
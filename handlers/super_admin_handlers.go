@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"Api/database"
+	"Api/internal/config"
 	"Api/models"
+	"Api/services"
 	"Api/utils"
 	"crypto/subtle"
 	"errors"
@@ -11,9 +13,9 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -22,11 +24,9 @@ import (
 	"gorm.io/gorm"
 )
 
-// Retrieve super admin secret from environment variable
-
 // POST /super/register
 func SuperAdminRegisterHandler(ctx *gin.Context) {
-	var superAdminSecret = os.Getenv("SUPER_ADMIN_SECRET")
+	superAdminSecret := config.Get().SuperAdminSecret
 	var payload struct {
 		Name     string `json:"name"`
 		Email    string `json:"email"`
@@ -39,8 +39,8 @@ func SuperAdminRegisterHandler(ctx *gin.Context) {
 		return
 	}
 
-	// Check secret using constant-time comparison
-	if subtle.ConstantTimeCompare([]byte(payload.Secret), []byte(superAdminSecret)) == 0 {
+	// Check secret using constant-time comparison; registration is closed without one
+	if superAdminSecret == "" || subtle.ConstantTimeCompare([]byte(payload.Secret), []byte(superAdminSecret)) == 0 {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
 	}
@@ -83,7 +83,7 @@ func SuperAdminRegisterHandler(ctx *gin.Context) {
 		Name:      payload.Name,
 		Email:     payload.Email,
 		Password:  hashedPassword,
-		Role:      "superadmin",
+		Role:      models.RoleSuperAdmin,
 		CreatedAt: utils.FormattedTime(time.Now()),
 		UpdatedAt: utils.FormattedTime(time.Now()),
 	}
@@ -93,14 +93,20 @@ func SuperAdminRegisterHandler(ctx *gin.Context) {
 		return
 	}
 
-	// Generate token
-	token, err := utils.GenerateToken(superAdmin.ID, superAdmin.Email)
+	// The account exists now; if the role requires 2FA it is set up before the first session
+	if respondWithLoginChallenge(ctx, &superAdmin) {
+		return
+	}
+	services.RecordLoginSuccess(payload.Email)
+
+	// Start a session for this device
+	tokens, err := services.StartSession(&superAdmin, ctx.Request.UserAgent(), ctx.ClientIP())
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"message": "superadmin registered", "token": token})
+	ctx.JSON(http.StatusOK, gin.H{"message": "superadmin registered", "token": tokens.AccessToken, "refresh_token": tokens.RefreshToken, "expires_in": tokens.ExpiresIn})
 }
 
 // POST /super/login
@@ -129,27 +135,40 @@ func SuperAdminLoginHandler(ctx *gin.Context) {
 		return
 	}
 
+	if loginThrottled(ctx, payload.Email) {
+		return
+	}
+
 	// Find super admin
 	var superAdmin models.Person
-	if err := database.DB.Where("email = ? AND role = ?", payload.Email, "superadmin").First(&superAdmin).Error; err != nil {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+	if err := database.DB.Where("email = ? AND role = ?", payload.Email, models.RoleSuperAdmin).First(&superAdmin).Error; err != nil {
+		rejectLogin(ctx, payload.Email, payload.Password)
 		return
 	}
 
 	// Check password
 	if !utils.CheckPasswordHash(payload.Password, superAdmin.Password) {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		rejectLogin(ctx, payload.Email, "")
+		return
+	}
+	if accountInactive(ctx, &superAdmin) {
 		return
 	}
 
-	// Generate token
-	token, err := utils.GenerateToken(superAdmin.ID, superAdmin.Email)
+	// Second factor, answered at /api/auth/2fa/verify
+	if respondWithLoginChallenge(ctx, &superAdmin) {
+		return
+	}
+	services.RecordLoginSuccess(payload.Email)
+
+	// Start a session for this device
+	tokens, err := services.StartSession(&superAdmin, ctx.Request.UserAgent(), ctx.ClientIP())
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"message": "login successful", "token": token})
+	ctx.JSON(http.StatusOK, gin.H{"message": "login successful", "token": tokens.AccessToken, "refresh_token": tokens.RefreshToken, "expires_in": tokens.ExpiresIn})
 }
 
 func SuperAdminProfileHandler(ctx *gin.Context) {
@@ -171,11 +190,6 @@ func SuperAdminProfileHandler(ctx *gin.Context) {
 		return
 	}
 
-	if user.Role != "superadmin" {
-		ctx.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
-		return
-	}
-
 	ctx.JSON(http.StatusOK, gin.H{
 		"id":    user.ID,
 		"name":  user.Name,
@@ -205,12 +219,6 @@ func SuperAdminDashboardHandler(ctx *gin.Context) {
 		return
 	}
 
-	// Verify role
-	if user.Role != "superadmin" {
-		ctx.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
-		return
-	}
-
 	// Return dashboard data (expand as needed)
 	ctx.JSON(http.StatusOK, gin.H{
 		"message": "Welcome to the SuperAdmin Dashboard",
@@ -264,15 +272,17 @@ func CreateUser(ctx *gin.Context) {
 		Name:      payload.Name,
 		Email:     payload.Email,
 		Password:  hashedPassword,
-		Role:      "User",
+		Role:      models.RoleUser,
 		CreatedAt: utils.FormattedTime(time.Now()),
 		UpdatedAt: utils.FormattedTime(time.Now()),
 	}
 
+	auditTarget(ctx, "user.create", "user", 0, nil)
 	if err := database.DB.Create(&user).Error; err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create user", "details": err.Error()})
 		return
 	}
+	auditResult(ctx, user.ID, &user)
 
 	ctx.JSON(http.StatusCreated, gin.H{
 		"message": "user created successfully",
@@ -283,24 +293,73 @@ func CreateUser(ctx *gin.Context) {
 
 // ✏️ Update user
 func UpdateUser(ctx *gin.Context) {
+	// Only profile fields can be edited here. Status changes go through
+	// SetAccountStatus, and email verification and 2FA belong to the user.
+	var input struct {
+		ID         uint   `json:"id"`
+		Name       string `json:"name"`
+		Email      string `json:"email" binding:"omitempty,email"`
+		Role       string `json:"role"`
+		Phone      string `json:"phone"`
+		Country    string `json:"country"`
+		TaxID      string `json:"tax_id"`
+		Membership string `json:"member_ship_type"`
+	}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	// POST /update-user carries the id in the body
 	id := ctx.Param("id")
+	if id == "" && input.ID != 0 {
+		id = strconv.FormatUint(uint64(input.ID), 10)
+	}
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "User id is required"})
+		return
+	}
 	var user models.Person
-
 	if err := database.DB.First(&user, id).Error; err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
-	var updateData models.Person
-	if err := ctx.ShouldBindJSON(&updateData); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
-		return
+	auditTarget(ctx, "user.update", "user", user.ID, &user)
+
+	if input.Name != "" {
+		user.Name = input.Name
+	}
+	if input.Email != "" {
+		user.Email = strings.ToLower(strings.TrimSpace(input.Email))
+	}
+	if input.Role != "" {
+		role, ok := models.ParseRole(input.Role)
+		if !ok {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "role must be user, admin or superadmin"})
+			return
+		}
+		user.Role = role
+	}
+	if input.Phone != "" {
+		user.Phone = input.Phone
+	}
+	if input.Country != "" {
+		user.Country = input.Country
+	}
+	if input.TaxID != "" {
+		user.TaxID = input.TaxID
+	}
+	if input.Membership != "" {
+		user.Membership = input.Membership
 	}
 
-	if err := database.DB.Model(&user).Updates(updateData).Error; err != nil {
+	if err := database.DB.Model(&user).Select("name", "email", "role", "phone", "country", "tax_id", "membership").
+		Updates(&user).Error; err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
 		return
 	}
+	auditResult(ctx, user.ID, &user)
 
 	ctx.JSON(http.StatusOK, gin.H{"message": "User updated successfully", "user": user})
 }
@@ -315,6 +374,7 @@ func DeleteUser(ctx *gin.Context) {
 		return
 	}
 
+	auditTarget(ctx, "user.delete", "user", user.ID, &user)
 	if err := database.DB.Delete(&user).Error; err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
 		return
@@ -425,10 +485,12 @@ func RejectUpgrade(ctx *gin.Context) {
 		return
 	}
 
+	auditTarget(ctx, "user.upgrade.reject", "user", user.ID, &user)
 	user.UpgradeRequestStatus = "rejected"
 	user.UpdatedAt = utils.FormattedTime(time.Now())
 
 	database.DB.Save(&user)
+	auditResult(ctx, user.ID, &user)
 
 	ctx.JSON(http.StatusOK, gin.H{"message": "User upgrade request rejected"})
 }
@@ -442,7 +504,7 @@ func RequestAdminUpgrade(ctx *gin.Context) {
 		return
 	}
 
-	if user.Role != "USER" {
+	if user.Role != models.RoleUser {
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Only normal users can request upgrade"})
 		return
 	}
@@ -472,11 +534,13 @@ func ApproveUpgrade(ctx *gin.Context) {
 		return
 	}
 
-	user.Role = "ADMIN"
+	auditTarget(ctx, "user.upgrade.approve", "user", user.ID, &user)
+	user.Role = models.RoleAdmin
 	user.UpgradeRequestStatus = "approved"
 	user.UpdatedAt = utils.FormattedTime(time.Now())
 
 	database.DB.Save(&user)
+	auditResult(ctx, user.ID, &user)
 
 	// 🔔 Send WebSocket notifications
 	messageToUser := fmt.Sprintf("🎉 Congratulations %s! Your admin upgrade request was approved.", user.Name)
@@ -485,6 +549,10 @@ func ApproveUpgrade(ctx *gin.Context) {
 	Hub.SendToUser(user.ID, messageToUser)
 	Hub.BroadcastToSuperAdmins(messageToSuperAdmins)
 
+	if err := services.SendUpgradeApproved(&user); err != nil {
+		log.Printf("Failed to email upgrade approval to user %d: %v", user.ID, err)
+	}
+
 	ctx.JSON(http.StatusOK, gin.H{"message": "User promoted to admin"})
 }
 
@@ -544,7 +612,7 @@ func ApproveUpgrade(ctx *gin.Context) {
 func GetAllAdmins(ctx *gin.Context) {
 	var admins []models.Person
 
-	if err := database.DB.Where("role IN ?", []string{"Admin", "Senior Admin", "Super Admin", "ADMIN"}).Find(&admins).Error; err != nil {
+	if err := database.DB.Where("role = ?", models.RoleAdmin).Find(&admins).Error; err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch admins"})
 		return
 	}
@@ -552,6 +620,8 @@ func GetAllAdmins(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"admins": admins})
 }
 
+// ToggleAdminStatus suspends an active admin until a superadmin lifts it, or
+// reactivates a suspended one. Body (optional): {"reason": "..."}
 func ToggleAdminStatus(ctx *gin.Context) {
 	id := ctx.Param("id")
 	var admin models.Person
@@ -561,18 +631,24 @@ func ToggleAdminStatus(ctx *gin.Context) {
 		return
 	}
 
-	if admin.UpgradeRequestStatus == "Active" || admin.UpgradeRequestStatus == "" {
-		admin.UpgradeRequestStatus = "Suspended"
-	} else {
-		admin.UpgradeRequestStatus = "Active"
+	var payload struct {
+		Reason string `json:"reason"`
 	}
+	ctx.ShouldBindJSON(&payload) // the body is optional
 
-	if err := database.DB.Save(&admin).Error; err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update status"})
+	status := models.AccountSuspended
+	if admin.CurrentStatus(time.Now()) != models.AccountActive {
+		status = models.AccountActive
+	} else if strings.TrimSpace(payload.Reason) == "" {
+		payload.Reason = "suspended by a superadmin"
+	}
+
+	updated, ok := updateAccountStatus(ctx, admin.ID, status, payload.Reason, nil)
+	if !ok {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"admin": admin})
+	ctx.JSON(http.StatusOK, gin.H{"admin": updated})
 }
 
 func DeleteAdmin(ctx *gin.Context) {
@@ -584,6 +660,7 @@ func DeleteAdmin(ctx *gin.Context) {
 		return
 	}
 
+	auditTarget(ctx, "admin.delete", "user", admin.ID, &admin)
 	if err := database.DB.Delete(&admin).Error; err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete admin"})
 		return
@@ -628,11 +705,12 @@ func CreateAdmin(ctx *gin.Context) {
 	}
 
 	// 👤 Create Person
+	auditTarget(ctx, "admin.create", "user", 0, nil)
 	person := models.Person{
 		Name:      payload.Name,
 		Email:     payload.Email,
 		Password:  hashedPassword,
-		Role:      "Admin",
+		Role:      models.RoleAdmin,
 		CreatedAt: utils.FormattedTime(time.Now()),
 		UpdatedAt: utils.FormattedTime(time.Now()),
 	}
@@ -650,6 +728,7 @@ func CreateAdmin(ctx *gin.Context) {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create admin record", "details": err.Error()})
 		return
 	}
+	auditResult(ctx, person.ID, &person)
 
 	// ✅ Response
 	ctx.JSON(http.StatusCreated, gin.H{
@@ -672,6 +751,8 @@ func UpdateAdmin(ctx *gin.Context) {
 		return
 	}
 
+	auditTarget(ctx, "admin.update", "user", admin.ID, &admin)
+
 	var input struct {
 		Name    string `json:"name"`
 		Email   string `json:"email" binding:"email"`
@@ -692,7 +773,12 @@ func UpdateAdmin(ctx *gin.Context) {
 		admin.Email = input.Email
 	}
 	if input.Role != "" {
-		admin.Role = input.Role
+		role, ok := models.ParseRole(input.Role)
+		if !ok {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "role must be user, admin or superadmin"})
+			return
+		}
+		admin.Role = role
 	}
 	if input.Phone != "" {
 		admin.Phone = input.Phone
@@ -705,6 +791,7 @@ func UpdateAdmin(ctx *gin.Context) {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update admin"})
 		return
 	}
+	auditResult(ctx, admin.ID, &admin)
 
 	ctx.JSON(http.StatusOK, gin.H{"admin": admin})
 }
@@ -763,6 +850,11 @@ func ScanAllBotsHandler(c *gin.Context) {
 	rootDir := "./uploads"
 	var invalidBots []map[string]interface{}
 
+	allowedAppIDs := map[string]bool{}
+	for _, id := range config.Get().DerivAppIDs {
+		allowedAppIDs[id] = true
+	}
+
 	// Regex to match app_id or appId assignments
 	re := regexp.MustCompile(`(?i)(app[_]?id)\s*[:=]\s*['"]?(\d+)['"]?`)
 
@@ -781,7 +873,7 @@ func ScanAllBotsHandler(c *gin.Context) {
 
 			matches := re.FindAllStringSubmatch(string(bytes), -1)
 			for _, m := range matches {
-				if len(m) > 2 && m[2] != "1089" {
+				if len(m) > 2 && !allowedAppIDs[m[2]] {
 					// Try to find bot record
 					var bot models.Bot
 					err := database.DB.Where("html_file = ?", path).First(&bot).Error
@@ -836,30 +928,6 @@ func ScanAllBotsHandler(c *gin.Context) {
 
 // GetAllTransactions retrieves all transactions for superadmin
 func GetAllTransactions(ctx *gin.Context) {
-	userID, exists := ctx.Get("user_id")
-	if !exists {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
-	}
-
-	userIDUint, ok := userID.(uint)
-	if !ok {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "invalid user id"})
-		return
-	}
-
-	// Verify superadmin role
-	var user models.Person
-	if err := database.DB.First(&user, userIDUint).Error; err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-		return
-	}
-
-	if user.Role != "superadmin" {
-		ctx.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
-		return
-	}
-
 	var transactions []models.Transaction
 	if err := database.DB.Order("created_at DESC").Find(&transactions).Error; err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
@@ -892,7 +960,13 @@ func GetAllTransactions(ctx *gin.Context) {
 	})
 }
 
-// RecordTransaction creates a new transaction
+// manualReferencePrefix marks references of hand-recorded transactions, so they
+// can never be mistaken for a checkout's reference
+const manualReferencePrefix = "MAN_"
+
+// RecordTransaction keeps a note of a sale made outside the platform. The record
+// is informational only: it never reaches the ledger, so it cannot change what a
+// creator is owed, and its reference is kept apart from checkout references.
 func RecordTransaction(ctx *gin.Context) {
 	userID, exists := ctx.Get("user_id")
 	if !exists {
@@ -906,18 +980,6 @@ func RecordTransaction(ctx *gin.Context) {
 		return
 	}
 
-	// Verify admin role
-	var user models.Person
-	if err := database.DB.First(&user, userIDUint).Error; err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-		return
-	}
-
-	if user.Role != "ADMIN" && user.Role != "superadmin" {
-		ctx.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
-		return
-	}
-
 	var input struct {
 		UserID         uint    `json:"user_id" binding:"required"`
 		BotID          uint    `json:"bot_id" binding:"required"`
@@ -948,6 +1010,17 @@ func RecordTransaction(ctx *gin.Context) {
 		return
 	}
 
+	reference := strings.TrimSpace(input.Reference)
+	if !strings.HasPrefix(reference, manualReferencePrefix) {
+		reference = manualReferencePrefix + reference
+	}
+	var existing int64
+	database.DB.Model(&models.Transaction{}).Where("reference = ?", reference).Count(&existing)
+	if existing > 0 {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "a transaction with this reference already exists"})
+		return
+	}
+
 	transaction := models.Transaction{
 		UserID:         input.UserID,
 		AdminID:        userIDUint,
@@ -955,15 +1028,17 @@ func RecordTransaction(ctx *gin.Context) {
 		Amount:         input.Amount,
 		CompanyShare:   input.CompanyShare,
 		AdminShare:     input.AdminShare,
-		Reference:      input.Reference,
+		Reference:      reference,
 		Status:         input.Status,
 		PaymentChannel: input.PaymentChannel,
 		PaymentType:    input.PaymentType,
 		Description:    input.Description,
+		Manual:         true,
 		CreatedAt:      time.Now(),
 		UpdatedAt:      time.Now(),
 	}
 
+	auditTarget(ctx, "transaction.record", "transaction", 0, nil)
 	if err := database.DB.Create(&transaction).Error; err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Error recording transaction",
@@ -972,5 +1047,47 @@ func RecordTransaction(ctx *gin.Context) {
 		return
 	}
 
+	auditResult(ctx, transaction.ID, &transaction)
 	ctx.JSON(http.StatusCreated, transaction)
 }
+
+// RefundTransaction refunds a payment through its provider and reverses the access it granted
+func RefundTransaction(ctx *gin.Context) {
+	userID := ctx.GetUint("user_id")
+
+	transactionID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid transaction id"})
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason" binding:"required"`
+		Manual bool   `json:"manual"` // money already returned outside the platform
+	}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	var before models.Transaction
+	if database.DB.First(&before, transactionID).Error == nil {
+		auditTarget(ctx, "transaction.refund", "transaction", before.ID, &before)
+	}
+	transaction, err := services.RefundOrder(uint(transactionID), input.Reason, input.Manual)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrTransactionNotFound):
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "transaction not found"})
+		case errors.Is(err, services.ErrNotRefundable), errors.Is(err, services.ErrRefundInProgress):
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusBadGateway, gin.H{"error": "refund failed", "details": err.Error()})
+		}
+		return
+	}
+
+	log.Printf("Superadmin %d refunded transaction %s: %s", userID, transaction.Reference, input.Reason)
+	auditResult(ctx, transaction.ID, transaction)
+	ctx.JSON(http.StatusOK, gin.H{"message": "Transaction refunded", "transaction": transaction})
+}
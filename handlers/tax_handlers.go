@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"Api/database"
+	"Api/models"
+	"Api/services"
+)
+
+// PUT /api/user/tax-details
+// Body: {"tax_id": "P051234567X"}; business buyers in reverse-charge countries
+// are then charged without tax.
+func UpdateTaxDetails(ctx *gin.Context) {
+	var input struct {
+		TaxID string `json:"tax_id"`
+	}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+	taxID := strings.ToUpper(strings.TrimSpace(input.TaxID))
+	if err := database.DB.Model(&models.Person{}).Where("id = ?", ctx.GetUint("user_id")).Update("tax_id", taxID).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to update tax details"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "Tax details updated", "tax_id": taxID})
+}
+
+// GET /api/superadmin/tax-rules
+func ListTaxRules(ctx *gin.Context) {
+	var rules []models.TaxRule
+	if err := database.DB.Order("country").Find(&rules).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch tax rules"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// POST /api/superadmin/tax-rules
+// Body: {"country": "Kenya", "name": "VAT", "rate": 16, "inclusive": false, "reverse_charge": true}
+func CreateTaxRule(ctx *gin.Context) {
+	saveTaxRule(ctx, &models.TaxRule{})
+}
+
+// PUT /api/superadmin/tax-rules/:id
+func UpdateTaxRule(ctx *gin.Context) {
+	var rule models.TaxRule
+	if err := database.DB.First(&rule, ctx.Param("id")).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "tax rule not found"})
+		return
+	}
+	saveTaxRule(ctx, &rule)
+}
+
+// DELETE /api/superadmin/tax-rules/:id
+// Past transactions keep the tax they were charged.
+func DeleteTaxRule(ctx *gin.Context) {
+	res := database.DB.Delete(&models.TaxRule{}, ctx.Param("id"))
+	if res.Error != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete tax rule"})
+		return
+	}
+	if res.RowsAffected == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "tax rule not found"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "tax rule deleted"})
+}
+
+func saveTaxRule(ctx *gin.Context, rule *models.TaxRule) {
+	var input services.TaxRuleInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+	if err := input.Validate(); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var clash int64
+	database.DB.Model(&models.TaxRule{}).Where("LOWER(country) = LOWER(?) AND id <> ?", input.Country, rule.ID).Count(&clash)
+	if clash > 0 {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "a tax rule for this country already exists"})
+		return
+	}
+
+	input.Apply(rule)
+	rule.UpdatedBy = ctx.GetUint("user_id")
+	rule.UpdatedAt = time.Now()
+	if err := database.DB.Save(rule).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save tax rule"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "tax rule saved", "rule": rule})
+}
+
+// GET /api/superadmin/tax-report?from=2026-07-01&to=2026-10-01&format=csv
+// Tax collected per jurisdiction; the period defaults to last calendar month.
+func GetTaxReport(ctx *gin.Context) {
+	now := time.Now()
+	to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	from := to.AddDate(0, -1, 0)
+	var err error
+	if v := ctx.Query("from"); v != "" {
+		if from, err = time.ParseInLocation("2006-01-02", v, now.Location()); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid from date"})
+			return
+		}
+	}
+	if v := ctx.Query("to"); v != "" {
+		if to, err = time.ParseInLocation("2006-01-02", v, now.Location()); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid to date"})
+			return
+		}
+	}
+	if !from.Before(to) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	rows, err := services.TaxReport(from, to)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error building tax report", "details": err.Error()})
+		return
+	}
+
+	if ctx.Query("format") != "csv" {
+		ctx.JSON(http.StatusOK, gin.H{
+			"from": from.Format("2006-01-02"),
+			"to":   to.Format("2006-01-02"),
+			"rows": rows,
+		})
+		return
+	}
+
+	filename := fmt.Sprintf("tax_report_%s_%s.csv", from.Format("2006-01-02"), to.Format("2006-01-02"))
+	ctx.Header("Content-Type", "text/csv")
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	w := csv.NewWriter(ctx.Writer)
+	w.Write([]string{"country", "tax_name", "currency", "transactions", "reverse_charge", "gross", "tax", "net"})
+	for _, row := range rows {
+		w.Write([]string{
+			row.Country,
+			row.TaxName,
+			row.Currency,
+			strconv.FormatInt(row.Transactions, 10),
+			strconv.FormatInt(row.ReverseCharge, 10),
+			fmt.Sprintf("%.2f", row.Gross),
+			fmt.Sprintf("%.2f", row.Tax),
+			fmt.Sprintf("%.2f", row.Net),
+		})
+	}
+	w.Flush()
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"Api/database"
+	"Api/models"
+	"Api/services"
+)
+
+// loginResponse is what a client receives once it holds a session
+func loginResponse(user *models.Person, tokens *services.AuthTokens) gin.H {
+	return gin.H{
+		"message":       "login successful",
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+		"role":          user.Role,       // "user", "admin" or "superadmin"
+		"membership":    user.Membership, // free, silver, gold, etc.
+		"user": gin.H{
+			"id":    user.ID,
+			"name":  user.Name,
+			"email": user.Email,
+		},
+		"last_login": time.Now(),
+	}
+}
+
+// respondWithLoginChallenge answers a correct password when a second factor is
+// still needed. It reports whether it wrote a response.
+func respondWithLoginChallenge(ctx *gin.Context, user *models.Person) bool {
+	challenge, err := services.StartLoginChallenge(user)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start login"})
+		return true
+	}
+	if challenge == nil {
+		return false
+	}
+	message := "enter the code from your authenticator app"
+	if challenge.SetupRequired {
+		message = "your role requires two-factor authentication, set it up to continue"
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":             message,
+		"two_factor_required": true,
+		"challenge_token":     challenge.Token,
+		"expires_in":          challenge.ExpiresIn,
+		"setup_required":      challenge.SetupRequired,
+	})
+	return true
+}
+
+// twoFactorError maps service errors onto responses
+func twoFactorError(ctx *gin.Context, err error, fallback string) {
+	var inactive *services.AccountStatusError
+	var throttled *services.LoginThrottledError
+	switch {
+	case errors.As(err, &inactive):
+		respondAccountInactive(ctx, inactive)
+	case errors.As(err, &throttled):
+		respondLoginThrottled(ctx, throttled)
+	case errors.Is(err, services.ErrInvalidChallenge):
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidTwoFactorCode):
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTwoFactorNotEnabled), errors.Is(err, services.ErrNoTOTPEnrolment):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTwoFactorAlreadyEnabled):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTwoFactorRequired):
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+// POST /api/auth/2fa/verify
+// Body: {"challenge_token": "...", "code": "123456"} or {"challenge_token": "...", "recovery_code": "abcde-fghij"}
+func VerifyLoginChallengeHandler(ctx *gin.Context) {
+	var payload struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+	if err := ctx.ShouldBindJSON(&payload); err != nil || (payload.Code == "" && payload.RecoveryCode == "") {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "challenge_token and a code or recovery_code are required"})
+		return
+	}
+	user, tokens, err := services.CompleteLoginChallenge(payload.ChallengeToken, payload.Code, payload.RecoveryCode,
+		ctx.Request.UserAgent(), ctx.ClientIP())
+	if err != nil {
+		twoFactorError(ctx, err, "failed to complete login")
+		return
+	}
+	ctx.JSON(http.StatusOK, loginResponse(user, tokens))
+}
+
+// POST /api/auth/2fa/setup
+// Body: {"challenge_token": "..."} from a login that answered setup_required.
+func ChallengeSetupHandler(ctx *gin.Context) {
+	var payload struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "challenge_token is required"})
+		return
+	}
+	enrolment, err := services.ChallengeEnrolment(payload.ChallengeToken)
+	if err != nil {
+		twoFactorError(ctx, err, "failed to start two-factor setup")
+		return
+	}
+	ctx.JSON(http.StatusOK, enrolment)
+}
+
+// POST /api/auth/2fa/setup/confirm
+// Body: {"challenge_token": "...", "code": "123456"}; enables 2FA and signs in.
+func ChallengeSetupConfirmHandler(ctx *gin.Context) {
+	var payload struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+		Code           string `json:"code" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "challenge_token and code are required"})
+		return
+	}
+	user, tokens, codes, err := services.ConfirmChallengeEnrolment(payload.ChallengeToken, payload.Code,
+		ctx.Request.UserAgent(), ctx.ClientIP())
+	if err != nil {
+		twoFactorError(ctx, err, "failed to enable two-factor authentication")
+		return
+	}
+	resp := loginResponse(user, tokens)
+	resp["recovery_codes"] = codes
+	ctx.JSON(http.StatusOK, resp)
+}
+
+func currentUser(ctx *gin.Context) (*models.Person, bool) {
+	var user models.Person
+	if err := database.DB.First(&user, ctx.GetUint("user_id")).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return nil, false
+	}
+	return &user, true
+}
+
+// GET /api/user/2fa
+func TwoFactorStatusHandler(ctx *gin.Context) {
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+	ctx.JSON(http.StatusOK, services.GetTwoFactorStatus(user))
+}
+
+// POST /api/user/2fa/setup
+// Returns a new secret and its otpauth:// URI; 2FA stays off until confirmed.
+func TwoFactorSetupHandler(ctx *gin.Context) {
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+	enrolment, err := services.BeginTOTPEnrolment(user)
+	if err != nil {
+		twoFactorError(ctx, err, "failed to start two-factor setup")
+		return
+	}
+	ctx.JSON(http.StatusOK, enrolment)
+}
+
+// POST /api/user/2fa/enable
+// Body: {"code": "123456"}; returns the recovery codes, which are not shown again.
+func TwoFactorEnableHandler(ctx *gin.Context) {
+	var payload struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return
+	}
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+	codes, err := services.ConfirmTOTPEnrolment(user, payload.Code)
+	if err != nil {
+		twoFactorError(ctx, err, "failed to enable two-factor authentication")
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "two-factor authentication enabled", "recovery_codes": codes})
+}
+
+// POST /api/user/2fa/disable
+// Body: {"code": "123456"} or {"recovery_code": "abcde-fghij"}
+func TwoFactorDisableHandler(ctx *gin.Context) {
+	var payload struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := ctx.ShouldBindJSON(&payload); err != nil || (payload.Code == "" && payload.RecoveryCode == "") {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "a code or recovery_code is required"})
+		return
+	}
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+	if err := services.DisableTOTP(user, payload.Code, payload.RecoveryCode); err != nil {
+		twoFactorError(ctx, err, "failed to disable two-factor authentication")
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
+
+// POST /api/user/2fa/recovery-codes
+// Body: {"code": "123456"}; replaces every recovery code.
+func RegenerateRecoveryCodesHandler(ctx *gin.Context) {
+	var payload struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return
+	}
+	user, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+	codes, err := services.RegenerateRecoveryCodes(user, payload.Code)
+	if err != nil {
+		twoFactorError(ctx, err, "failed to regenerate recovery codes")
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// GET /api/superadmin/2fa-policy
+func GetTwoFactorPoliciesHandler(ctx *gin.Context) {
+	policies, err := services.TwoFactorPolicies()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch two-factor policies"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"policies": policies})
+}
+
+// PUT /api/superadmin/2fa-policy/:role
+// Body: {"required": true}. Users of the role without 2FA set it up at their next login.
+func SetTwoFactorPolicyHandler(ctx *gin.Context) {
+	role, ok := models.ParseRole(ctx.Param("role"))
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "unknown role"})
+		return
+	}
+	var payload struct {
+		Required *bool `json:"required" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "required must be true or false"})
+		return
+	}
+	policy, err := services.SetTwoFactorPolicy(role, *payload.Required, ctx.GetUint("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save two-factor policy"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "two-factor policy updated", "policy": policy})
+}
@@ -2,11 +2,14 @@ package handlers
 
 import (
 	"Api/database"
+	"Api/internal/config"
 
 	"Api/models"
+	"Api/services"
 	"Api/utils"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"sort"
 	"strconv"
@@ -41,43 +44,40 @@ func LoginHandler(ctx *gin.Context) {
 		return
 	}
 
-	// Look up user
+	if loginThrottled(ctx, payload.Email) {
+		return
+	}
+
+	// Look up user and verify password; both failures look the same to the caller
 	var user models.Person
 	if err := database.DB.Where("email = ?", payload.Email).First(&user).Error; err != nil {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+		rejectLogin(ctx, payload.Email, payload.Password)
 		return
 	}
-
-	// Verify password
 	if !utils.CheckPasswordHash(payload.Password, user.Password) {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password"})
+		rejectLogin(ctx, payload.Email, "")
+		return
+	}
+	if accountInactive(ctx, &user) {
 		return
 	}
 
-	// Generate token
-	token, _ := utils.GenerateToken(user.ID, user.Email)
-
-	// Respond with user info, role, and membership
-	ctx.JSON(http.StatusOK, gin.H{
-		"message":    "login successful",
-		"token":      token,
-		"role":       user.Role,       // "User" or "Admin"
-		"membership": user.Membership, // free, silver, gold, etc.
-		"user": gin.H{
-			"id":    user.ID,
-			"name":  user.Name,
-			"email": user.Email,
-		},
-		"last_login": time.Now(),
-	})
+	// Accounts with 2FA answer a challenge before they get a session; the failed
+	// login counter is only cleared once the second factor checks out
+	if respondWithLoginChallenge(ctx, &user) {
+		return
+	}
+	services.RecordLoginSuccess(payload.Email)
 
-	fmt.Println("Login attempt:")
-	fmt.Println("Email:", payload.Email)
-	fmt.Println("Entered password:", payload.Password)
-	fmt.Println("Stored hash:", user.Password)
-	fmt.Println("Check result:", utils.CheckPasswordHash(payload.Password, user.Password))
-	fmt.Println("Role:", user.Role)
+	// Start a session for this device
+	tokens, err := services.StartSession(&user, ctx.Request.UserAgent(), ctx.ClientIP())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start session"})
+		return
+	}
 
+	// Respond with user info, role, and membership
+	ctx.JSON(http.StatusOK, loginResponse(&user, tokens))
 }
 
 // func SignupHandler(ctx *gin.Context) {
@@ -170,6 +170,7 @@ func ProfileHandler(ctx *gin.Context) {
 		"membership":     user.Membership,
 		"role":           user.Role,
 		"upgrade_status": upgradeMessage,
+		"email_verified": user.EmailVerifiedAt != nil,
 	})
 }
 
@@ -221,20 +222,17 @@ func ForgotPasswordHandler(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 		return
 	}
-	var user models.Person
-	if err := database.DB.Where("email = ?", payload.Email).First(&user).Error; err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+	email := strings.ToLower(strings.TrimSpace(payload.Email))
+	if email == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
 		return
 	}
-	resetToken := utils.GenerateResetToken()
-	user.ResetToken = resetToken
-	user.ResetExpiry = time.Now().Add(15 * time.Minute)
-	if err := database.DB.Save(&user).Error; err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save reset token", "details": err.Error()})
+	if err := services.RequestPasswordReset(email); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send reset email", "details": err.Error()})
 		return
 	}
-	// Normally send via email; returning for demo
-	ctx.JSON(http.StatusOK, gin.H{"message": "reset token generated", "reset_token": resetToken})
+	// Same answer whether or not the account exists
+	ctx.JSON(http.StatusOK, gin.H{"message": "if an account exists for that email, a reset link has been sent to it"})
 }
 
 func ResetPasswordHandler(ctx *gin.Context) {
@@ -246,6 +244,10 @@ func ResetPasswordHandler(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 		return
 	}
+	if payload.Token == "" || strings.TrimSpace(payload.NewPassword) == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "token and new_password are required"})
+		return
+	}
 	var user models.Person
 	if err := database.DB.Where("reset_token = ?", payload.Token).First(&user).Error; err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid reset token"})
@@ -262,6 +264,11 @@ func ResetPasswordHandler(ctx *gin.Context) {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset password", "details": err.Error()})
 		return
 	}
+	// Whoever knew the old password may still be signed in somewhere
+	if _, err := services.RevokeOtherSessions(user.ID, 0, "password reset"); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sign out other devices", "details": err.Error()})
+		return
+	}
 	ctx.JSON(http.StatusOK, gin.H{"message": "password reset successful"})
 }
 
@@ -334,6 +341,7 @@ func ServeBotHandler(c *gin.Context) {
 // 	})
 // }
 
+// MarketplaceHandler lists bots; with ?currency= prices are also shown converted
 func MarketplaceHandler(c *gin.Context) {
 	// Get logged-in user ID
 	userIDVal, exists := c.Get("user_id")
@@ -366,9 +374,12 @@ func MarketplaceHandler(c *gin.Context) {
 	var bots []models.Bot
 	var total int64
 
-	database.DB.Model(&models.Bot{}).Count(&total)
+	// Bots of suspended, banned or deleted creators are not listed
+	database.DB.Model(&models.Bot{}).Where("owner_id NOT IN (?)", services.InactiveAccountIDs()).Count(&total)
 
 	if err := database.DB.
+		Where("owner_id NOT IN (?)", services.InactiveAccountIDs()).
+		Preload("RentalPlans", "is_active = ?", true).
 		Order("created_at desc").
 		Limit(limit).
 		Offset(offset).
@@ -397,19 +408,26 @@ func MarketplaceHandler(c *gin.Context) {
 	// -----------------------------
 	// 🧱 Build custom bot list
 	// -----------------------------
+	currency := c.Query("currency")
 	var botList []gin.H
-	for _, b := range bots {
+	for i, b := range bots {
 		botPath := strings.TrimPrefix(b.HTMLFile, "uploads/")
-		botList = append(botList, gin.H{
-			"id":          b.ID,
-			"name":        b.Name,
-			"image":       b.Image,
-			"price":       b.Price,
-			"strategy":    b.Strategy,
-			"status":      b.Status,
-			"bot_link":    fmt.Sprintf("http://localhost:8080/uploads/%s", botPath),
-			"is_favorite": favoriteMap[b.ID],
-		})
+		entry := gin.H{
+			"id":           b.ID,
+			"name":         b.Name,
+			"image":        b.Image,
+			"price":        b.Price,
+			"currency":     services.BotCurrency(&b),
+			"rental_plans": b.RentalPlans,
+			"strategy":     b.Strategy,
+			"status":       b.Status,
+			"bot_link":     fmt.Sprintf("%s/uploads/%s", config.Get().PublicURL, botPath),
+			"is_favorite":  favoriteMap[b.ID],
+		}
+		if currency != "" {
+			entry["display"] = displayPrices(&bots[i], currency)
+		}
+		botList = append(botList, entry)
 	}
 
 	// -----------------------------
@@ -657,12 +675,26 @@ func SignupHandler(ctx *gin.Context) {
 		return
 	}
 
-	// 4️⃣ Generate JWT token
-	token, _ := utils.GenerateToken(user.ID, user.Email)
+	// 4️⃣ Email a verification link; the account works without it, purchases do not
+	verificationSent := true
+	if err := services.SendVerificationEmail(&user); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+		verificationSent = false
+	}
+
+	// 5️⃣ Start a session for this device
+	tokens, err := services.StartSession(&user, ctx.Request.UserAgent(), ctx.ClientIP())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start session"})
+		return
+	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": "signup successful",
-		"token":   token,
-		"country": country,
+		"message":           "signup successful",
+		"token":             tokens.AccessToken,
+		"refresh_token":     tokens.RefreshToken,
+		"expires_in":        tokens.ExpiresIn,
+		"country":           country,
+		"verification_sent": verificationSent,
 	})
 }
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"Api/database"
+	"Api/models"
+	"Api/payments"
+	"Api/services"
+)
+
+// GET /api/user/wallet
+// Balances per currency, with the funds currently held for payments in progress.
+func GetWallet(ctx *gin.Context) {
+	userID := ctx.GetUint("user_id")
+	wallets, err := services.GetWallets(userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching wallet"})
+		return
+	}
+	balances := make([]gin.H, 0, len(wallets))
+	for i := range wallets {
+		w := &wallets[i]
+		balances = append(balances, gin.H{
+			"currency":  w.Currency,
+			"balance":   payments.FromMinor(w.Balance),
+			"held":      payments.FromMinor(w.Held),
+			"available": payments.FromMinor(services.Available(w)),
+		})
+	}
+	var holds []models.WalletHold
+	database.DB.Where("user_id = ? AND status = ?", userID, "held").Order("created_at DESC").Find(&holds)
+	ctx.JSON(http.StatusOK, gin.H{"wallets": balances, "holds": holds})
+}
+
+// GET /api/user/wallet/entries?currency=KES&page=1&limit=20
+func GetWalletEntries(ctx *gin.Context) {
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	q := database.DB.Model(&models.WalletEntry{}).Where("wallet_entries.user_id = ?", ctx.GetUint("user_id"))
+	if currency := ctx.Query("currency"); currency != "" {
+		q = q.Joins("JOIN wallets ON wallets.id = wallet_entries.wallet_id").Where("wallets.currency = ?", currency)
+	}
+	var total int64
+	q.Count(&total)
+	var entries []models.WalletEntry
+	if err := q.Order("wallet_entries.created_at DESC").Limit(limit).Offset((page - 1) * limit).Find(&entries).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching wallet history"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"page": page, "limit": limit, "total": total, "entries": entries})
+}
+
+// POST /api/user/wallet/topup
+// Body: {"amount": 5000, "currency": "KES", "channel": "M-Pesa", "phone": "2547..."}
+// channel defaults to the default provider; phone is only needed for M-Pesa.
+func TopUpWallet(ctx *gin.Context) {
+	var input struct {
+		Amount   float64 `json:"amount" binding:"required"`
+		Currency string  `json:"currency"`
+		Channel  string  `json:"channel"`
+		Phone    string  `json:"phone"`
+	}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+	currency, err := services.NormalizeCurrency(input.Currency, "KES")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	var user models.Person
+	if err := database.DB.First(&user, ctx.GetUint("user_id")).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "User not found"})
+		return
+	}
+
+	result, err := services.StartWalletTopUp(&user, input.Amount, currency, input.Channel, input.Phone)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "Top-up initialized", "data": result})
+}
+
+// POST /api/user/wallet/pay
+// Body: {"bot_id": 1, "payment_type": "rent", "plan_id": 2, "coupon_code": "", "currency": "KES"}
+func PayFromWallet(ctx *gin.Context) {
+	var input services.WalletCheckout
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+
+	fulfilment, err := services.PayWithWallet(ctx.GetUint("user_id"), input)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInsufficientFunds):
+			ctx.JSON(http.StatusPaymentRequired, gin.H{"message": "Insufficient wallet balance, please top up"})
+		case errors.Is(err, services.ErrBotNotFound):
+			ctx.JSON(http.StatusNotFound, gin.H{"message": "Bot not found"})
+		case errors.Is(err, services.ErrSellerUnavailable):
+			ctx.JSON(http.StatusForbidden, gin.H{"message": err.Error()})
+		default:
+			ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		}
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "Payment completed from wallet", "fulfilment": fulfilment})
+}
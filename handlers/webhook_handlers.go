@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"Api/database"
+	"Api/models"
+	"Api/services"
+)
+
+// GET /api/superadmin/webhook-events?provider=Paystack&status=failed&reference=...&page=1&limit=50
+// Payloads are left out of the list, fetch a single event to see one.
+func ListWebhookEvents(ctx *gin.Context) {
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	q := database.DB.Model(&models.WebhookEvent{})
+	for _, field := range []string{"provider", "status", "type", "reference"} {
+		if v := ctx.Query(field); v != "" {
+			q = q.Where(field+" = ?", v)
+		}
+	}
+	var total int64
+	q.Count(&total)
+	var events []models.WebhookEvent
+	if err := q.Omit("payload").Order("created_at DESC").Limit(limit).Offset((page - 1) * limit).Find(&events).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching webhook events", "details": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"page": page, "limit": limit, "total": total, "events": events})
+}
+
+// GET /api/superadmin/webhook-events/:id
+func GetWebhookEvent(ctx *gin.Context) {
+	var event models.WebhookEvent
+	if err := database.DB.First(&event, ctx.Param("id")).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "webhook event not found"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"event": event})
+}
+
+// POST /api/superadmin/webhook-events/:id/replay
+// Runs a stored event through its processor again, e.g. after a bug fix.
+func ReplayWebhookEvent(ctx *gin.Context) {
+	eventID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid event id"})
+		return
+	}
+
+	event, err := services.ReplayWebhook(uint(eventID), ctx.GetUint("user_id"))
+	switch {
+	case errors.Is(err, services.ErrWebhookNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "webhook event not found"})
+	case errors.Is(err, services.ErrWebhookUnsigned):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Replay failed", "details": err.Error(), "event": event})
+	default:
+		ctx.JSON(http.StatusOK, gin.H{"message": "Event replayed", "event": event})
+	}
+}
@@ -1 +1,312 @@
+// Package config loads the application's settings once at startup.
+//
+// Values come from the environment. Outside production a local .env file is read
+// first, then the profile for the environment (config/<env>.env, or CONFIG_DIR);
+// neither overrides a variable that is already set, so real environment variables
+// always win. Profiles hold non-secret defaults only and are committed.
 package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// Environments a profile can be written for
+const (
+	Development = "development"
+	Staging     = "staging"
+	Production  = "production"
+)
+
+// devJWTSecret signs tokens in development when JWT_SECRET is not set
+const devJWTSecret = "algocdk-development-only-jwt-secret"
+
+// Config is the typed application configuration. Fields tagged secret are never
+// printed; Summary shows only whether they are set.
+type Config struct {
+	Env         string `env:"APP_ENV,ENV" default:"development"`
+	Port        string `env:"PORT" default:"8080"`
+	GinMode     string `env:"GIN_MODE" default:"debug"`
+	PublicURL   string `env:"PUBLIC_URL"`   // where this server is reached, defaults to http://localhost:PORT
+	FrontendURL string `env:"FRONTEND_URL"` // where the web app is served, defaults to PublicURL
+
+	DatabaseURL      string `env:"DATABASE_URL" secret:"true"`
+	JWTSecret        string `env:"JWT_SECRET" secret:"true"`
+	SuperAdminSecret string `env:"SUPER_ADMIN_SECRET" secret:"true"`
+
+	// DerivAppIDs are the Deriv app ids uploaded bots may connect with
+	DerivAppIDs []string `env:"DERIV_APP_IDS" default:"1089"`
+
+	PaymentProvider string `env:"PAYMENT_PROVIDER" default:"paystack"` // "paystack" or "fake"
+	Paystack        Paystack
+	Mpesa           Mpesa
+	SMTP            SMTP
+	Invoice         Invoice
+
+	ExchangeRatesURL  string  `env:"EXCHANGE_RATES_URL"`
+	EscrowDisputeDays int     `env:"ESCROW_DISPUTE_DAYS"`
+	PayoutMinimum     float64 `env:"PAYOUT_MINIMUM"` // in shillings; 0 keeps the built-in minimum
+
+	// LoginAttemptStore keeps failed login counters in "database", or in "memory"
+	// for single-node deployments
+	LoginAttemptStore string `env:"LOGIN_ATTEMPT_STORE" default:"database"`
+}
+
+type Paystack struct {
+	BaseURL     string `env:"PAYSTACK_BASE_URL"`
+	SecretKey   string `env:"PAYSTACK_SECRET_KEY" secret:"true"`
+	CallbackURL string `env:"PAYSTACK_CALLBACK_URL"`
+}
+
+type Mpesa struct {
+	BaseURL            string `env:"MPESA_BASE_URL"`
+	ConsumerKey        string `env:"MPESA_CONSUMER_KEY" secret:"true"`
+	ConsumerSecret     string `env:"MPESA_CONSUMER_SECRET" secret:"true"`
+	ShortCode          string `env:"MPESA_SHORTCODE"`
+	PassKey            string `env:"MPESA_PASSKEY" secret:"true"`
+	CallbackURL        string `env:"MPESA_CALLBACK_URL"`
+	CallbackSecret     string `env:"MPESA_CALLBACK_SECRET" secret:"true"`
+	InitiatorName      string `env:"MPESA_INITIATOR_NAME"`
+	SecurityCredential string `env:"MPESA_SECURITY_CREDENTIAL" secret:"true"`
+	B2CShortCode       string `env:"MPESA_B2C_SHORTCODE"`
+	B2CResultURL       string `env:"MPESA_B2C_RESULT_URL"`
+	B2CTimeoutURL      string `env:"MPESA_B2C_TIMEOUT_URL"`
+}
+
+type SMTP struct {
+	Host     string `env:"SMTP_HOST"`
+	Port     string `env:"SMTP_PORT" default:"587"`
+	Username string `env:"SMTP_USERNAME"`
+	Password string `env:"SMTP_PASSWORD" secret:"true"`
+	From     string `env:"MAIL_FROM"`
+
+	// Backend is "smtp", "file" or "log"; empty picks smtp when SMTP_HOST is set
+	Backend string `env:"MAIL_BACKEND"`
+	Dir     string `env:"MAIL_DIR" default:"mail"` // where the file backend writes .eml files
+}
+
+type Invoice struct {
+	IssuerName    string `env:"INVOICE_ISSUER_NAME" default:"AlgoCDK"`
+	IssuerAddress string `env:"INVOICE_ISSUER_ADDRESS"`
+}
+
+var current *Config
+
+// Get returns the configuration loaded by Load
+func Get() *Config {
+	if current == nil {
+		panic("config: Get called before Load")
+	}
+	return current
+}
+
+// Load reads the profile files and the environment, validates the result and makes
+// it available through Get.
+func Load() (*Config, error) {
+	env := lookup("APP_ENV", "ENV")
+	if env != Production {
+		if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("reading .env: %w", err)
+		}
+		// .env may itself choose the environment
+		env = lookup("APP_ENV", "ENV")
+	}
+	if env == "" {
+		env = Development
+	}
+
+	dir := os.Getenv("CONFIG_DIR")
+	if dir == "" {
+		dir = "config"
+	}
+	profile := filepath.Join(dir, env+".env")
+	if err := godotenv.Load(profile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading profile %s: %w", profile, err)
+	}
+
+	cfg := &Config{}
+	if err := fill(reflect.ValueOf(cfg).Elem()); err != nil {
+		return nil, err
+	}
+	cfg.applyDefaults()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	current = cfg
+	return cfg, nil
+}
+
+func (c *Config) applyDefaults() {
+	c.Env = strings.ToLower(c.Env)
+	if c.PublicURL == "" {
+		c.PublicURL = "http://localhost:" + c.Port
+	}
+	c.PublicURL = strings.TrimRight(c.PublicURL, "/")
+	if c.FrontendURL == "" {
+		c.FrontendURL = c.PublicURL
+	}
+	c.FrontendURL = strings.TrimRight(c.FrontendURL, "/")
+	if c.JWTSecret == "" && c.Env == Development {
+		log.Println("⚠️ JWT_SECRET not set, using the development secret")
+		c.JWTSecret = devJWTSecret
+	}
+}
+
+// Validate reports every problem at once so a bad deploy is fixed in one go
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Env == Development || c.Env == Staging || c.Env == Production,
+		"APP_ENV must be %s, %s or %s, got %q", Development, Staging, Production, c.Env)
+	check(c.DatabaseURL != "", "DATABASE_URL is required")
+	check(c.JWTSecret != "", "JWT_SECRET is required")
+	if c.Env != Development {
+		check(len(c.JWTSecret) >= 32, "JWT_SECRET must be at least 32 characters")
+		check(c.JWTSecret != devJWTSecret, "JWT_SECRET must not be the development secret")
+	}
+	check(c.PaymentProvider == "paystack" || c.PaymentProvider == "fake",
+		"PAYMENT_PROVIDER must be paystack or fake, got %q", c.PaymentProvider)
+	check(c.PaymentProvider != "paystack" || c.Paystack.SecretKey != "" || c.Env == Development,
+		"PAYSTACK_SECRET_KEY is required")
+	check(c.Env != Production || c.PaymentProvider != "fake", "the fake payment provider cannot be used in production")
+	// Daraja callbacks carry no signature, only the token appended to their URL
+	check(c.Env == Development || c.PaymentProvider == "fake" || c.Mpesa.ConsumerKey == "" || c.Mpesa.CallbackSecret != "",
+		"MPESA_CALLBACK_SECRET is required when M-Pesa is configured")
+	check(len(c.DerivAppIDs) > 0, "DERIV_APP_IDS needs at least one app id")
+	check(c.EscrowDisputeDays >= 0, "ESCROW_DISPUTE_DAYS cannot be negative")
+	check(c.PayoutMinimum >= 0, "PAYOUT_MINIMUM cannot be negative")
+	check(c.LoginAttemptStore == "database" || c.LoginAttemptStore == "memory",
+		"LOGIN_ATTEMPT_STORE must be database or memory, got %q", c.LoginAttemptStore)
+	if c.SMTP.Host != "" {
+		_, err := strconv.Atoi(c.SMTP.Port)
+		check(err == nil, "SMTP_PORT must be a number, got %q", c.SMTP.Port)
+	}
+	check(c.SMTP.Backend == "" || c.SMTP.Backend == "smtp" || c.SMTP.Backend == "file" || c.SMTP.Backend == "log",
+		"MAIL_BACKEND must be smtp, file or log, got %q", c.SMTP.Backend)
+	check(c.SMTP.Backend != "smtp" || c.SMTP.Host != "", "MAIL_BACKEND=smtp needs SMTP_HOST")
+	for _, setting := range [][2]string{
+		{"PUBLIC_URL", c.PublicURL},
+		{"FRONTEND_URL", c.FrontendURL},
+		{"EXCHANGE_RATES_URL", c.ExchangeRatesURL},
+		{"MPESA_BASE_URL", c.Mpesa.BaseURL},
+	} {
+		if setting[1] == "" {
+			continue
+		}
+		u, err := url.Parse(setting[1])
+		check(err == nil && u.Scheme != "" && u.Host != "", "%s must be an absolute URL", setting[0])
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Summary lists every setting for the startup log, with secrets reduced to
+// whether they are set
+func (c *Config) Summary() []string {
+	var lines []string
+	walk(reflect.ValueOf(c).Elem(), func(f reflect.StructField, v reflect.Value) {
+		name := strings.Split(f.Tag.Get("env"), ",")[0]
+		value := fmt.Sprint(v.Interface())
+		if v.Kind() == reflect.Slice {
+			value = strings.Join(v.Interface().([]string), ",")
+		}
+		if f.Tag.Get("secret") == "true" {
+			value = "(not set)"
+			if !v.IsZero() {
+				value = "(set)"
+			}
+		}
+		lines = append(lines, name+"="+value)
+	})
+	return lines
+}
+
+func lookup(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return strings.ToLower(v)
+		}
+	}
+	return ""
+}
+
+// walk calls fn for every field with an env tag, descending into nested structs
+func walk(v reflect.Value, fn func(reflect.StructField, reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f, fv := t.Field(i), v.Field(i)
+		if f.Type.Kind() == reflect.Struct {
+			walk(fv, fn)
+			continue
+		}
+		if f.Tag.Get("env") != "" {
+			fn(f, fv)
+		}
+	}
+}
+
+func fill(v reflect.Value) error {
+	var errs []error
+	walk(v, func(f reflect.StructField, fv reflect.Value) {
+		names := strings.Split(f.Tag.Get("env"), ",")
+		raw := ""
+		for _, name := range names {
+			if raw = strings.TrimSpace(os.Getenv(name)); raw != "" {
+				break
+			}
+		}
+		if raw == "" {
+			raw = f.Tag.Get("default")
+		}
+		if raw == "" {
+			return
+		}
+		switch fv.Kind() {
+		case reflect.String:
+			fv.SetString(raw)
+		case reflect.Int:
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be a whole number, got %q", names[0], raw))
+				return
+			}
+			fv.SetInt(int64(n))
+		case reflect.Float64:
+			n, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be a number, got %q", names[0], raw))
+				return
+			}
+			fv.SetFloat(n)
+		case reflect.Slice:
+			var items []string
+			for _, item := range strings.Split(raw, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			fv.Set(reflect.ValueOf(items))
+		}
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+// Package invoice renders issued invoices as HTML and PDF receipts.
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+
+	"Api/internal/config"
+	"Api/models"
+)
+
+// Line is one labelled amount on a receipt
+type Line struct {
+	Label  string
+	Amount string
+	Bold   bool
+}
+
+// Issuer is the business named at the top of every receipt, from INVOICE_ISSUER_NAME
+// and INVOICE_ISSUER_ADDRESS
+func Issuer() (name, address string) {
+	cfg := config.Get().Invoice
+	return cfg.IssuerName, cfg.IssuerAddress
+}
+
+// Description names what was paid for, e.g. "Rental of Scalper X (weekly, 7 days)"
+func Description(inv *models.Invoice) string {
+	if inv.PaymentType == "rent" {
+		plan := inv.PlanName
+		if plan == "" {
+			plan = "rental"
+		}
+		return fmt.Sprintf("Rental of %s (%s, %d days)", inv.BotName, plan, inv.RentalDays)
+	}
+	return "Purchase of " + inv.BotName
+}
+
+// Totals lists the price breakdown shown on the receipt
+func Totals(inv *models.Invoice) []Line {
+	money := func(v float64) string { return Money(inv.Currency, v) }
+	var lines []Line
+	if inv.Discount > 0 {
+		lines = append(lines,
+			Line{Label: "List price", Amount: money(inv.ListPrice)},
+			Line{Label: "Discount (" + inv.CouponCode + ")", Amount: "-" + money(inv.Discount)},
+		)
+	}
+	if inv.TaxCountry != "" {
+		name := inv.TaxName
+		if name == "" {
+			name = "Tax"
+		}
+		label := fmt.Sprintf("%s %.2f%%", name, inv.TaxRate)
+		switch {
+		case inv.ReverseCharge:
+			label = name + " (reverse charge)"
+		case inv.TaxInclusive:
+			label += " (included)"
+		}
+		lines = append(lines,
+			Line{Label: "Subtotal", Amount: money(inv.Total - inv.Tax)},
+			Line{Label: label, Amount: money(inv.Tax)},
+		)
+	}
+	lines = append(lines, Line{Label: "Total paid", Amount: money(inv.Total), Bold: true})
+	return lines
+}
+
+// TaxNote explains how tax was handled, or is empty when there is nothing to say
+func TaxNote(inv *models.Invoice) string {
+	if inv.ReverseCharge {
+		return fmt.Sprintf("Reverse charge: %s is to be accounted for by the buyer (%s).", inv.TaxName, inv.BuyerTaxID)
+	}
+	return ""
+}
+
+// Split lists how the payment was shared between the platform and the creator
+func Split(inv *models.Invoice) []Line {
+	return []Line{
+		{Label: "Platform commission", Amount: Money(inv.Currency, inv.CompanyShare)},
+		{Label: "Creator share (" + inv.CreatorName + ")", Amount: Money(inv.Currency, inv.CreatorShare)},
+	}
+}
+
+// Money formats an amount with its currency and thousands separators
+func Money(currency string, v float64) string {
+	s := fmt.Sprintf("%.2f", v)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	whole, frac := s[:len(s)-3], s[len(s)-3:]
+	var b strings.Builder
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
+	}
+	if neg {
+		return fmt.Sprintf("-%s %s%s", currency, b.String(), frac)
+	}
+	return fmt.Sprintf("%s %s%s", currency, b.String(), frac)
+}
+
+// Filename is the download name for a rendered receipt
+func Filename(inv *models.Invoice, ext string) string {
+	return inv.Number + "." + ext
+}
+
+var htmlTemplate = template.Must(template.New("invoice").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Receipt {{.Invoice.Number}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; color: #222; max-width: 640px; margin: 24px auto; }
+h1 { font-size: 22px; margin-bottom: 0; }
+table { width: 100%; border-collapse: collapse; margin-top: 16px; }
+td { padding: 6px 0; border-bottom: 1px solid #eee; }
+td.amount { text-align: right; }
+.bold td { font-weight: bold; }
+.muted { color: #666; font-size: 13px; }
+</style>
+</head>
+<body>
+<h1>Receipt</h1>
+<p class="muted">{{.IssuerName}}{{if .IssuerAddress}}<br>{{.IssuerAddress}}{{end}}</p>
+<table>
+<tr><td>Receipt number</td><td class="amount">{{.Invoice.Number}}</td></tr>
+<tr><td>Date paid</td><td class="amount">{{.Invoice.PaidAt.Format "02 Jan 2006 15:04 MST"}}</td></tr>
+<tr><td>Billed to</td><td class="amount">{{.Invoice.BuyerName}}<br>{{.Invoice.BuyerEmail}}{{if .Invoice.BuyerTaxID}}<br>Tax ID {{.Invoice.BuyerTaxID}}{{end}}</td></tr>
+<tr><td>Payment method</td><td class="amount">{{.Invoice.PaymentChannel}}</td></tr>
+<tr><td>Reference</td><td class="amount">{{.Invoice.Reference}}{{if .Invoice.ProviderRef}} / {{.Invoice.ProviderRef}}{{end}}</td></tr>
+</table>
+<table>
+<tr class="bold"><td>{{.Description}}</td><td class="amount"></td></tr>
+{{range .Totals}}<tr{{if .Bold}} class="bold"{{end}}><td>{{.Label}}</td><td class="amount">{{.Amount}}</td></tr>
+{{end}}</table>
+<table>
+<tr class="bold"><td>Revenue split</td><td class="amount"></td></tr>
+{{range .Split}}<tr><td>{{.Label}}</td><td class="amount">{{.Amount}}</td></tr>
+{{end}}</table>
+{{if .TaxNote}}<p class="muted">{{.TaxNote}}</p>
+{{end}}<p class="muted">Paid in full. Keep this receipt for your records.</p>
+</body>
+</html>
+`))
+
+// RenderHTML renders the receipt as a standalone HTML page
+func RenderHTML(inv *models.Invoice) ([]byte, error) {
+	name, address := Issuer()
+	var buf bytes.Buffer
+	err := htmlTemplate.Execute(&buf, map[string]interface{}{
+		"Invoice":       inv,
+		"IssuerName":    name,
+		"IssuerAddress": address,
+		"Description":   Description(inv),
+		"Totals":        Totals(inv),
+		"Split":         Split(inv),
+		"TaxNote":       TaxNote(inv),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderPDF renders the receipt as a one-page A4 PDF
+func RenderPDF(inv *models.Invoice) []byte {
+	const left, right = 56, pageWidth - 56
+	p := &pdfPage{}
+	y := float64(pageHeight - 72)
+
+	name, address := Issuer()
+	p.text(left, y, 22, true, "Receipt")
+	p.textRight(right, y, 11, true, inv.Number)
+	y -= 20
+	p.text(left, y, 10, false, name)
+	if address != "" {
+		y -= 14
+		p.text(left, y, 10, false, address)
+	}
+
+	y -= 36
+	for _, row := range [][2]string{
+		{"Date paid", inv.PaidAt.Format("02 Jan 2006 15:04 MST")},
+		{"Billed to", inv.BuyerName},
+		{"Email", inv.BuyerEmail},
+		{"Tax ID", inv.BuyerTaxID},
+		{"Payment method", inv.PaymentChannel},
+		{"Reference", inv.Reference},
+		{"Provider reference", inv.ProviderRef},
+	} {
+		if row[1] == "" {
+			continue
+		}
+		p.text(left, y, 10, false, row[0])
+		p.textRight(right, y, 10, false, row[1])
+		y -= 16
+	}
+
+	section := func(title string, lines []Line) {
+		y -= 16
+		p.text(left, y, 11, true, title)
+		y -= 6
+		p.line(left, y, right, y)
+		y -= 16
+		for _, l := range lines {
+			p.text(left, y, 10, l.Bold, l.Label)
+			p.textRight(right, y, 10, l.Bold, l.Amount)
+			y -= 16
+		}
+	}
+	section(Description(inv), Totals(inv))
+	section("Revenue split", Split(inv))
+
+	y -= 24
+	if note := TaxNote(inv); note != "" {
+		p.text(left, y, 9, false, note)
+		y -= 14
+	}
+	p.text(left, y, 9, false, "Paid in full. Keep this receipt for your records.")
+	return pdfDocument([]*pdfPage{p})
+}
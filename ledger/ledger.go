@@ -0,0 +1,225 @@
+// Package ledger keeps a double-entry record of every payment, refund and payout.
+// All amounts are integer subunits (cents) of the account currency.
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"Api/database"
+	"Api/models"
+)
+
+// DefaultCurrency is the currency of transactions recorded before they carried one
+const DefaultCurrency = "KES"
+
+// Account codes
+const (
+	ProviderClearing = "provider_clearing" // asset: money held for us by a payment provider
+	PlatformRevenue  = "platform_revenue"  // revenue: the platform's commission
+	CreatorPayable   = "creator_payable"   // liability: what we owe a creator
+	ProviderFees     = "provider_fees"     // expense: fees charged by payment providers
+	Refunds          = "refunds"           // expense: commission given back on refunded payments
+	TaxPayable       = "tax_payable"       // liability: tax collected from buyers and owed to tax authorities
+	EscrowHeld       = "escrow_held"       // liability: a creator's share held until the dispute window closes
+	WalletBalance    = "wallet_balance"    // liability: prepaid money a user can still spend
+)
+
+var accountTypes = map[string]string{
+	ProviderClearing: "asset",
+	PlatformRevenue:  "revenue",
+	CreatorPayable:   "liability",
+	ProviderFees:     "expense",
+	Refunds:          "expense",
+	TaxPayable:       "liability",
+	EscrowHeld:       "liability",
+	WalletBalance:    "liability",
+}
+
+var ErrUnbalanced = errors.New("journal entry does not balance")
+
+// Line is one side of a journal entry before it is posted
+type Line struct {
+	Account *models.LedgerAccount
+	Debit   int64
+	Credit  int64
+}
+
+// Debit returns a line debiting account
+func Debit(account *models.LedgerAccount, amount int64) Line {
+	return Line{Account: account, Debit: amount}
+}
+
+// Credit returns a line crediting account
+func Credit(account *models.LedgerAccount, amount int64) Line {
+	return Line{Account: account, Credit: amount}
+}
+
+// Account returns the account for code, creating it on first use. ownerID is only
+// used for creator and wallet accounts and channel only for provider accounts.
+func Account(tx *gorm.DB, code string, ownerID uint, channel, currency string) (*models.LedgerAccount, error) {
+	accountType, ok := accountTypes[code]
+	if !ok {
+		return nil, fmt.Errorf("unknown ledger account %q", code)
+	}
+
+	key := code
+	name := code
+	switch {
+	case ownerID != 0:
+		key = fmt.Sprintf("%s:%d", code, ownerID)
+		owner := "creator"
+		if code == WalletBalance {
+			owner = "user"
+		}
+		name = fmt.Sprintf("%s for %s %d", code, owner, ownerID)
+	case channel != "":
+		key = fmt.Sprintf("%s:%s", code, channel)
+		name = fmt.Sprintf("%s at %s", code, channel)
+	}
+	key += ":" + currency
+
+	account := models.LedgerAccount{
+		Key:       key,
+		Code:      code,
+		Name:      name,
+		Type:      accountType,
+		OwnerID:   ownerID,
+		Channel:   channel,
+		Currency:  currency,
+		CreatedAt: time.Now(),
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&account).Error; err != nil {
+		return nil, err
+	}
+	if account.ID == 0 {
+		if err := tx.Where("key = ?", key).First(&account).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &account, nil
+}
+
+// Post writes a balanced journal entry. key makes posting idempotent: posting the
+// same key again returns the existing entry without writing anything.
+func Post(tx *gorm.DB, key, kind string, transactionID *uint, currency, description string, lines ...Line) (*models.JournalEntry, error) {
+	var existing models.JournalEntry
+	err := tx.Where("key = ?", key).First(&existing).Error
+	if err == nil {
+		return &existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var debits, credits int64
+	entry := models.JournalEntry{
+		Key:           key,
+		Kind:          kind,
+		TransactionID: transactionID,
+		Currency:      currency,
+		Description:   description,
+		CreatedAt:     time.Now(),
+	}
+	for _, l := range lines {
+		if l.Debit < 0 || l.Credit < 0 {
+			return nil, fmt.Errorf("%w: negative amount on %s", ErrUnbalanced, l.Account.Key)
+		}
+		if l.Account.Currency != currency {
+			return nil, fmt.Errorf("%w: %s is not a %s account", ErrUnbalanced, l.Account.Key, currency)
+		}
+		if l.Debit == 0 && l.Credit == 0 {
+			continue
+		}
+		debits += l.Debit
+		credits += l.Credit
+		entry.Lines = append(entry.Lines, models.JournalLine{AccountID: l.Account.ID, Debit: l.Debit, Credit: l.Credit})
+	}
+	if debits != credits {
+		return nil, fmt.Errorf("%w: %s debits %d, credits %d", ErrUnbalanced, key, debits, credits)
+	}
+	if len(entry.Lines) == 0 {
+		return nil, nil
+	}
+
+	if err := tx.Create(&entry).Error; err != nil {
+		return nil, fmt.Errorf("failed to post journal entry %s: %w", key, err)
+	}
+	return &entry, nil
+}
+
+// Balance is the running total of one account. Amount is positive on the account's
+// normal side: debit for assets and expenses, credit for liabilities and revenue.
+type Balance struct {
+	Account models.LedgerAccount `json:"account"`
+	Debits  int64                `json:"debits"`
+	Credits int64                `json:"credits"`
+	Amount  int64                `json:"amount"`
+}
+
+// Balances returns the balance of every account matching the optional filter
+func Balances(filter func(*gorm.DB) *gorm.DB) ([]Balance, error) {
+	q := database.DB.Model(&models.LedgerAccount{})
+	if filter != nil {
+		q = filter(q)
+	}
+	var accounts []models.LedgerAccount
+	if err := q.Order("code, owner_id, channel").Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+	if len(accounts) == 0 {
+		return []Balance{}, nil
+	}
+
+	ids := make([]uint, len(accounts))
+	for i, a := range accounts {
+		ids[i] = a.ID
+	}
+	var sums []struct {
+		AccountID uint
+		Debits    int64
+		Credits   int64
+	}
+	if err := database.DB.Model(&models.JournalLine{}).
+		Select("account_id, COALESCE(SUM(debit), 0) AS debits, COALESCE(SUM(credit), 0) AS credits").
+		Where("account_id IN ?", ids).
+		Group("account_id").
+		Scan(&sums).Error; err != nil {
+		return nil, err
+	}
+	byAccount := make(map[uint]int, len(sums))
+	for i, s := range sums {
+		byAccount[s.AccountID] = i
+	}
+
+	balances := make([]Balance, len(accounts))
+	for i, a := range accounts {
+		b := Balance{Account: a}
+		if j, ok := byAccount[a.ID]; ok {
+			b.Debits = sums[j].Debits
+			b.Credits = sums[j].Credits
+		}
+		if a.Type == "asset" || a.Type == "expense" {
+			b.Amount = b.Debits - b.Credits
+		} else {
+			b.Amount = b.Credits - b.Debits
+		}
+		balances[i] = b
+	}
+	return balances, nil
+}
+
+// CreatorBalance is what the platform currently owes a creator
+func CreatorBalance(creatorID uint, currency string) (int64, error) {
+	balances, err := Balances(func(q *gorm.DB) *gorm.DB {
+		return q.Where("code = ? AND owner_id = ? AND currency = ?", CreatorPayable, creatorID, currency)
+	})
+	if err != nil || len(balances) == 0 {
+		return 0, err
+	}
+	return balances[0].Amount, nil
+}
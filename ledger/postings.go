@@ -0,0 +1,363 @@
+package ledger
+
+import (
+	"fmt"
+	"log"
+	"math"
+
+	"gorm.io/gorm"
+
+	"Api/database"
+	"Api/models"
+	"Api/payments"
+)
+
+// PostPayment records a successful payment: the provider holds the full amount,
+// the platform earns its commission, any tax is owed to the tax authority and the
+// creator is owed the rest.
+//
+//	Dr provider_clearing  amount
+//	Cr platform_revenue   company share
+//	Cr tax_payable        tax
+//	Cr creator_payable    creator share
+//
+// Purchases in escrow credit escrow_held instead of creator_payable until
+// PostEscrowRelease. When the provider already paid the creator through a
+// subaccount split, a settlement entry clears what we owe them straight away.
+//
+//	Dr creator_payable    creator share
+//	Cr provider_clearing  creator share
+func PostPayment(tx *gorm.DB, transaction *models.Transaction) error {
+	currency := CurrencyOf(transaction)
+	amount := payments.ToMinor(transaction.Amount)
+	tax := payments.ToMinor(transaction.Tax)
+	if tax > amount {
+		tax = amount
+	}
+	platform := payments.ToMinor(transaction.CompanyShare)
+	if platform > amount-tax {
+		platform = amount - tax
+	}
+	creator := amount - tax - platform
+
+	clearing, err := Account(tx, ProviderClearing, 0, transaction.PaymentChannel, currency)
+	if err != nil {
+		return err
+	}
+	revenue, err := Account(tx, PlatformRevenue, 0, "", currency)
+	if err != nil {
+		return err
+	}
+	taxes, err := Account(tx, TaxPayable, 0, "", currency)
+	if err != nil {
+		return err
+	}
+	payable, err := creatorAccount(tx, transaction, currency)
+	if err != nil {
+		return err
+	}
+
+	_, err = Post(tx, "payment:"+transaction.Reference, "payment", &transaction.ID, currency,
+		fmt.Sprintf("%s of bot %d", transaction.PaymentType, transaction.BotID),
+		Debit(clearing, amount),
+		Credit(revenue, platform),
+		Credit(taxes, tax),
+		Credit(payable, creator),
+	)
+	if err != nil || !transaction.SplitAtSource {
+		return err
+	}
+
+	_, err = Post(tx, "settlement:"+transaction.Reference, "settlement", &transaction.ID, currency,
+		fmt.Sprintf("creator share of %s settled by %s split", transaction.Reference, transaction.PaymentChannel),
+		Debit(payable, creator),
+		Credit(clearing, creator),
+	)
+	return err
+}
+
+// PostProviderFee records the fee a provider kept from a payment
+//
+//	Dr provider_fees      fee
+//	Cr provider_clearing  fee
+func PostProviderFee(tx *gorm.DB, transaction *models.Transaction, fee int64) error {
+	if fee <= 0 {
+		return nil
+	}
+	currency := CurrencyOf(transaction)
+	fees, err := Account(tx, ProviderFees, 0, transaction.PaymentChannel, currency)
+	if err != nil {
+		return err
+	}
+	clearing, err := Account(tx, ProviderClearing, 0, transaction.PaymentChannel, currency)
+	if err != nil {
+		return err
+	}
+	_, err = Post(tx, "fee:"+transaction.Reference, "fee", &transaction.ID, currency,
+		fmt.Sprintf("%s fee on %s", transaction.PaymentChannel, transaction.Reference),
+		Debit(fees, fee),
+		Credit(clearing, fee),
+	)
+	return err
+}
+
+// PostRefund records money returned to a buyer. The refund is taken from the
+// platform, the tax collected and the creator in the same proportion the payment
+// was split.
+//
+//	Dr refunds            company part
+//	Dr tax_payable        tax part
+//	Dr creator_payable    creator part
+//	Cr provider_clearing  refunded amount
+func PostRefund(tx *gorm.DB, transaction *models.Transaction) error {
+	amount := payments.ToMinor(transaction.Amount)
+	refunded := payments.ToMinor(transaction.RefundedAmount)
+	if refunded <= 0 || amount <= 0 {
+		return nil
+	}
+	currency := CurrencyOf(transaction)
+	platform := int64(math.Round(float64(refunded) * float64(payments.ToMinor(transaction.CompanyShare)) / float64(amount)))
+	tax := int64(math.Round(float64(refunded) * float64(payments.ToMinor(transaction.Tax)) / float64(amount)))
+	if tax > refunded {
+		tax = refunded
+	}
+	if platform > refunded-tax {
+		platform = refunded - tax
+	}
+
+	refunds, err := Account(tx, Refunds, 0, "", currency)
+	if err != nil {
+		return err
+	}
+	taxes, err := Account(tx, TaxPayable, 0, "", currency)
+	if err != nil {
+		return err
+	}
+	payable, err := creatorAccount(tx, transaction, currency)
+	if err != nil {
+		return err
+	}
+	clearing, err := Account(tx, ProviderClearing, 0, transaction.PaymentChannel, currency)
+	if err != nil {
+		return err
+	}
+
+	_, err = Post(tx, "refund:"+transaction.Reference, "refund", &transaction.ID, currency,
+		fmt.Sprintf("refund of %s: %s", transaction.Reference, transaction.RefundReason),
+		Debit(refunds, platform),
+		Debit(taxes, tax),
+		Debit(payable, refunded-platform-tax),
+		Credit(clearing, refunded),
+	)
+	return err
+}
+
+// PostEscrowRelease makes a creator's escrowed share of a purchase payable once
+// the dispute window has closed or a dispute was ruled in their favour
+//
+//	Dr escrow_held        creator share
+//	Cr creator_payable    creator share
+func PostEscrowRelease(tx *gorm.DB, transaction *models.Transaction) error {
+	currency := CurrencyOf(transaction)
+	var held int64
+	err := tx.Model(&models.JournalLine{}).
+		Select("COALESCE(SUM(journal_lines.credit - journal_lines.debit), 0)").
+		Joins("JOIN journal_entries ON journal_entries.id = journal_lines.entry_id").
+		Joins("JOIN ledger_accounts ON ledger_accounts.id = journal_lines.account_id").
+		Where("journal_entries.transaction_id = ? AND ledger_accounts.code = ?", transaction.ID, EscrowHeld).
+		Scan(&held).Error
+	if err != nil || held <= 0 {
+		return err
+	}
+
+	seller := sellerOf(tx, transaction)
+	escrow, err := Account(tx, EscrowHeld, seller, "", currency)
+	if err != nil {
+		return err
+	}
+	payable, err := Account(tx, CreatorPayable, seller, "", currency)
+	if err != nil {
+		return err
+	}
+	_, err = Post(tx, "escrow_release:"+transaction.Reference, "escrow_release", &transaction.ID, currency,
+		fmt.Sprintf("escrow on %s released", transaction.Reference),
+		Debit(escrow, held),
+		Credit(payable, held),
+	)
+	return err
+}
+
+// PostPayout records money sent from a provider balance to a creator
+//
+//	Dr creator_payable    amount
+//	Cr provider_clearing  amount
+func PostPayout(tx *gorm.DB, key string, creatorID uint, channel, currency string, amount int64, description string) error {
+	payable, err := Account(tx, CreatorPayable, creatorID, "", currency)
+	if err != nil {
+		return err
+	}
+	clearing, err := Account(tx, ProviderClearing, 0, channel, currency)
+	if err != nil {
+		return err
+	}
+	_, err = Post(tx, "payout:"+key, "payout", nil, currency, description,
+		Debit(payable, amount),
+		Credit(clearing, amount),
+	)
+	return err
+}
+
+// PostPayoutReversal puts back a payout the provider returned after confirming it
+//
+//	Dr provider_clearing  amount
+//	Cr creator_payable    amount
+func PostPayoutReversal(tx *gorm.DB, key string, creatorID uint, channel, currency string, amount int64, description string) error {
+	payable, err := Account(tx, CreatorPayable, creatorID, "", currency)
+	if err != nil {
+		return err
+	}
+	clearing, err := Account(tx, ProviderClearing, 0, channel, currency)
+	if err != nil {
+		return err
+	}
+	_, err = Post(tx, "payout_reversal:"+key, "payout_reversal", nil, currency, description,
+		Debit(clearing, amount),
+		Credit(payable, amount),
+	)
+	return err
+}
+
+// PostTopUp records money paid into a user's wallet
+//
+//	Dr provider_clearing  amount
+//	Cr wallet_balance     amount
+func PostTopUp(tx *gorm.DB, transaction *models.Transaction) error {
+	currency := CurrencyOf(transaction)
+	amount := payments.ToMinor(transaction.Amount)
+	clearing, err := Account(tx, ProviderClearing, 0, transaction.PaymentChannel, currency)
+	if err != nil {
+		return err
+	}
+	wallet, err := Account(tx, WalletBalance, transaction.UserID, "", currency)
+	if err != nil {
+		return err
+	}
+	_, err = Post(tx, "topup:"+transaction.Reference, "topup", &transaction.ID, currency,
+		fmt.Sprintf("wallet top-up for user %d", transaction.UserID),
+		Debit(clearing, amount),
+		Credit(wallet, amount),
+	)
+	return err
+}
+
+// PostWalletSpend records a payment made from a wallet. PostPayment has already
+// debited the wallet's clearing account, which this entry settles.
+//
+//	Dr wallet_balance     amount
+//	Cr provider_clearing  amount
+func PostWalletSpend(tx *gorm.DB, transaction *models.Transaction, amount int64) error {
+	currency := CurrencyOf(transaction)
+	wallet, err := Account(tx, WalletBalance, transaction.UserID, "", currency)
+	if err != nil {
+		return err
+	}
+	clearing, err := Account(tx, ProviderClearing, 0, payments.ChannelWallet, currency)
+	if err != nil {
+		return err
+	}
+	_, err = Post(tx, "wallet_spend:"+transaction.Reference, "wallet_spend", &transaction.ID, currency,
+		fmt.Sprintf("%s paid from wallet", transaction.Reference),
+		Debit(wallet, amount),
+		Credit(clearing, amount),
+	)
+	return err
+}
+
+// PostWalletRefund returns a refunded wallet payment to the wallet
+//
+//	Dr provider_clearing  amount
+//	Cr wallet_balance     amount
+func PostWalletRefund(tx *gorm.DB, transaction *models.Transaction, amount int64) error {
+	currency := CurrencyOf(transaction)
+	clearing, err := Account(tx, ProviderClearing, 0, payments.ChannelWallet, currency)
+	if err != nil {
+		return err
+	}
+	wallet, err := Account(tx, WalletBalance, transaction.UserID, "", currency)
+	if err != nil {
+		return err
+	}
+	_, err = Post(tx, "wallet_refund:"+transaction.Reference, "wallet_refund", &transaction.ID, currency,
+		fmt.Sprintf("refund of %s to wallet", transaction.Reference),
+		Debit(clearing, amount),
+		Credit(wallet, amount),
+	)
+	return err
+}
+
+// creatorAccount is where a transaction's creator share sits: escrow while a purchase
+// is held or was refunded out of escrow, otherwise what we owe the creator
+func creatorAccount(tx *gorm.DB, transaction *models.Transaction, currency string) (*models.LedgerAccount, error) {
+	code := CreatorPayable
+	if transaction.EscrowStatus == "held" || transaction.EscrowStatus == "refunded" {
+		code = EscrowHeld
+	}
+	return Account(tx, code, sellerOf(tx, transaction), "", currency)
+}
+
+// sellerOf returns the creator who is paid for a transaction
+func sellerOf(tx *gorm.DB, transaction *models.Transaction) uint {
+	if transaction.PaymentType == "purchase" {
+		if transaction.PreviousOwnerID != 0 {
+			return transaction.PreviousOwnerID
+		}
+		// Purchases fulfilled before PreviousOwnerID existed only have the sale record
+		var sale models.Sale
+		if err := tx.Where("bot_id = ? AND buyer_id = ? AND sale_type = ?", transaction.BotID, transaction.UserID, "purchase").
+			First(&sale).Error; err == nil {
+			return sale.SellerID
+		}
+	}
+	var bot models.Bot
+	tx.First(&bot, transaction.BotID)
+	return bot.OwnerID
+}
+
+// CurrencyOf is the currency a transaction was charged in, KES for older rows
+func CurrencyOf(transaction *models.Transaction) string {
+	if transaction.Currency == "" {
+		return DefaultCurrency
+	}
+	return transaction.Currency
+}
+
+// Backfill posts entries for payments and refunds made before the ledger existed.
+// Entries are keyed by reference, so running it again changes nothing. Manual
+// records moved no money through the platform and are left out.
+func Backfill() {
+	var transactions []models.Transaction
+	database.DB.
+		Where("status IN ? AND payment_type <> ? AND manual = ?", []string{"success", "refunded", "disputed"}, "topup", false).
+		Where("NOT EXISTS (SELECT 1 FROM journal_entries WHERE journal_entries.key = 'payment:' || transactions.reference)").
+		Find(&transactions)
+	if len(transactions) == 0 {
+		return
+	}
+
+	log.Printf("Backfilling ledger for %d transactions...", len(transactions))
+	for i := range transactions {
+		t := &transactions[i]
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			if err := PostPayment(tx, t); err != nil {
+				return err
+			}
+			if t.Status == "refunded" {
+				return PostRefund(tx, t)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("Failed to backfill ledger for %s: %v", t.Reference, err)
+		}
+	}
+}
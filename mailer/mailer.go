@@ -0,0 +1,230 @@
+// Package mailer sends transactional email over SMTP, or writes it to files or the
+// log so local development works without a mail server.
+package mailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"Api/internal/config"
+)
+
+// Attachment is a file sent with a message
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Message is one email. Text is required; HTML is sent as an alternative when set.
+type Message struct {
+	To          []string
+	Subject     string
+	Text        string
+	HTML        string
+	Attachments []Attachment
+}
+
+// Sender delivers messages
+type Sender interface {
+	Send(msg *Message) error
+}
+
+var sender Sender = LogSender{}
+
+// Init picks the backend named by MAIL_BACKEND. Without one it uses SMTP when
+// SMTP_HOST is set and the log backend otherwise.
+func Init() {
+	cfg := config.Get().SMTP
+	backend := cfg.Backend
+	if backend == "" {
+		backend = "log"
+		if cfg.Host != "" {
+			backend = "smtp"
+		}
+	}
+	switch backend {
+	case "smtp":
+		sender = &SMTPSender{
+			Host:     cfg.Host,
+			Port:     cfg.Port,
+			Username: cfg.Username,
+			Password: cfg.Password,
+			From:     cfg.From,
+		}
+	case "file":
+		log.Printf("Emails will be written to %s instead of sent", cfg.Dir)
+		sender = &FileSender{Dir: cfg.Dir, From: cfg.From}
+	default:
+		log.Printf("SMTP_HOST not set, emails will be logged instead of sent")
+		sender = LogSender{}
+	}
+}
+
+// Send delivers msg with the configured backend
+func Send(msg *Message) error {
+	if len(msg.To) == 0 {
+		return fmt.Errorf("email has no recipients")
+	}
+	return sender.Send(msg)
+}
+
+// SMTPSender sends mail through an SMTP relay with PLAIN auth over STARTTLS
+type SMTPSender struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPSender) Send(msg *Message) error {
+	from := s.From
+	if from == "" {
+		from = s.Username
+	}
+	body, err := Build(from, msg)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	if err := smtp.SendMail(s.Host+":"+s.Port, auth, from, msg.To, body); err != nil {
+		return fmt.Errorf("smtp send to %s failed: %w", strings.Join(msg.To, ", "), err)
+	}
+	return nil
+}
+
+// LogSender writes a summary of each message to the log
+type LogSender struct{}
+
+func (LogSender) Send(msg *Message) error {
+	names := make([]string, len(msg.Attachments))
+	for i, a := range msg.Attachments {
+		names[i] = a.Filename
+	}
+	log.Printf("Email to %s: %q attachments=%v\n%s", strings.Join(msg.To, ", "), msg.Subject, names, msg.Text)
+	return nil
+}
+
+// FileSender writes each message as an .eml file that mail clients can open
+type FileSender struct {
+	Dir  string
+	From string
+}
+
+func (s *FileSender) Send(msg *Message) error {
+	from := s.From
+	if from == "" {
+		from = "noreply@localhost"
+	}
+	body, err := Build(from, msg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000000"), fileSafe(msg.To[0]))
+	path := filepath.Join(s.Dir, name)
+	if err := os.WriteFile(path, body, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	log.Printf("Email to %s: %q written to %s", strings.Join(msg.To, ", "), msg.Subject, path)
+	return nil
+}
+
+// fileSafe keeps letters, digits, dots, dashes and @ so an address can name a file
+func fileSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '@':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// Build renders msg as a MIME message ready for SMTP
+func Build(from string, msg *Message) ([]byte, error) {
+	var buf bytes.Buffer
+	header := func(k, v string) { fmt.Fprintf(&buf, "%s: %s\r\n", k, v) }
+	header("From", from)
+	header("To", strings.Join(msg.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+
+	mixed := multipart.NewWriter(&buf)
+	header("Content-Type", "multipart/mixed; boundary="+mixed.Boundary())
+	buf.WriteString("\r\n")
+
+	// Text and HTML bodies as alternatives of each other
+	var alt bytes.Buffer
+	altWriter := multipart.NewWriter(&alt)
+	if err := writePart(altWriter, "text/plain; charset=utf-8", "", []byte(msg.Text)); err != nil {
+		return nil, err
+	}
+	if msg.HTML != "" {
+		if err := writePart(altWriter, "text/html; charset=utf-8", "", []byte(msg.HTML)); err != nil {
+			return nil, err
+		}
+	}
+	if err := altWriter.Close(); err != nil {
+		return nil, err
+	}
+	part, err := mixed.CreatePart(textproto.MIMEHeader{"Content-Type": {"multipart/alternative; boundary=" + altWriter.Boundary()}})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(alt.Bytes()); err != nil {
+		return nil, err
+	}
+
+	for _, a := range msg.Attachments {
+		disposition := mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})
+		if err := writePart(mixed, a.ContentType, disposition, a.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writePart adds a base64 encoded part, wrapped at 76 columns as RFC 2045 requires
+func writePart(w *multipart.Writer, contentType, disposition string, data []byte) error {
+	h := textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"base64"},
+	}
+	if disposition != "" {
+		h.Set("Content-Disposition", disposition)
+	}
+	part, err := w.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		if _, err := fmt.Fprintf(part, "%s\r\n", encoded[:76]); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err = fmt.Fprintf(part, "%s\r\n", encoded)
+	return err
+}
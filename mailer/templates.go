@@ -0,0 +1,77 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+
+	"Api/internal/config"
+)
+
+// AppName signs every templated email
+const AppName = "AlgoCDK"
+
+// Templates, one file per email under templates/. Each defines "subject", "text"
+// and "content"; the HTML part is "content" inside layout.tmpl.
+const (
+	TemplateVerifyEmail     = "verify_email"
+	TemplatePasswordReset   = "password_reset"
+	TemplateReceipt         = "receipt"
+	TemplateSaleNotice      = "sale_notice"
+	TemplateUpgradeApproved = "upgrade_approved"
+	TemplateAccountLocked   = "account_locked"
+	TemplateAccountStatus   = "account_status"
+)
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+// Data is what a template is rendered with. Render adds AppName and FrontendURL.
+type Data map[string]interface{}
+
+// Render builds a message from the named template
+func Render(name string, data Data) (*Message, error) {
+	files := []string{"templates/layout.tmpl", "templates/" + name + ".tmpl"}
+	text, err := template.ParseFS(templateFS, files...)
+	if err != nil {
+		return nil, fmt.Errorf("email template %s: %w", name, err)
+	}
+	html, err := htmltemplate.ParseFS(templateFS, files...)
+	if err != nil {
+		return nil, fmt.Errorf("email template %s: %w", name, err)
+	}
+
+	values := Data{"AppName": AppName, "FrontendURL": config.Get().FrontendURL}
+	for k, v := range data {
+		values[k] = v
+	}
+
+	var subject, body, htmlBody bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, "subject", values); err != nil {
+		return nil, fmt.Errorf("email template %s: %w", name, err)
+	}
+	if err := text.ExecuteTemplate(&body, "text", values); err != nil {
+		return nil, fmt.Errorf("email template %s: %w", name, err)
+	}
+	if err := html.ExecuteTemplate(&htmlBody, "layout", values); err != nil {
+		return nil, fmt.Errorf("email template %s: %w", name, err)
+	}
+	return &Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(body.String()) + "\n",
+		HTML:    htmlBody.String(),
+	}, nil
+}
+
+// SendTemplate renders the named template and sends it to one recipient
+func SendTemplate(to, name string, data Data) error {
+	msg, err := Render(name, data)
+	if err != nil {
+		return err
+	}
+	msg.To = []string{to}
+	return Send(msg)
+}
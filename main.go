@@ -3,38 +3,63 @@ package main
 import (
 	"fmt"
 	"log"
-	"os"
+	"time"
 
 	"Api/database"
+	"Api/internal/config"
+	"Api/ledger"
+	"Api/mailer"
 	"Api/middleware"
+	"Api/mpesa"
+	"Api/payments"
+	"Api/paystack"
 	"Api/routes"
+	"Api/services"
 	"Api/tasks"
 
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
 )
 
 func main() {
-	// Load .env locally
-	if os.Getenv("ENV") != "production" {
-		if err := godotenv.Load(); err != nil {
-			log.Println("⚠️ No .env found, using system env vars")
-		}
+	// Load configuration (.env locally, then the environment's profile)
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("❌ ", err)
+	}
+	log.Printf("⚙️ Configuration for %s:", cfg.Env)
+	for _, line := range cfg.Summary() {
+		log.Println("   " + line)
 	}
-
-	fmt.Println("SUPER_ADMIN_SECRET:", os.Getenv("SUPER_ADMIN_SECRET"))
 
 	// Connect to DB + run expired bot task
 	database.InitDB()
-	tasks.DeactivateExpiredBots()
+	ledger.Backfill()
+	mailer.Init()
+	services.InitLoginThrottle()
+	payments.InitProviders()
+	mpesa.InitProvider()
+	paystack.RegisterWebhookProcessor()
+	mpesa.RegisterWebhookProcessors()
+	go tasks.Every(time.Hour, "expired rentals", tasks.DeactivateExpiredBots)
+	go tasks.Every(time.Hour, "subscription renewals", tasks.RenewSubscriptions)
+	go tasks.Every(time.Minute, "M-Pesa poller", tasks.ResolvePendingSTKPushes)
+	go tasks.Daily(2, "payment reconciliation", tasks.ReconcileYesterday)
+	go tasks.Every(time.Hour, "escrow release", tasks.ReleaseEscrows)
+	go tasks.Every(5*time.Minute, "wallet holds", tasks.ReleaseWalletHolds)
+	go tasks.Every(time.Minute, "webhook retries", tasks.RetryWebhooks)
+	go tasks.Every(time.Hour, "login attempts", tasks.PruneLoginAttempts)
+	go tasks.Every(time.Hour, "account suspensions", tasks.LiftExpiredSuspensions)
+	if cfg.ExchangeRatesURL != "" {
+		go tasks.Every(6*time.Hour, "exchange rates", tasks.ImportExchangeRates)
+	}
 
 	// Gin config
-	if os.Getenv("GIN_MODE") == "release" {
+	if cfg.GinMode == "release" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	r := gin.Default()
 	r.SetTrustedProxies(nil)
-	r.Use(middleware.CORSMiddleware())
+	r.Use(middleware.RequestID(), middleware.CORSMiddleware())
 
 	// Set up API routes
 	routes.SetUpRouter(r)
@@ -94,14 +119,8 @@ func main() {
 		c.File(frontendPath + "/index.html")
 	})
 
-	// Port config
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-
-	fmt.Printf("🚀 Server running on http://localhost:%s\n", port)
-	if err := r.Run(":" + port); err != nil {
+	fmt.Printf("🚀 Server running on %s\n", cfg.PublicURL)
+	if err := r.Run(":" + cfg.Port); err != nil {
 		log.Fatal("❌ Failed to start server:", err)
 	}
 }
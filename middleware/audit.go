@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"Api/models"
+	"Api/services"
+)
+
+// Audit appends every mutating request in the group to the audit log once the
+// handler has run, whatever its outcome. It runs after AuthMiddleware.
+//
+// Handlers can describe the change through context keys: "audit_action",
+// "audit_target_type", "audit_target_id", and "audit_before"/"audit_after"
+// holding services.AuditSnapshot results. Without them the action is the
+// route and the target is its :id.
+func Audit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		c.Next()
+
+		rec := services.AuditRecord{
+			ActorID:    c.GetUint("user_id"),
+			ActorEmail: c.GetString("email"),
+			ActorRole:  models.Role(c.GetString("role")),
+			Action:     c.GetString("audit_action"),
+			Method:     c.Request.Method,
+			Path:       c.Request.URL.Path,
+			TargetType: c.GetString("audit_target_type"),
+			TargetID:   c.GetString("audit_target_id"),
+			Status:     c.Writer.Status(),
+			IP:         c.ClientIP(),
+			RequestID:  c.GetString("request_id"),
+		}
+		if rec.Action == "" {
+			rec.Action = c.Request.Method + " " + c.FullPath()
+		}
+		if rec.TargetID == "" {
+			rec.TargetID = c.Param("id")
+		}
+		if keyID, ok := c.Get("api_key_id"); ok {
+			if id, ok := keyID.(uint); ok {
+				rec.APIKeyID = &id
+			}
+		}
+		if v, ok := c.Get("audit_before"); ok {
+			rec.Before, _ = v.(map[string]interface{})
+		}
+		if v, ok := c.Get("audit_after"); ok {
+			rec.After, _ = v.(map[string]interface{})
+		}
+
+		if _, err := services.RecordAudit(rec); err != nil {
+			log.Printf("⚠️ Audit entry lost for %s %s by user %d (request %s): %v",
+				rec.Method, rec.Path, rec.ActorID, rec.RequestID, err)
+		}
+	}
+}
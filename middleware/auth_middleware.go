@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"Api/models"
+	"Api/services"
 	"Api/utils"
 	"net/http"
 	"strings"
@@ -9,8 +11,16 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// AuthMiddleware accepts a user's access token, or an API key on routes that
+// allow one (see AllowAPIKey)
 func AuthMiddleware() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		// API keys are checked against the route's scope instead of a session
+		if key := apiKeyFromRequest(ctx); key != "" {
+			authenticateAPIKey(ctx, key)
+			return
+		}
+
 		// Try getting token from header first
 		tokenString := ctx.GetHeader("Authorization")
 
@@ -34,7 +44,7 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		// Parse and validate JWT
 		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return utils.JwtKey, nil
+			return utils.JwtKey(), nil
 		})
 		if err != nil || !token.Valid {
 			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
@@ -43,9 +53,35 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		claims := token.Claims.(jwt.MapClaims)
-		ctx.Set("user_id", uint(claims["user_id"].(float64)))
-		ctx.Set("email", claims["email"].(string))
+		userID, _ := claims["user_id"].(float64)
+		email, _ := claims["email"].(string)
+		sessionID, _ := claims["sid"].(float64)
+		role, _ := claims["role"].(string)
+
+		// Tokens are tied to a session so signing a device out takes effect immediately
+		if sessionID == 0 || !services.SessionActive(uint(sessionID), uint(userID)) {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "session has been signed out"})
+			ctx.Abort()
+			return
+		}
+
+		// Suspended, banned and deleted accounts lose access at once, whatever tokens they hold
+		if err := services.CheckAccountActiveByID(uint(userID)); err != nil {
+			rejectInactiveAccount(ctx, err.(*services.AccountStatusError))
+			return
+		}
+
+		ctx.Set("user_id", uint(userID))
+		ctx.Set("email", email)
+		ctx.Set("session_id", uint(sessionID))
+		ctx.Set("role", string(models.NormalizeRole(role)))
 
 		ctx.Next()
 	}
 }
+
+// rejectInactiveAccount answers a request from an account that is not active
+func rejectInactiveAccount(ctx *gin.Context, err *services.AccountStatusError) {
+	ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "code": "account_" + string(err.Status), "account_status": err})
+	ctx.Abort()
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"Api/models"
+)
+
+// RequirePermission lets the request through only when the caller's role, taken
+// from the access token by AuthMiddleware, grants every one of perms.
+func RequirePermission(perms ...models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := models.Role(c.GetString("role"))
+		for _, p := range perms {
+			if !role.Can(p) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "access denied", "missing_permission": p})
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// SuperAdminMiddleware only lets superadmins through
+func SuperAdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if models.Role(c.GetString("role")) != models.RoleSuperAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"message": "Access denied"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
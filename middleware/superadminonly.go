@@ -1,19 +0,0 @@
-package middleware
-
-import (
-	"net/http"
-
-	"github.com/gin-gonic/gin"
-)
-
-func SuperAdminMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		role, exists := c.Get("role")
-		if !exists || role != "SUPERADMIN" {
-			c.JSON(http.StatusForbidden, gin.H{"message": "Access denied"})
-			c.Abort()
-			return
-		}
-		c.Next()
-	}
-}
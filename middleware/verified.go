@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"Api/database"
+	"Api/models"
+)
+
+// RequireVerifiedEmail blocks purchases until the caller has confirmed their
+// email address. It runs after AuthMiddleware.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user models.Person
+		err := database.DB.Select("id", "email_verified_at").First(&user, c.GetUint("user_id")).Error
+		if err != nil || user.EmailVerifiedAt == nil {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "confirm your email address before making a purchase",
+				"code":  "email_not_verified",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// AccountStatus says whether a person may use the platform. Only active accounts
+// can sign in, call the API or sell; their bots are hidden from the marketplace
+// otherwise.
+type AccountStatus string
+
+const (
+	AccountActive    AccountStatus = "active"
+	AccountSuspended AccountStatus = "suspended" // temporary, optionally until StatusUntil
+	AccountBanned    AccountStatus = "banned"    // permanent, lifted only by a superadmin
+	AccountDeleted   AccountStatus = "deleted"   // closed, records kept for payments and audit
+)
+
+// ParseAccountStatus reports whether s is a known status
+func ParseAccountStatus(s string) (AccountStatus, bool) {
+	switch status := AccountStatus(s); status {
+	case AccountActive, AccountSuspended, AccountBanned, AccountDeleted:
+		return status, true
+	}
+	return "", false
+}
+
+// CurrentStatus is the status in force at now: a suspension past its end date
+// has lapsed even before the task that lifts it has run
+func (p *Person) CurrentStatus(now time.Time) AccountStatus {
+	if p.Status == "" {
+		return AccountActive
+	}
+	if p.Status == AccountSuspended && p.StatusUntil != nil && !now.Before(*p.StatusUntil) {
+		return AccountActive
+	}
+	return p.Status
+}
@@ -15,6 +15,8 @@ type Admin struct {
 	AccountNumber          string     `json:"account_number"`
 	AccountName            string     `json:"account_name"`
 	PaystackSubaccountCode string     `json:"paystack_subaccount_code"`
+	PayoutMethod           string     `gorm:"default:bank" json:"payout_method"` // "bank" or "mpesa"
+	PayoutPhone            string     `json:"payout_phone"`                      // M-Pesa number for "mpesa" payouts
 	KYCStatus              string     `gorm:"default:unverified" json:"kyc_status"`
 	VerifiedAt             *time.Time `json:"verified_at"`
 
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Scope is something an API key may do. Each route that accepts API keys names
+// the scope it needs; every other route only accepts a user's access token.
+type Scope string
+
+const (
+	ScopeBotsRead         Scope = "bots:read"         // list the creator's bots
+	ScopeBotsWrite        Scope = "bots:write"        // publish, update and delete bots and their rental plans
+	ScopeTransactionsRead Scope = "transactions:read" // transactions and invoices the owner can see
+	ScopePayoutsRead      Scope = "payouts:read"      // balance and payout history
+)
+
+// scopePermissions is what the key's owner must be allowed to do to grant a scope
+var scopePermissions = map[Scope]Permission{
+	ScopeBotsRead:         PermCreator,
+	ScopeBotsWrite:        PermCreator,
+	ScopeTransactionsRead: PermCreator,
+	ScopePayoutsRead:      PermCreator,
+}
+
+// ParseScope reports whether s is a known scope
+func ParseScope(s string) (Scope, bool) {
+	scope := Scope(strings.ToLower(strings.TrimSpace(s)))
+	_, ok := scopePermissions[scope]
+	return scope, ok
+}
+
+// CanGrant reports whether the role may create keys with the scope
+func (r Role) CanGrant(s Scope) bool {
+	p, ok := scopePermissions[s]
+	return ok && r.Can(p)
+}
+
+// APIKey lets an integration call the API as its owner, limited to its scopes.
+// Only a hash of the key is stored; Prefix identifies it in listings and logs.
+type APIKey struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"index" json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `gorm:"uniqueIndex" json:"prefix"`
+	KeyHash    string     `gorm:"uniqueIndex" json:"-"`
+	Scopes     string     `json:"-"` // space separated, see ScopeList
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// ScopeList returns the key's scopes
+func (k *APIKey) ScopeList() []Scope {
+	fields := strings.Fields(k.Scopes)
+	scopes := make([]Scope, len(fields))
+	for i, f := range fields {
+		scopes[i] = Scope(f)
+	}
+	return scopes
+}
+
+// HasScope reports whether the key was granted s
+func (k *APIKey) HasScope(s Scope) bool {
+	for _, granted := range k.ScopeList() {
+		if granted == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// AuditEntry records one mutating admin or superadmin request. Entries are only
+// ever inserted: each one carries the hash of the entry before it, so editing
+// or removing a row breaks the chain (see services.VerifyAuditChain).
+type AuditEntry struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Seq        uint64    `gorm:"uniqueIndex" json:"seq"` // 1, 2, 3... with no gaps
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+	ActorID    uint      `gorm:"index" json:"actor_id"`
+	ActorEmail string    `json:"actor_email"`
+	ActorRole  Role      `json:"actor_role"`
+	APIKeyID   *uint     `json:"api_key_id,omitempty"` // set when the call used an API key
+	Action     string    `gorm:"index" json:"action"`  // e.g. "user.update", or "POST /api/admin/payouts"
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	TargetType string    `gorm:"index:idx_audit_target" json:"target_type,omitempty"`
+	TargetID   string    `gorm:"index:idx_audit_target" json:"target_id,omitempty"`
+	Changes    AuditDiff `gorm:"type:text" json:"changes,omitempty"`
+	Status     int       `json:"status"`
+	IP         string    `json:"ip"`
+	RequestID  string    `gorm:"index" json:"request_id"`
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `gorm:"uniqueIndex" json:"hash"`
+}
+
+// AuditDiff is a JSON object of changed fields, {"field": {"from": x, "to": y}}.
+// It is stored as text so the bytes that were hashed are the bytes read back.
+type AuditDiff string
+
+// MarshalJSON embeds the diff as an object rather than a quoted string
+func (d AuditDiff) MarshalJSON() ([]byte, error) {
+	if d == "" {
+		return []byte("null"), nil
+	}
+	return []byte(d), nil
+}
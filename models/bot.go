@@ -7,14 +7,17 @@ type Bot struct {
 	Name      string    `json:"name"`
 	HTMLFile  string    `json:"html_file"`
 	Image     string    `json:"image"`
-	Price     float64   `json:"price"`      // 💰 Main purchase price
-	RentPrice float64   `json:"rent_price"` // 💰 Rental price per period
+	Price     float64   `json:"price"`                       // 💰 Main purchase price
+	Currency  string    `json:"currency" gorm:"default:KES"` // currency of Price and of the rental plans
 	Strategy  string    `json:"strategy"`
 	OwnerID   uint      `json:"owner_id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Status    string    `json:"status" gorm:"default:'inactive'"`
 
+	// 💰 Rental prices, one per period
+	RentalPlans []RentalPlan `gorm:"foreignKey:BotID;constraint:OnDelete:CASCADE" json:"rental_plans,omitempty"`
+
 	// 🕒 Subscription / Rent Info
 	SubscriptionType   string `json:"subscription_type"`   // e.g. "monthly", "weekly", "lifetime"
 	SubscriptionExpiry string `json:"subscription_expiry"` // optional: template expiry or plan info
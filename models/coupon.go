@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Coupon discounts a bot checkout. Scope narrows from platform-wide (no BotID or
+// CreatorID) to every bot of one creator (CreatorID) to a single bot (BotID).
+type Coupon struct {
+	ID                uint       `gorm:"primaryKey" json:"id"`
+	Code              string     `gorm:"uniqueIndex" json:"code"` // stored upper case
+	Description       string     `json:"description"`
+	Type              string     `json:"type"`  // "percent" or "fixed"
+	Value             float64    `json:"value"` // percentage points for "percent", an amount in Currency for "fixed"
+	Currency          string     `json:"currency" gorm:"default:KES"`
+	BotID             *uint      `gorm:"index" json:"bot_id,omitempty"`
+	CreatorID         *uint      `gorm:"index" json:"creator_id,omitempty"`
+	PaymentType       string     `json:"payment_type,omitempty"` // "purchase", "rent" or empty for both
+	MaxUses           int        `json:"max_uses"`               // 0 means unlimited
+	PerUserLimit      int        `json:"per_user_limit"`         // 0 means unlimited
+	UsedCount         int        `json:"used_count"`
+	FirstPurchaseOnly bool       `json:"first_purchase_only"` // only buyers with no earlier successful payment
+	StartsAt          *time.Time `json:"starts_at,omitempty"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+	IsActive          bool       `json:"is_active"`
+	CreatedBy         uint       `json:"created_by"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Dispute is a buyer's complaint about a purchase whose creator share is still in
+// escrow. A superadmin rules on it by releasing the funds or refunding the buyer.
+type Dispute struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	TransactionID  uint       `gorm:"index" json:"transaction_id"`
+	BuyerID        uint       `gorm:"index" json:"buyer_id"`
+	CreatorID      uint       `gorm:"index" json:"creator_id"`
+	Reason         string     `json:"reason"`
+	Evidence       string     `gorm:"type:text" json:"evidence"`
+	AttachmentPath string     `json:"attachment_path,omitempty"`
+	Status         string     `gorm:"index" json:"status"` // "open", "released" or "refunded"
+	Ruling         string     `json:"ruling,omitempty"`    // the superadmin's reasons
+	ResolvedBy     *uint      `json:"resolved_by,omitempty"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
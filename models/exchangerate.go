@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// ExchangeRate is how many units of Currency one US dollar buys. Conversions
+// between any two currencies go through USD.
+type ExchangeRate struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Currency    string    `gorm:"uniqueIndex" json:"currency"` // ISO 4217 code, e.g. "NGN"
+	UnitsPerUSD float64   `json:"units_per_usd"`
+	Source      string    `json:"source"` // "manual" or the import job's provider
+	UpdatedBy   *uint     `json:"updated_by,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// Invoice is the numbered receipt issued for a successful transaction.
+// Everything shown on the document is copied here so later edits to the bot,
+// plan or buyer profile don't change an issued receipt.
+type Invoice struct {
+	ID            uint   `gorm:"primaryKey" json:"id"`
+	Number        string `gorm:"uniqueIndex" json:"number"` // e.g. INV-2026-000042
+	TransactionID uint   `gorm:"uniqueIndex" json:"transaction_id"`
+	UserID        uint   `gorm:"index" json:"user_id"`    // buyer
+	CreatorID     uint   `gorm:"index" json:"creator_id"` // seller
+
+	BuyerName   string `json:"buyer_name"`
+	BuyerEmail  string `json:"buyer_email"`
+	CreatorName string `json:"creator_name"`
+
+	BotID       uint   `json:"bot_id"`
+	BotName     string `json:"bot_name"`
+	PaymentType string `json:"payment_type"` // "purchase" or "rent"
+	PlanName    string `json:"plan_name,omitempty"`
+	RentalDays  int    `json:"rental_days,omitempty"`
+
+	Currency     string  `json:"currency"`
+	ListPrice    float64 `json:"list_price"`
+	Discount     float64 `json:"discount"`
+	CouponCode   string  `json:"coupon_code,omitempty"`
+	TaxRate      float64 `json:"tax_rate"` // percent
+	Tax          float64 `json:"tax"`
+	Total        float64 `json:"total"`
+	CompanyShare float64 `json:"company_share"`
+	CreatorShare float64 `json:"creator_share"`
+
+	// Tax details; TaxName is e.g. "VAT" and TaxCountry the buyer's country
+	TaxName       string `json:"tax_name,omitempty"`
+	TaxCountry    string `json:"tax_country,omitempty"`
+	TaxInclusive  bool   `json:"tax_inclusive"`
+	ReverseCharge bool   `json:"reverse_charge"`
+	BuyerTaxID    string `json:"buyer_tax_id,omitempty"`
+
+	PaymentChannel string     `json:"payment_channel"`
+	Reference      string     `json:"reference"`
+	ProviderRef    string     `json:"provider_ref,omitempty"`
+	PaidAt         time.Time  `json:"paid_at"`
+	IssuedAt       time.Time  `json:"issued_at"`
+	EmailedAt      *time.Time `json:"emailed_at,omitempty"`
+}
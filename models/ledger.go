@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// LedgerAccount is one account of the double-entry ledger
+type LedgerAccount struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Key       string    `gorm:"uniqueIndex" json:"key"` // e.g. "creator_payable:12:KES"
+	Code      string    `gorm:"index" json:"code"`      // e.g. "platform_revenue", "creator_payable"
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`                            // "asset", "liability", "revenue", "expense"
+	OwnerID   uint      `gorm:"index" json:"owner_id,omitempty"` // creator the account belongs to, 0 for platform accounts
+	Channel   string    `json:"channel,omitempty"`               // payment channel for provider accounts
+	Currency  string    `json:"currency"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// JournalEntry groups balanced ledger lines for one business event
+type JournalEntry struct {
+	ID            uint          `gorm:"primaryKey" json:"id"`
+	Key           string        `gorm:"uniqueIndex" json:"key"` // makes posting idempotent, e.g. "payment:ALG_1_123"
+	Kind          string        `json:"kind"`                   // "payment", "settlement", "fee", "refund", "escrow_release", "topup", "wallet_spend", "wallet_refund", "payout", "payout_reversal"
+	TransactionID *uint         `gorm:"index" json:"transaction_id,omitempty"`
+	Currency      string        `json:"currency"`
+	Description   string        `json:"description"`
+	Lines         []JournalLine `gorm:"foreignKey:EntryID" json:"lines,omitempty"`
+	CreatedAt     time.Time     `json:"created_at"`
+}
+
+// JournalLine debits or credits one account, in the currency's subunit
+type JournalLine struct {
+	ID        uint  `gorm:"primaryKey" json:"id"`
+	EntryID   uint  `gorm:"index" json:"entry_id"`
+	AccountID uint  `gorm:"index" json:"account_id"`
+	Debit     int64 `json:"debit"`
+	Credit    int64 `json:"credit"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// LoginAttempt counts recent failed logins for one key: "ip:<address>" or
+// "account:<email>". Counting by the email typed, whether or not an account has
+// it, keeps throttling from revealing which accounts exist.
+type LoginAttempt struct {
+	Key           string    `gorm:"primaryKey" json:"key"`
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `gorm:"index" json:"last_failure_at"`
+}
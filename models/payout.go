@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Payout is a creator's request to withdraw earnings, and its transfer
+type Payout struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	CreatorID     uint       `gorm:"index" json:"creator_id"` // person who owns the earnings
+	Amount        int64      `json:"amount"`                  // subunits
+	Currency      string     `json:"currency"`
+	Channel       string     `json:"channel"`     // provider doing the transfer, e.g. "Paystack", "M-Pesa"
+	Destination   string     `json:"destination"` // masked account number or phone
+	Reference     string     `gorm:"uniqueIndex" json:"reference"`
+	ProviderRef   string     `gorm:"index" json:"provider_ref,omitempty"` // transfer code or B2C ConversationID
+	Status        string     `json:"status"`                              // "requested", "processing", "paid", "failed", "rejected"
+	FailureReason string     `json:"failure_reason,omitempty"`
+	ReviewedBy    *uint      `json:"reviewed_by,omitempty"`
+	ReviewedAt    *time.Time `json:"reviewed_at,omitempty"`
+	PaidAt        *time.Time `json:"paid_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
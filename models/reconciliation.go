@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// ReconciliationReport summarises one comparison of local transactions with a provider
+type ReconciliationReport struct {
+	ID         uint                 `gorm:"primaryKey" json:"id"`
+	Channel    string               `json:"channel"`
+	From       time.Time            `json:"from"`
+	To         time.Time            `json:"to"`
+	Status     string               `json:"status"` // "running", "completed", "failed"
+	Error      string               `json:"error,omitempty"`
+	Matched    int                  `json:"matched"`
+	Fulfilled  int                  `json:"fulfilled"`
+	Failed     int                  `json:"failed"`
+	Mismatches int                  `json:"mismatches"`
+	Items      []ReconciliationItem `gorm:"foreignKey:ReportID;constraint:OnDelete:CASCADE" json:"items,omitempty"`
+	CreatedAt  time.Time            `json:"created_at"`
+	FinishedAt *time.Time           `json:"finished_at,omitempty"`
+}
+
+// ReconciliationItem is one reference that needed action or attention
+type ReconciliationItem struct {
+	ID               uint   `gorm:"primaryKey" json:"id"`
+	ReportID         uint   `gorm:"index" json:"report_id"`
+	Reference        string `json:"reference"`
+	TransactionID    *uint  `json:"transaction_id,omitempty"`
+	Issue            string `json:"issue"`  // e.g. "stuck_pending", "amount_mismatch", "missing_local"
+	Action           string `json:"action"` // "fulfilled", "failed", "flagged"
+	LocalStatus      string `json:"local_status"`
+	ProviderStatus   string `json:"provider_status"`
+	LocalAmount      int64  `json:"local_amount"` // subunits
+	ProviderAmount   int64  `json:"provider_amount"`
+	LocalCurrency    string `json:"local_currency"`
+	ProviderCurrency string `json:"provider_currency"`
+	Detail           string `json:"detail,omitempty"`
+}
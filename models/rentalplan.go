@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// RentalPlan is one way to rent a bot, e.g. weekly for KES 500. Prices are in the bot's currency.
+type RentalPlan struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	BotID        uint      `gorm:"index" json:"bot_id"`
+	Name         string    `json:"name"` // e.g. "daily", "weekly", "monthly", "quarterly"
+	DurationDays int       `json:"duration_days"`
+	Price        float64   `json:"price"`
+	IsActive     bool      `json:"is_active"` // retired plans stay for existing rentals but can't be bought
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
@@ -1,9 +1,72 @@
 package models
 
+import "strings"
+
+// Role is stored on Person in lower case. ParseRole accepts the older spellings
+// ("User", "ADMIN", "Super Admin", ...) that are still in some clients.
 type Role string
 
 const (
-	RoleAdmin      Role = "Admin"
-	RoleUser       Role = "User"
-	RoleSuperAdmin Role = "SuperAdmin"
+	RoleUser       Role = "user"
+	RoleAdmin      Role = "admin"
+	RoleSuperAdmin Role = "superadmin"
+)
+
+// Roles lists every role, least privileged first
+var Roles = []Role{RoleUser, RoleAdmin, RoleSuperAdmin}
+
+// Permission is what a route group asks of the caller's role
+type Permission string
+
+const (
+	PermMarketplace Permission = "marketplace"  // buy and rent bots, manage one's own account
+	PermCreator     Permission = "creator"      // publish bots, run a storefront and get paid out
+	PermPlatform    Permission = "platform"     // the superadmin dashboard and notifications
+	PermManageUsers Permission = "manage_users" // users, admins and upgrade requests
+	PermManageBots  Permission = "manage_bots"  // every bot on the platform
+	PermFinance     Permission = "finance"      // transactions, refunds, ledger, payouts, coupons, tax, disputes, webhooks
 )
+
+var rolePermissions = map[Role][]Permission{
+	RoleUser:       {PermMarketplace},
+	RoleAdmin:      {PermMarketplace, PermCreator},
+	RoleSuperAdmin: {PermMarketplace, PermCreator, PermPlatform, PermManageUsers, PermManageBots, PermFinance},
+}
+
+// ParseRole maps any known spelling of a role onto its stored form
+func ParseRole(s string) (Role, bool) {
+	key := strings.ToLower(s)
+	key = strings.NewReplacer(" ", "", "_", "", "-", "").Replace(key)
+	switch key {
+	case "user":
+		return RoleUser, true
+	case "admin", "senioradmin":
+		return RoleAdmin, true
+	case "superadmin":
+		return RoleSuperAdmin, true
+	}
+	return "", false
+}
+
+// NormalizeRole is ParseRole falling back to the least privileged role
+func NormalizeRole(s string) Role {
+	if role, ok := ParseRole(s); ok {
+		return role
+	}
+	return RoleUser
+}
+
+// Can reports whether the role grants p
+func (r Role) Can(p Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Permissions lists what the role grants
+func (r Role) Permissions() []Permission {
+	return rolePermissions[r]
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Session is one signed-in device. Only hashes of its refresh token are stored;
+// the token rotates on every refresh and the previous hash is kept to spot reuse.
+type Session struct {
+	ID                uint       `gorm:"primaryKey" json:"id"`
+	UserID            uint       `gorm:"index" json:"user_id"`
+	TokenHash         string     `gorm:"uniqueIndex" json:"-"`
+	PreviousTokenHash string     `gorm:"index" json:"-"`
+	UserAgent         string     `json:"user_agent"`
+	IP                string     `json:"ip"`
+	LastUsedAt        time.Time  `json:"last_used_at"`
+	ExpiresAt         time.Time  `json:"expires_at"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+	RevokedReason     string     `json:"revoked_reason,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Subscription renews a bot rental automatically by charging the renter's saved card
+type Subscription struct {
+	ID                uint       `gorm:"primaryKey" json:"id"`
+	UserID            uint       `gorm:"uniqueIndex:idx_subscription_user_bot" json:"user_id"`
+	BotID             uint       `gorm:"uniqueIndex:idx_subscription_user_bot" json:"bot_id"`
+	Bot               Bot        `gorm:"foreignKey:BotID;constraint:OnDelete:CASCADE" json:"bot"`
+	RentalPlanID      uint       `json:"rental_plan_id"` // plan charged on every renewal
+	PaymentChannel    string     `json:"payment_channel"`
+	AuthorizationCode string     `json:"-"` // provider token for the saved card
+	Email             string     `json:"-"`
+	CardLast4         string     `json:"card_last4"`
+	CardBrand         string     `json:"card_brand"`
+	Amount            float64    `json:"amount"`                      // last amount charged
+	Currency          string     `json:"currency" gorm:"default:KES"` // currency the card is charged in
+	Status            string     `json:"status"`                      // "active", "past_due", "cancelled", "expired"
+	CurrentPeriodEnd  time.Time  `json:"current_period_end"`          // when paid access runs out
+	NextChargeAt      *time.Time `json:"next_charge_at,omitempty"`    // nil once no more charges will be attempted
+	FailedAttempts    int        `json:"failed_attempts"`
+	GraceUntil        *time.Time `json:"grace_until,omitempty"` // access is kept until then while past_due
+	PendingReference  string     `json:"-"`                     // renewal charge the provider has not settled yet
+	LastTransactionID uint       `json:"last_transaction_id"`
+	CancelledAt       *time.Time `json:"cancelled_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
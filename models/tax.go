@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// TaxRule is how sales to buyers in one country are taxed. Country matches
+// Person.Country as detected at signup, e.g. "Kenya".
+type TaxRule struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Country       string    `gorm:"uniqueIndex" json:"country"`
+	Name          string    `json:"name"`           // shown on receipts, e.g. "VAT"
+	Rate          float64   `json:"rate"`           // percent
+	Inclusive     bool      `json:"inclusive"`      // bot prices already include the tax
+	ReverseCharge bool      `json:"reverse_charge"` // business buyers with a tax ID account for the tax themselves
+	IsActive      bool      `json:"is_active"`
+	UpdatedBy     uint      `json:"updated_by"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
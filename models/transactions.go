@@ -7,14 +7,51 @@ type Transaction struct {
 	UserID         uint      `json:"user_id"`
 	AdminID        uint      `json:"admin_id"`
 	BotID          uint      `json:"bot_id"`
-	Amount         float64   `json:"amount"`
+	Amount         float64   `json:"amount"` // in Currency, as are the shares, list price and discount
 	CompanyShare   float64   `json:"company_share"`
 	AdminShare     float64   `json:"admin_share"`
-	Reference      string    `json:"reference"`
-	Status         string    `json:"status"`          // "pending", "success", "failed"
-	PaymentChannel string    `json:"payment_channel"` // e.g. "Paystack"
+	Reference      string    `json:"reference" gorm:"index"`
+	Status         string    `json:"status"`          // "pending", "success", "failed", "refunding", "refunded", "disputed"
+	PaymentChannel string    `json:"payment_channel"` // e.g. "Paystack", "M-Pesa"
+	ProviderRef    string    `json:"provider_ref"`    // provider's own receipt, e.g. M-Pesa receipt number
 	PaymentType    string    `json:"payment_type"`    // "purchase" or "rent"
 	Description    string    `json:"description"`
+	AutoRenew      bool      `json:"auto_renew"` // rentals only, start a subscription once paid
+	RentalPlanID   *uint     `json:"rental_plan_id,omitempty"`
+	RentalDays     int       `json:"rental_days,omitempty"` // copied from the plan so later plan edits don't change what was paid for
+	SplitAtSource  bool      `json:"split_at_source"`       // creator share settled by the provider through a subaccount
+	Manual         bool      `json:"manual"`                // recorded by hand through RecordTransaction, never posted to the ledger
+	CouponID       *uint     `gorm:"index" json:"coupon_id,omitempty"`
+	CouponCode     string    `json:"coupon_code,omitempty"`
+	ListPrice      float64   `json:"list_price,omitempty"` // price before the coupon
+	Discount       float64   `json:"discount,omitempty"`
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
+
+	// Currency conversion
+	Currency           string  `json:"currency" gorm:"default:KES"`            // currency the buyer was charged in
+	SettlementCurrency string  `json:"settlement_currency" gorm:"default:KES"` // the bot's price currency
+	SettlementAmount   float64 `json:"settlement_amount"`                      // Amount in SettlementCurrency
+	ExchangeRate       float64 `json:"exchange_rate" gorm:"default:1"`         // units of Currency per unit of SettlementCurrency
+
+	// Tax, collected by the platform on top of or within Amount
+	TaxCountry    string  `json:"tax_country,omitempty"`
+	TaxName       string  `json:"tax_name,omitempty"`
+	TaxRate       float64 `json:"tax_rate"` // percent
+	Tax           float64 `json:"tax"`
+	TaxInclusive  bool    `json:"tax_inclusive"`
+	ReverseCharge bool    `json:"reverse_charge"` // buyer accounts for the tax; none was charged
+	BuyerTaxID    string  `json:"buyer_tax_id,omitempty"`
+
+	// Escrow, for purchases whose creator share is held through the dispute window
+	EscrowStatus     string     `json:"escrow_status,omitempty"` // "held", "released" or "refunded"
+	EscrowReleaseAt  *time.Time `json:"escrow_release_at,omitempty"`
+	EscrowReleasedAt *time.Time `json:"escrow_released_at,omitempty"`
+
+	// Reversal bookkeeping
+	PreviousOwnerID uint       `json:"previous_owner_id,omitempty"` // bot owner before a purchase, restored on refund
+	RefundedAmount  float64    `json:"refunded_amount"`
+	RefundReference string     `json:"refund_reference,omitempty"`
+	RefundReason    string     `json:"refund_reason,omitempty"`
+	RefundedAt      *time.Time `json:"refunded_at,omitempty"`
 }
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// LoginChallenge is the step between a correct password and a session for accounts
+// that need a second factor. Only a hash of its token is stored.
+type LoginChallenge struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	UserID        uint       `gorm:"index" json:"user_id"`
+	TokenHash     string     `gorm:"uniqueIndex" json:"-"`
+	SetupRequired bool       `json:"setup_required"` // the role requires 2FA and the user has not enrolled yet
+	Attempts      int        `json:"attempts"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	UsedAt        *time.Time `json:"used_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// RecoveryCode is a one-time code that stands in for a TOTP code when the
+// authenticator is lost. Only its hash is stored.
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index" json:"user_id"`
+	CodeHash  string     `gorm:"index" json:"-"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// TwoFactorPolicy records whether a role must sign in with a second factor
+type TwoFactorPolicy struct {
+	Role      Role      `gorm:"primaryKey" json:"role"`
+	Required  bool      `json:"required"`
+	UpdatedBy uint      `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
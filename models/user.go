@@ -10,12 +10,11 @@ type Person struct {
 	Name                 string              `json:"name"`
 	Email                string              `json:"email" gorm:"uniqueIndex"`
 	Password             string              `json:"-"` // hide in responses
-	Role                 string              `json:"role" gorm:"default:USER"`
+	Role                 Role                `json:"role" gorm:"default:user"`
 	Phone                string              `json:"phone"`
 	Country              string              `json:"country"`
-	RefreshToken         string              `json:"refresh_token"`
-	Token                string              `json:"token,omitempty"`
-	ResetToken           string              `json:"-"` // for password reset
+	TaxID                string              `json:"tax_id"` // VAT or business registration number, for reverse-charge sales
+	ResetToken           string              `json:"-"`      // for password reset
 	ResetExpiry          time.Time           `json:"-"`
 	CreatedAt            utils.FormattedTime `json:"created_at"`
 	UpdatedAt            utils.FormattedTime `json:"updated_at"`
@@ -25,4 +24,26 @@ type Person struct {
 	Membership           string              `json:"member_ship_type"`
 	SubscriptionExpiry   time.Time           `json:"subscription_expiry"`
 	UpgradeRequestStatus string              `json:"upgrade_request_status" gorm:"type:varchar(20);default:null"`
+
+	// Purchasing needs a confirmed email address
+	EmailVerifiedAt   *time.Time `json:"email_verified_at"`
+	EmailVerifyToken  string     `json:"-" gorm:"index"`
+	EmailVerifyExpiry time.Time  `json:"-"`
+
+	// TOTP two-factor authentication
+	TOTPSecret        string     `json:"-"`
+	TOTPPendingSecret string     `json:"-"` // set during enrolment until the first code is confirmed
+	TOTPEnabledAt     *time.Time `json:"two_factor_enabled_at"`
+	TOTPLastStep      int64      `json:"-"` // last accepted time step, so a code cannot be used twice
+
+	// Sent with the lockout email, lifts a login lockout early
+	UnlockToken  string    `json:"-" gorm:"index"`
+	UnlockExpiry time.Time `json:"-"`
+
+	// Set by a superadmin through services.SetAccountStatus
+	Status          AccountStatus `json:"status" gorm:"type:varchar(20);default:active;index"`
+	StatusReason    string        `json:"status_reason,omitempty"`
+	StatusUntil     *time.Time    `json:"status_until,omitempty"` // end of a suspension, nil until lifted
+	StatusChangedAt *time.Time    `json:"status_changed_at,omitempty"`
+	StatusChangedBy uint          `json:"-"`
 }
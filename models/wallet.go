@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// Wallet is a user's prepaid balance in one currency. Amounts are subunits.
+// Held is the part of Balance reserved for payments still in progress.
+type Wallet struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"uniqueIndex:idx_wallet_user_currency" json:"user_id"`
+	Currency  string    `gorm:"uniqueIndex:idx_wallet_user_currency" json:"currency"`
+	Balance   int64     `gorm:"check:chk_wallet_balance,balance >= 0" json:"balance"`
+	Held      int64     `gorm:"check:chk_wallet_held,held >= 0 AND held <= balance" json:"held"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WalletEntry is one change to a wallet balance, kept as its history
+type WalletEntry struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	WalletID      uint      `gorm:"index" json:"wallet_id"`
+	UserID        uint      `gorm:"index" json:"user_id"`
+	Kind          string    `json:"kind"`   // "topup", "purchase", "rent", "refund"
+	Amount        int64     `json:"amount"` // subunits, negative for money spent
+	BalanceAfter  int64     `json:"balance_after"`
+	Reference     string    `gorm:"index" json:"reference"`
+	TransactionID *uint     `json:"transaction_id,omitempty"`
+	Description   string    `json:"description"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// WalletHold reserves wallet funds for a payment until it is captured or released
+type WalletHold struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	WalletID  uint      `gorm:"index" json:"wallet_id"`
+	UserID    uint      `gorm:"index" json:"user_id"`
+	Amount    int64     `json:"amount"` // subunits
+	Reference string    `gorm:"uniqueIndex" json:"reference"`
+	Status    string    `gorm:"index" json:"status"` // "held", "captured" or "released"
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// WebhookEvent is a provider notification as it arrived, kept so it can be
+// de-duplicated, retried after a failure and replayed by a superadmin.
+type WebhookEvent struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	Provider       string     `gorm:"uniqueIndex:idx_webhook_provider_event;index" json:"provider"` // payments channel, e.g. "Paystack"
+	EventID        string     `gorm:"uniqueIndex:idx_webhook_provider_event" json:"event_id"`       // the provider's id, or a hash of the body
+	Source         string     `json:"source"`                                                       // the endpoint it came in on, picks the processor
+	Type           string     `gorm:"index" json:"type"`
+	Reference      string     `gorm:"index" json:"reference"`
+	Payload        string     `gorm:"type:text" json:"payload"`
+	SignatureValid bool       `json:"signature_valid"`
+	Status         string     `gorm:"index" json:"status"` // "received", "processed", "failed" or "rejected"
+	Attempts       int        `json:"attempts"`
+	LastError      string     `gorm:"type:text" json:"last_error,omitempty"`
+	NextAttemptAt  *time.Time `gorm:"index" json:"next_attempt_at,omitempty"`
+	ProcessedAt    *time.Time `json:"processed_at,omitempty"`
+	ReplayedBy     *uint      `json:"replayed_by,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,307 @@
+package mpesa
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"Api/database"
+	"Api/models"
+	"Api/payments"
+	"Api/services"
+)
+
+// STKPushHandler starts an M-Pesa checkout for a bot purchase or rental
+func STKPushHandler(ctx *gin.Context) {
+	var input struct {
+		BotID       uint   `json:"bot_id" binding:"required"`
+		PaymentType string `json:"payment_type" binding:"required"`
+		Phone       string `json:"phone" binding:"required"`
+		Description string `json:"description"`
+		PlanID      uint   `json:"plan_id"`     // rental plan, required when the bot has more than one
+		CouponCode  string `json:"coupon_code"` // optional promo code
+	}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		log.Printf("Invalid STK push input: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+
+	userID := ctx.GetUint("user_id")
+	log.Printf("M-Pesa checkout for user_id: %d, bot_id: %d, payment_type: %s", userID, input.BotID, input.PaymentType)
+
+	// M-Pesa only takes shillings
+	checkout, err := services.PrepareCheckout(services.CheckoutInput{
+		UserID:      userID,
+		BotID:       input.BotID,
+		PaymentType: input.PaymentType,
+		PlanID:      input.PlanID,
+		CouponCode:  input.CouponCode,
+		Currency:    "KES",
+		Channel:     Channel,
+		Description: input.Description,
+		AutoRenew:   new(bool), // there is no saved card to charge, rentals are renewed by hand
+	})
+	if err != nil {
+		respondCheckoutError(ctx, err)
+		return
+	}
+
+	provider, err := payments.Get(Channel)
+	if err != nil {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"message": "M-Pesa is not available"})
+		return
+	}
+
+	// STK push rounds up to whole shillings, record what the buyer is actually asked for
+	amountMinor := (payments.ToMinor(checkout.Quote.Amount) + 99) / 100 * 100
+	amount := payments.FromMinor(amountMinor)
+
+	resp, err := provider.Initialize(payments.InitializeRequest{
+		Phone:     input.Phone,
+		Amount:    amountMinor,
+		Currency:  "KES",
+		Reference: fmt.Sprintf("ALG%d", input.BotID),
+	})
+	if err != nil {
+		log.Printf("STK push failed: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Failed to send M-Pesa prompt", "error": err.Error()})
+		return
+	}
+
+	transaction, err := checkout.Record(resp.Reference, amount)
+	if err != nil {
+		log.Printf("Failed to save transaction: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to save transaction"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "M-Pesa prompt sent, enter your PIN to complete payment",
+		"data": gin.H{
+			"reference": transaction.Reference,
+			"amount":    amount,
+		},
+	})
+}
+
+// respondCheckoutError maps a refused checkout to an HTTP response
+func respondCheckoutError(ctx *gin.Context, err error) {
+	var pending *services.PendingCheckoutError
+	switch {
+	case errors.As(err, &pending):
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": pending.Error(), "reference": pending.Reference})
+	case errors.Is(err, services.ErrBotNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "Bot not found"})
+	case errors.Is(err, services.ErrCreatorNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "Admin not found"})
+	case errors.Is(err, services.ErrSellerUnavailable):
+		ctx.JSON(http.StatusForbidden, gin.H{"message": err.Error()})
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+	}
+}
+
+// Sources stored on webhook events received by the M-Pesa callbacks
+const (
+	SourceSTK        = "mpesa.stk"
+	SourceB2CResult  = "mpesa.b2c_result"
+	SourceB2CTimeout = "mpesa.b2c_timeout"
+)
+
+// RegisterWebhookProcessors lets stored M-Pesa callbacks be retried and replayed
+func RegisterWebhookProcessors() {
+	services.RegisterWebhookProcessor(SourceSTK, processSTKCallback)
+	services.RegisterWebhookProcessor(SourceB2CResult, func(body []byte) error { return processB2C(body, false) })
+	services.RegisterWebhookProcessor(SourceB2CTimeout, func(body []byte) error { return processB2C(body, true) })
+}
+
+// CallbackHandler receives Daraja STK results. It always acknowledges valid callbacks,
+// otherwise Safaricom keeps retrying them; failures are retried from the stored event.
+func CallbackHandler(ctx *gin.Context) {
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"ResultCode": 1, "ResultDesc": "Invalid request"})
+		return
+	}
+
+	provider, err := payments.Get(Channel)
+	if err != nil {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"ResultCode": 1, "ResultDesc": "M-Pesa is not available"})
+		return
+	}
+
+	event, err := provider.ParseWebhook(body, ctx.Query("token"))
+	if errors.Is(err, payments.ErrInvalidSignature) {
+		log.Printf("Rejected M-Pesa callback with bad token from %s", ctx.ClientIP())
+		recordRejected(SourceSTK, body)
+		ctx.JSON(http.StatusUnauthorized, gin.H{"ResultCode": 1, "ResultDesc": "Unauthorized"})
+		return
+	}
+	if err != nil {
+		log.Printf("Invalid M-Pesa callback: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"ResultCode": 1, "ResultDesc": "Invalid payload"})
+		return
+	}
+	log.Printf("M-Pesa callback received: event=%s, reference=%s", event.Type, event.Reference)
+
+	storeAndProcess(ctx, services.WebhookDelivery{
+		Provider:       Channel,
+		Source:         SourceSTK,
+		EventID:        event.ID,
+		Type:           event.Type,
+		Reference:      event.Reference,
+		Body:           body,
+		SignatureValid: true,
+	})
+}
+
+// storeAndProcess records an authenticated callback and processes it unless it was
+// already processed. Daraja gets an acknowledgement once the callback is stored.
+func storeAndProcess(ctx *gin.Context, delivery services.WebhookDelivery) {
+	stored, created, err := services.RecordWebhook(delivery)
+	if err != nil {
+		log.Printf("Failed to store M-Pesa callback %s: %v", delivery.Reference, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"ResultCode": 1, "ResultDesc": "Temporary failure"})
+		return
+	}
+	if !created && stored.Status == "processed" {
+		log.Printf("Duplicate M-Pesa callback %s ignored", stored.EventID)
+	} else if _, err := services.ProcessWebhook(stored.ID, false); err != nil {
+		log.Printf("Failed to process M-Pesa callback %s, will retry: %v", delivery.Reference, err)
+	}
+	ctx.JSON(http.StatusOK, gin.H{"ResultCode": 0, "ResultDesc": "Accepted"})
+}
+
+func recordRejected(source string, body []byte) {
+	if _, _, err := services.RecordWebhook(services.WebhookDelivery{Provider: Channel, Source: source, Body: body}); err != nil {
+		log.Printf("Failed to store rejected M-Pesa callback: %v", err)
+	}
+}
+
+// processSTKCallback applies a stored STK callback
+func processSTKCallback(body []byte) error {
+	provider, err := payments.Get(Channel)
+	if err != nil {
+		return err
+	}
+	var event *payments.WebhookEvent
+	if _, ok := provider.(*Client); ok {
+		event, err = decodeSTKCallback(body)
+	} else {
+		// The fake provider checks no token
+		event, err = provider.ParseWebhook(body, "")
+	}
+	if err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	return HandleEvent(event)
+}
+
+// HandleEvent applies a decoded STK result to its transaction
+func HandleEvent(event *payments.WebhookEvent) error {
+	var transaction models.Transaction
+	if err := database.DB.Where("reference = ?", event.Reference).First(&transaction).Error; err != nil {
+		return services.ErrTransactionNotFound
+	}
+
+	if event.Type != "stk.success" {
+		return services.FailOrder(event.Reference, fmt.Sprintf("%v", event.Data["result_desc"]))
+	}
+
+	if event.Amount == 0 {
+		// Without the amount there is nothing to check the payment against. A failed
+		// order can still be fulfilled once the payment is confirmed another way.
+		log.Printf("M-Pesa callback for %s reports no amount, failing the order for review", event.Reference)
+		return services.FailOrder(event.Reference, "M-Pesa did not report the amount paid")
+	}
+	if _, err := services.FulfilOrder(event.Reference, payments.FromMinor(event.Amount), event.Currency); err != nil {
+		return err
+	}
+
+	if receipt, ok := event.Data["receipt"].(string); ok && receipt != "" {
+		return database.DB.Model(&transaction).Update("provider_ref", receipt).Error
+	}
+	return nil
+}
+
+// B2CResultHandler receives the outcome of a payout sent with B2C
+func B2CResultHandler(ctx *gin.Context) {
+	handleB2C(ctx, false)
+}
+
+// B2CTimeoutHandler receives B2C payouts that timed out in Safaricom's queue
+func B2CTimeoutHandler(ctx *gin.Context) {
+	handleB2C(ctx, true)
+}
+
+func handleB2C(ctx *gin.Context, timedOut bool) {
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"ResultCode": 1, "ResultDesc": "Invalid request"})
+		return
+	}
+
+	provider, err := payments.Get(Channel)
+	if err != nil {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"ResultCode": 1, "ResultDesc": "M-Pesa is not available"})
+		return
+	}
+	client, ok := provider.(*Client)
+	if !ok {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"ResultCode": 1, "ResultDesc": "M-Pesa B2C is not configured"})
+		return
+	}
+
+	source, eventType := SourceB2CResult, "b2c.result"
+	if timedOut {
+		source, eventType = SourceB2CTimeout, "b2c.timeout"
+	}
+
+	result, err := client.ParseB2CResult(body, ctx.Query("token"))
+	if errors.Is(err, payments.ErrInvalidSignature) {
+		log.Printf("Rejected B2C result with bad token from %s", ctx.ClientIP())
+		recordRejected(source, body)
+		ctx.JSON(http.StatusUnauthorized, gin.H{"ResultCode": 1, "ResultDesc": "Unauthorized"})
+		return
+	}
+	if err != nil {
+		log.Printf("Invalid B2C result: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"ResultCode": 1, "ResultDesc": "Invalid payload"})
+		return
+	}
+	log.Printf("B2C result received: conversation=%s, code=%d, desc=%s", result.Result.ConversationID, result.Result.ResultCode, result.Result.ResultDesc)
+
+	storeAndProcess(ctx, services.WebhookDelivery{
+		Provider:       Channel,
+		Source:         source,
+		EventID:        eventType + ":" + result.Result.ConversationID,
+		Type:           eventType,
+		Reference:      result.Result.ConversationID,
+		Body:           body,
+		SignatureValid: true,
+	})
+}
+
+// processB2C applies a stored B2C result to its payout. Timeouts are only logged.
+func processB2C(body []byte, timedOut bool) error {
+	result, err := decodeB2CResult(body)
+	if err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	if timedOut {
+		// A queue timeout says nothing about whether the money moved; the transfer may
+		// still go through, so the payout stays processing until its result arrives
+		log.Printf("B2C request %s timed out in the queue, payout left processing", result.Result.ConversationID)
+		return nil
+	}
+	success := result.Result.ResultCode == 0
+	if err := services.CompletePayout(result.Result.ConversationID, success, result.Result.ResultDesc); err != nil {
+		return fmt.Errorf("failed to complete payout %s: %w", result.Result.ConversationID, err)
+	}
+	return nil
+}
@@ -0,0 +1,409 @@
+package mpesa
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Api/internal/config"
+	"Api/payments"
+)
+
+// Channel is the payment channel recorded on M-Pesa transactions
+const Channel = "M-Pesa"
+
+const (
+	SandboxBaseURL  = "https://sandbox.safaricom.co.ke"
+	TransactionDesc = "AlgoCDK bot payment"
+
+	// Daraja answers STK queries with this error code while the buyer has not responded yet
+	stillProcessingCode = "500.001.1001"
+)
+
+var ErrRefundUnsupported = errors.New("M-Pesa refunds are not supported")
+
+// ======================
+// STRUCTS
+// ======================
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   string `json:"expires_in"`
+}
+
+type STKPushRequest struct {
+	BusinessShortCode string `json:"BusinessShortCode"`
+	Password          string `json:"Password"`
+	Timestamp         string `json:"Timestamp"`
+	TransactionType   string `json:"TransactionType"`
+	Amount            int64  `json:"Amount"`
+	PartyA            string `json:"PartyA"`
+	PartyB            string `json:"PartyB"`
+	PhoneNumber       string `json:"PhoneNumber"`
+	CallBackURL       string `json:"CallBackURL"`
+	AccountReference  string `json:"AccountReference"`
+	TransactionDesc   string `json:"TransactionDesc"`
+}
+
+type STKPushResponse struct {
+	MerchantRequestID   string `json:"MerchantRequestID"`
+	CheckoutRequestID   string `json:"CheckoutRequestID"`
+	ResponseCode        string `json:"ResponseCode"`
+	ResponseDescription string `json:"ResponseDescription"`
+	CustomerMessage     string `json:"CustomerMessage"`
+	ErrorCode           string `json:"errorCode"`
+	ErrorMessage        string `json:"errorMessage"`
+}
+
+type STKQueryResponse struct {
+	ResponseCode        string `json:"ResponseCode"`
+	ResponseDescription string `json:"ResponseDescription"`
+	ResultCode          string `json:"ResultCode"`
+	ResultDesc          string `json:"ResultDesc"`
+	CheckoutRequestID   string `json:"CheckoutRequestID"`
+	MerchantRequestID   string `json:"MerchantRequestID"`
+	ErrorCode           string `json:"errorCode"`
+	ErrorMessage        string `json:"errorMessage"`
+}
+
+// STKCallback is the body Daraja posts to CallBackURL once the buyer answers the prompt
+type STKCallback struct {
+	Body struct {
+		StkCallback struct {
+			MerchantRequestID string `json:"MerchantRequestID"`
+			CheckoutRequestID string `json:"CheckoutRequestID"`
+			ResultCode        int    `json:"ResultCode"`
+			ResultDesc        string `json:"ResultDesc"`
+			CallbackMetadata  struct {
+				Item []struct {
+					Name  string      `json:"Name"`
+					Value interface{} `json:"Value"`
+				} `json:"Item"`
+			} `json:"CallbackMetadata"`
+		} `json:"stkCallback"`
+	} `json:"Body"`
+}
+
+// Metadata returns a callback metadata item by name, e.g. "Amount" or "MpesaReceiptNumber"
+func (c *STKCallback) Metadata(name string) interface{} {
+	for _, item := range c.Body.StkCallback.CallbackMetadata.Item {
+		if item.Name == name {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+// ======================
+// CLIENT
+// ======================
+
+// Client talks to the Safaricom Daraja API and implements payments.PaymentProvider
+type Client struct {
+	BaseURL        string
+	ConsumerKey    string
+	ConsumerSecret string
+	ShortCode      string
+	PassKey        string
+	CallbackURL    string
+	CallbackSecret string
+	HTTP           *http.Client
+
+	// B2C payouts
+	InitiatorName      string
+	SecurityCredential string
+	B2CShortCode       string
+	B2CResultURL       string
+	B2CTimeoutURL      string
+
+	tokenMu     sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewClient builds a client from the MPESA_* settings
+func NewClient(cfg config.Mpesa) *Client {
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = SandboxBaseURL
+	}
+	return &Client{
+		BaseURL:        strings.TrimRight(baseURL, "/"),
+		ConsumerKey:    cfg.ConsumerKey,
+		ConsumerSecret: cfg.ConsumerSecret,
+		ShortCode:      cfg.ShortCode,
+		PassKey:        cfg.PassKey,
+		CallbackURL:    cfg.CallbackURL,
+		CallbackSecret: cfg.CallbackSecret,
+		HTTP:           &http.Client{Timeout: 30 * time.Second},
+
+		InitiatorName:      cfg.InitiatorName,
+		SecurityCredential: cfg.SecurityCredential,
+		B2CShortCode:       cfg.B2CShortCode,
+		B2CResultURL:       cfg.B2CResultURL,
+		B2CTimeoutURL:      cfg.B2CTimeoutURL,
+	}
+}
+
+// InitProvider registers the M-Pesa provider, or a fake one when PAYMENT_PROVIDER=fake
+func InitProvider() {
+	if payments.UseFake() {
+		payments.Register(payments.NewFakeProvider(Channel))
+		return
+	}
+	client := NewClient(config.Get().Mpesa)
+	if client.ConsumerKey == "" || client.ShortCode == "" || client.PassKey == "" {
+		log.Println("⚠️ M-Pesa credentials are not set, STK push requests will fail")
+	}
+	if client.CallbackSecret == "" {
+		log.Println("⚠️ MPESA_CALLBACK_SECRET is not set, every M-Pesa callback will be rejected")
+	}
+	payments.Register(client)
+}
+
+func (c *Client) Name() string { return Channel }
+
+// ======================
+// HELPERS
+// ======================
+func formatPhoneNumber(number string) (string, error) {
+	number = strings.TrimSpace(strings.ReplaceAll(strings.ReplaceAll(number, "+", ""), " ", ""))
+	if strings.HasPrefix(number, "0") {
+		return "254" + number[1:], nil
+	}
+	if strings.HasPrefix(number, "254") {
+		return number, nil
+	}
+	return "", fmt.Errorf("invalid phone number format")
+}
+
+func (c *Client) password(timestamp string) string {
+	return base64.StdEncoding.EncodeToString([]byte(c.ShortCode + c.PassKey + timestamp))
+}
+
+// ======================
+// ACCESS TOKEN
+// ======================
+func (c *Client) getAccessToken() (string, error) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	if c.token != "" && time.Now().Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	req, err := http.NewRequest("GET", c.BaseURL+"/oauth/v1/generate?grant_type=client_credentials", nil)
+	if err != nil {
+		return "", err
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte(c.ConsumerKey + ":" + c.ConsumerSecret))
+	req.Header.Add("Authorization", "Basic "+auth)
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(res.Body)
+	var token TokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("M-Pesa auth failed: %s", string(body))
+	}
+
+	expiresIn, _ := strconv.Atoi(token.ExpiresIn)
+	if expiresIn == 0 {
+		expiresIn = 3599
+	}
+	c.token = token.AccessToken
+	// Refresh a minute early so requests never race the expiry
+	c.tokenExpiry = time.Now().Add(time.Duration(expiresIn-60) * time.Second)
+	return c.token, nil
+}
+
+func (c *Client) post(path string, payload interface{}, out interface{}) error {
+	token, err := c.getAccessToken()
+	if err != nil {
+		return err
+	}
+
+	jsonData, _ := json.Marshal(payload)
+	req, _ := http.NewRequest("POST", c.BaseURL+path, bytes.NewBuffer(jsonData))
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Content-Type", "application/json")
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(res.Body)
+	log.Printf("M-Pesa %s response: %s", path, string(body))
+	return json.Unmarshal(body, out)
+}
+
+// ======================
+// STK PUSH REQUEST
+// ======================
+
+// SendSTKPush prompts the phone owner to pay amount whole shillings
+func (c *Client) SendSTKPush(phone string, amount int64, accountRef string) (*STKPushResponse, error) {
+	formatted, err := formatPhoneNumber(phone)
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := time.Now().Format("20060102150405")
+	payload := STKPushRequest{
+		BusinessShortCode: c.ShortCode,
+		Password:          c.password(timestamp),
+		Timestamp:         timestamp,
+		TransactionType:   "CustomerPayBillOnline",
+		Amount:            amount,
+		PartyA:            formatted,
+		PartyB:            c.ShortCode,
+		PhoneNumber:       formatted,
+		CallBackURL:       c.callbackURL(),
+		AccountReference:  accountRef,
+		TransactionDesc:   TransactionDesc,
+	}
+
+	var response STKPushResponse
+	if err := c.post("/mpesa/stkpush/v1/processrequest", payload, &response); err != nil {
+		return nil, err
+	}
+	if response.ResponseCode != "0" {
+		msg := response.ErrorMessage
+		if msg == "" {
+			msg = response.ResponseDescription
+		}
+		return nil, fmt.Errorf("M-Pesa STK push rejected: %s", msg)
+	}
+	return &response, nil
+}
+
+// callbackURL appends the shared secret so CallbackHandler can tell Daraja from strangers
+func (c *Client) callbackURL() string {
+	return withToken(c.CallbackURL, c.CallbackSecret)
+}
+
+// ======================
+// STK QUERY
+// ======================
+func (c *Client) QuerySTKPush(checkoutID string) (*STKQueryResponse, error) {
+	timestamp := time.Now().Format("20060102150405")
+	data := map[string]string{
+		"BusinessShortCode": c.ShortCode,
+		"Password":          c.password(timestamp),
+		"Timestamp":         timestamp,
+		"CheckoutRequestID": checkoutID,
+	}
+
+	var response STKQueryResponse
+	if err := c.post("/mpesa/stkpushquery/v1/query", data, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// ======================
+// PAYMENT PROVIDER
+// ======================
+
+// Initialize sends an STK push. The returned reference is Daraja's CheckoutRequestID,
+// which is what the callback and STK queries identify the payment by.
+func (c *Client) Initialize(in payments.InitializeRequest) (*payments.InitializeResponse, error) {
+	// STK push only accepts whole shillings
+	amount := (in.Amount + 99) / 100
+	resp, err := c.SendSTKPush(in.Phone, amount, in.Reference)
+	if err != nil {
+		return nil, err
+	}
+	return &payments.InitializeResponse{
+		AccessCode: resp.MerchantRequestID,
+		Reference:  resp.CheckoutRequestID,
+	}, nil
+}
+
+// Verify queries an STK push by CheckoutRequestID. Daraja does not report the
+// amount on queries, so Amount is left at zero.
+func (c *Client) Verify(reference string) (*payments.VerifyResult, error) {
+	resp, err := c.QuerySTKPush(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &payments.VerifyResult{Reference: reference, Currency: "KES", Channel: "mpesa"}
+	switch {
+	case resp.ErrorCode == stillProcessingCode:
+		result.Status = "pending"
+		result.Message = resp.ErrorMessage
+	case resp.ErrorCode != "":
+		return nil, fmt.Errorf("M-Pesa query failed: %s", resp.ErrorMessage)
+	case resp.ResultCode == "0":
+		result.Status = "success"
+		result.Message = resp.ResultDesc
+	default:
+		result.Status = "failed"
+		result.Message = resp.ResultDesc
+	}
+	return result, nil
+}
+
+// ParseWebhook decodes an STK callback. signature is the token query parameter
+// that was appended to the callback URL.
+func (c *Client) ParseWebhook(body []byte, signature string) (*payments.WebhookEvent, error) {
+	if !c.validToken(signature) {
+		return nil, payments.ErrInvalidSignature
+	}
+	return decodeSTKCallback(body)
+}
+
+// decodeSTKCallback decodes an STK callback body without checking its token
+func decodeSTKCallback(body []byte) (*payments.WebhookEvent, error) {
+	var callback STKCallback
+	if err := json.Unmarshal(body, &callback); err != nil {
+		return nil, err
+	}
+	stk := callback.Body.StkCallback
+	if stk.CheckoutRequestID == "" {
+		return nil, fmt.Errorf("missing CheckoutRequestID")
+	}
+
+	event := &payments.WebhookEvent{
+		ID:        "stk:" + stk.CheckoutRequestID,
+		Type:      "stk.failed",
+		Reference: stk.CheckoutRequestID,
+		Currency:  "KES",
+		Data: map[string]interface{}{
+			"result_code": stk.ResultCode,
+			"result_desc": stk.ResultDesc,
+		},
+	}
+	if stk.ResultCode == 0 {
+		event.Type = "stk.success"
+		if amount, ok := callback.Metadata("Amount").(float64); ok {
+			event.Amount = payments.ToMinor(amount)
+		}
+		if receipt, ok := callback.Metadata("MpesaReceiptNumber").(string); ok {
+			event.Data["receipt"] = receipt
+		}
+	}
+	return event, nil
+}
+
+// Refund is not available for STK payments yet
+func (c *Client) Refund(reference string, amount int64) (*payments.RefundResult, error) {
+	return nil, ErrRefundUnsupported
+}
@@ -0,0 +1,368 @@
+package payments
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const defaultPaystackBaseURL = "https://api.paystack.co"
+
+// PaystackProvider talks to the Paystack REST API
+type PaystackProvider struct {
+	BaseURL   string
+	SecretKey string
+	Client    *http.Client
+}
+
+// NewPaystackProvider creates a Paystack client; an empty baseURL uses the live API
+func NewPaystackProvider(baseURL, secretKey string) *PaystackProvider {
+	if baseURL == "" {
+		baseURL = defaultPaystackBaseURL
+	}
+	return &PaystackProvider{
+		BaseURL:   strings.TrimRight(baseURL, "/"),
+		SecretKey: secretKey,
+		Client:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (p *PaystackProvider) Name() string { return ChannelPaystack }
+
+// paystackEnvelope is the common shape of every Paystack response
+type paystackEnvelope struct {
+	Status  bool            `json:"status"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// do sends a request to Paystack and decodes the "data" field into out
+// paystackError is a request Paystack answered with "status": false
+type paystackError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *paystackError) Error() string {
+	return fmt.Sprintf("Paystack error: %s", e.Message)
+}
+
+func (p *PaystackProvider) do(method, path string, payload interface{}, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(raw)
+	}
+
+	req, err := http.NewRequest(method, p.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Authorization", "Bearer "+p.SecretKey)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Cache-Control", "no-cache")
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Paystack API error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	log.Printf("Paystack %s %s response: %s", method, path, string(respBody))
+
+	var envelope paystackEnvelope
+	if err := json.Unmarshal(respBody, &envelope); err != nil {
+		return fmt.Errorf("failed to parse Paystack response: %v", err)
+	}
+	if !envelope.Status {
+		return &paystackError{StatusCode: resp.StatusCode, Message: envelope.Message}
+	}
+	if out != nil && len(envelope.Data) > 0 {
+		if err := json.Unmarshal(envelope.Data, out); err != nil {
+			return fmt.Errorf("failed to parse Paystack response: %v", err)
+		}
+	}
+	return nil
+}
+
+// Initialize calls POST /transaction/initialize
+func (p *PaystackProvider) Initialize(in InitializeRequest) (*InitializeResponse, error) {
+	payload := map[string]interface{}{
+		"email":        in.Email,
+		"amount":       in.Amount,
+		"reference":    in.Reference,
+		"callback_url": in.CallbackURL,
+		"currency":     in.Currency,
+	}
+	if in.Subaccount != "" {
+		payload["subaccount"] = in.Subaccount
+		payload["bearer"] = "subaccount"
+		payload["transaction_charge"] = in.TransactionCharge
+	}
+	if len(in.Metadata) > 0 {
+		payload["metadata"] = in.Metadata
+	}
+
+	var out InitializeResponse
+	if err := p.do("POST", "/transaction/initialize", payload, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// paystackTransaction is the transaction object returned by verify and webhooks
+type paystackTransaction struct {
+	ID        int64  `json:"id"`
+	Status    string `json:"status"`
+	Reference string `json:"reference"`
+	Amount    int64  `json:"amount"`
+	Currency  string `json:"currency"`
+	Fees      int64  `json:"fees"`
+	Channel   string `json:"channel"`
+	PaidAt    string `json:"paid_at"`
+	Message   string `json:"gateway_response"`
+	Customer  struct {
+		Email string `json:"email"`
+	} `json:"customer"`
+	Authorization struct {
+		AuthorizationCode string `json:"authorization_code"`
+		Reusable          bool   `json:"reusable"`
+		Last4             string `json:"last4"`
+		CardType          string `json:"card_type"`
+	} `json:"authorization"`
+}
+
+func (t paystackTransaction) toResult() *VerifyResult {
+	paidAt, _ := time.Parse(time.RFC3339, t.PaidAt)
+	result := &VerifyResult{
+		Reference:     t.Reference,
+		Status:        t.Status,
+		Amount:        t.Amount,
+		Currency:      t.Currency,
+		Fees:          t.Fees,
+		Channel:       t.Channel,
+		CustomerEmail: t.Customer.Email,
+		PaidAt:        paidAt,
+		Message:       t.Message,
+	}
+	if t.Authorization.AuthorizationCode != "" {
+		result.Authorization = &Authorization{
+			Code:     t.Authorization.AuthorizationCode,
+			Reusable: t.Authorization.Reusable,
+			Last4:    t.Authorization.Last4,
+			Brand:    t.Authorization.CardType,
+		}
+	}
+	return result
+}
+
+// Verify calls GET /transaction/verify/:reference
+func (p *PaystackProvider) Verify(reference string) (*VerifyResult, error) {
+	var out paystackTransaction
+	if err := p.do("GET", "/transaction/verify/"+reference, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.toResult(), nil
+}
+
+// ChargeAuthorization calls POST /transaction/charge_authorization
+func (p *PaystackProvider) ChargeAuthorization(in ChargeRequest) (*VerifyResult, error) {
+	payload := map[string]interface{}{
+		"authorization_code": in.AuthorizationCode,
+		"email":              in.Email,
+		"amount":             in.Amount,
+		"reference":          in.Reference,
+		"currency":           in.Currency,
+	}
+	if in.Subaccount != "" {
+		payload["subaccount"] = in.Subaccount
+		payload["bearer"] = "subaccount"
+		payload["transaction_charge"] = in.TransactionCharge
+	}
+
+	var out paystackTransaction
+	if err := p.do("POST", "/transaction/charge_authorization", payload, &out); err != nil {
+		return nil, err
+	}
+	return out.toResult(), nil
+}
+
+// ListTransactions pages through GET /transaction for payments created between from and to
+func (p *PaystackProvider) ListTransactions(from, to time.Time) ([]VerifyResult, error) {
+	const perPage = 100
+	var results []VerifyResult
+	for page := 1; ; page++ {
+		path := fmt.Sprintf("/transaction?perPage=%d&page=%d&from=%s&to=%s",
+			perPage, page, url.QueryEscape(from.Format(time.RFC3339)), url.QueryEscape(to.Format(time.RFC3339)))
+		var out []paystackTransaction
+		if err := p.do("GET", path, nil, &out); err != nil {
+			return nil, err
+		}
+		for _, t := range out {
+			results = append(results, *t.toResult())
+		}
+		if len(out) < perPage {
+			return results, nil
+		}
+	}
+}
+
+// ParseWebhook checks the X-Paystack-Signature HMAC and decodes the event
+func (p *PaystackProvider) ParseWebhook(body []byte, signature string) (*WebhookEvent, error) {
+	h := hmac.New(sha512.New, []byte(p.SecretKey))
+	h.Write(body)
+	expected := hex.EncodeToString(h.Sum(nil))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return nil, ErrInvalidSignature
+	}
+	return DecodePaystackEvent(body)
+}
+
+// DecodePaystackEvent decodes a Paystack-shaped event body without checking its signature.
+// Use it only on bodies that were authenticated when they arrived.
+func DecodePaystackEvent(body []byte) (*WebhookEvent, error) {
+	var raw struct {
+		Event string                 `json:"event"`
+		Data  map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, err
+	}
+
+	event := &WebhookEvent{Type: raw.Event, Data: raw.Data}
+	if ref, ok := raw.Data["reference"].(string); ok {
+		event.Reference = ref
+	}
+	// Refund and dispute events carry the original charge under "transaction"
+	if trx, ok := raw.Data["transaction"].(map[string]interface{}); ok && event.Reference == "" {
+		if ref, ok := trx["reference"].(string); ok {
+			event.Reference = ref
+		}
+	}
+	if ref, ok := raw.Data["transaction_reference"].(string); ok && event.Reference == "" {
+		event.Reference = ref
+	}
+	if amount, ok := raw.Data["amount"].(float64); ok {
+		event.Amount = int64(amount)
+	}
+	if currency, ok := raw.Data["currency"].(string); ok {
+		event.Currency = currency
+	}
+	if id, ok := raw.Data["id"].(float64); ok {
+		event.ID = fmt.Sprintf("%s:%d", raw.Event, int64(id))
+	}
+	return event, nil
+}
+
+// Refund calls POST /refund
+func (p *PaystackProvider) Refund(reference string, amount int64) (*RefundResult, error) {
+	payload := map[string]interface{}{"transaction": reference}
+	if amount > 0 {
+		payload["amount"] = amount
+	}
+
+	var out struct {
+		ID     int64  `json:"id"`
+		Status string `json:"status"`
+		Amount int64  `json:"amount"`
+	}
+	if err := p.do("POST", "/refund", payload, &out); err != nil {
+		return nil, err
+	}
+	return &RefundResult{
+		ID:        fmt.Sprintf("%d", out.ID),
+		Reference: reference,
+		Status:    out.Status,
+		Amount:    out.Amount,
+	}, nil
+}
+
+// Transfer creates a transfer recipient and calls POST /transfer from the Paystack balance
+func (p *PaystackProvider) Transfer(in TransferRequest) (*TransferResult, error) {
+	recipientType := "nuban"
+	if in.Currency == "KES" {
+		recipientType = "kepss"
+	}
+	accountNumber, bankCode := in.AccountNumber, in.BankCode
+	if in.Phone != "" {
+		recipientType, accountNumber, bankCode = "mobile_money", in.Phone, "MPESA"
+	}
+
+	var recipient struct {
+		RecipientCode string `json:"recipient_code"`
+	}
+	if err := p.do("POST", "/transferrecipient", map[string]interface{}{
+		"type":           recipientType,
+		"name":           in.Name,
+		"account_number": accountNumber,
+		"bank_code":      bankCode,
+		"currency":       in.Currency,
+	}, &recipient); err != nil {
+		// No transfer has been attempted yet
+		return nil, fmt.Errorf("%w: %v", ErrTransferRejected, err)
+	}
+
+	var out struct {
+		TransferCode string `json:"transfer_code"`
+		Status       string `json:"status"`
+	}
+	if err := p.do("POST", "/transfer", map[string]interface{}{
+		"source":    "balance",
+		"amount":    in.Amount,
+		"currency":  in.Currency,
+		"recipient": recipient.RecipientCode,
+		"reference": in.Reference,
+		"reason":    in.Reason,
+	}, &out); err != nil {
+		// A 4xx answer is a refusal; a server error or a lost response may still
+		// have moved the money
+		var apiErr *paystackError
+		if errors.As(err, &apiErr) && apiErr.StatusCode < http.StatusInternalServerError {
+			return nil, fmt.Errorf("%w: %v", ErrTransferRejected, err)
+		}
+		return nil, err
+	}
+
+	status := out.Status
+	if status == "otp" || status == "received" {
+		// OTP must be disabled on the integration for automated payouts; treat as in flight
+		status = "pending"
+	}
+	return &TransferResult{ID: out.TransferCode, Status: status}, nil
+}
+
+// CreateSubaccount calls POST /subaccount and returns the subaccount code
+func (p *PaystackProvider) CreateSubaccount(in SubaccountRequest) (string, error) {
+	payload := map[string]interface{}{
+		"business_name":     in.BusinessName,
+		"settlement_bank":   in.BankCode,
+		"account_number":    in.AccountNumber,
+		"percentage_charge": in.PercentageCharge,
+	}
+
+	var out struct {
+		SubaccountCode string `json:"subaccount_code"`
+	}
+	if err := p.do("POST", "/subaccount", payload, &out); err != nil {
+		return "", err
+	}
+	if out.SubaccountCode == "" {
+		return "", fmt.Errorf("failed to parse Paystack response: no subaccount_code")
+	}
+	return out.SubaccountCode, nil
+}
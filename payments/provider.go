@@ -0,0 +1,238 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"Api/internal/config"
+)
+
+// Channel names stored in models.Transaction.PaymentChannel
+const (
+	ChannelPaystack = "Paystack"
+	ChannelMpesa    = "M-Pesa"
+	ChannelWallet   = "Wallet" // prepaid balance, settled without a provider
+)
+
+// ErrInvalidSignature is returned by ParseWebhook when the payload was not signed by the provider
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// ErrTransferRejected is wrapped by Transfer errors when the provider certainly did
+// not take the transfer. Any other Transfer error leaves the outcome unknown.
+var ErrTransferRejected = errors.New("transfer rejected")
+
+// PaymentProvider is implemented by every payment gateway the checkout can talk to
+type PaymentProvider interface {
+	// Name returns the channel name recorded on transactions, e.g. "Paystack"
+	Name() string
+	// Initialize starts a payment and returns where the buyer should be sent to pay
+	Initialize(req InitializeRequest) (*InitializeResponse, error)
+	// Verify asks the provider for the final state of a payment
+	Verify(reference string) (*VerifyResult, error)
+	// ParseWebhook authenticates and decodes a raw webhook body
+	ParseWebhook(body []byte, signature string) (*WebhookEvent, error)
+	// Refund returns money for a payment; amount 0 refunds the full amount
+	Refund(reference string, amount int64) (*RefundResult, error)
+}
+
+// RecurringCharger is implemented by providers that can charge a saved card again
+type RecurringCharger interface {
+	ChargeAuthorization(req ChargeRequest) (*VerifyResult, error)
+}
+
+// TransactionLister is implemented by providers that can list their transactions for reconciliation
+type TransactionLister interface {
+	ListTransactions(from, to time.Time) ([]VerifyResult, error)
+}
+
+// Transferer is implemented by providers that can send money out to a creator
+type Transferer interface {
+	Transfer(req TransferRequest) (*TransferResult, error)
+}
+
+// SubaccountCreator is implemented by providers that support split payments to creators
+type SubaccountCreator interface {
+	CreateSubaccount(req SubaccountRequest) (string, error)
+}
+
+// InitializeRequest describes a payment to start. Amounts are in the currency's subunit (cents, kobo).
+type InitializeRequest struct {
+	Email             string
+	Phone             string // required by M-Pesa STK push
+	Amount            int64
+	Currency          string
+	Reference         string
+	CallbackURL       string
+	Subaccount        string
+	TransactionCharge int64
+	Metadata          map[string]interface{}
+}
+
+// InitializeResponse is what the frontend needs to continue checkout
+type InitializeResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+	AccessCode       string `json:"access_code"`
+	Reference        string `json:"reference"`
+}
+
+// VerifyResult is the provider's view of a payment
+type VerifyResult struct {
+	Reference     string    `json:"reference"`
+	Status        string    `json:"status"` // "success", "failed", "abandoned", "pending"
+	Amount        int64     `json:"amount"`
+	Currency      string    `json:"currency"`
+	Fees          int64     `json:"fees"`
+	Channel       string    `json:"channel"`
+	CustomerEmail string    `json:"customer_email"`
+	PaidAt        time.Time `json:"paid_at"`
+	Message       string    `json:"message,omitempty"`
+
+	Authorization *Authorization `json:"-"`
+}
+
+// Authorization is a saved payment method that can be charged again without the buyer
+type Authorization struct {
+	Code     string
+	Reusable bool
+	Last4    string
+	Brand    string
+}
+
+// ChargeRequest charges a saved authorization. Amounts are in subunits.
+type ChargeRequest struct {
+	AuthorizationCode string
+	Email             string
+	Amount            int64
+	Currency          string
+	Reference         string
+	Subaccount        string
+	TransactionCharge int64
+}
+
+// TransferRequest sends Amount subunits to a bank account or, for M-Pesa, a phone number
+type TransferRequest struct {
+	Reference     string
+	Amount        int64
+	Currency      string
+	Reason        string
+	Name          string
+	BankCode      string
+	AccountNumber string
+	Phone         string
+}
+
+// TransferResult is the provider's answer to a transfer request
+type TransferResult struct {
+	ID      string `json:"id"`     // provider's transfer code, used to match later notifications
+	Status  string `json:"status"` // "pending", "success", "failed"
+	Message string `json:"message,omitempty"`
+}
+
+// WebhookEvent is a decoded provider notification
+type WebhookEvent struct {
+	ID        string                 `json:"id"`
+	Type      string                 `json:"event"`
+	Reference string                 `json:"reference"`
+	Amount    int64                  `json:"amount"`
+	Currency  string                 `json:"currency"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// RefundResult is the provider's answer to a refund request
+type RefundResult struct {
+	ID        string `json:"id"`
+	Reference string `json:"reference"`
+	Status    string `json:"status"` // "pending", "processed", "failed"
+	Amount    int64  `json:"amount"`
+}
+
+// SubaccountRequest holds the bank details used to open a creator subaccount
+type SubaccountRequest struct {
+	BusinessName     string
+	BankCode         string
+	AccountNumber    string
+	PercentageCharge float64
+}
+
+var (
+	providers   = map[string]PaymentProvider{}
+	providersMu sync.RWMutex
+	defaultName string
+)
+
+// Register makes a provider available under its channel name
+func Register(p PaymentProvider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[p.Name()] = p
+	if defaultName == "" {
+		defaultName = p.Name()
+	}
+}
+
+// Get returns the provider registered for a payment channel
+func Get(channel string) (PaymentProvider, error) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	p, ok := providers[channel]
+	if !ok {
+		return nil, fmt.Errorf("no payment provider registered for channel %q", channel)
+	}
+	return p, nil
+}
+
+// All returns every registered provider
+func All() []PaymentProvider {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	all := make([]PaymentProvider, 0, len(providers))
+	for _, p := range providers {
+		all = append(all, p)
+	}
+	return all
+}
+
+// Default returns the provider used for card checkout
+func Default() PaymentProvider {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	return providers[defaultName]
+}
+
+// InitProviders registers the card provider selected by PAYMENT_PROVIDER ("paystack" or "fake").
+// The fake provider is registered under the Paystack channel so existing routes keep working.
+func InitProviders() {
+	if UseFake() {
+		log.Println("⚠️ Using fake payment provider, no real charges will be made")
+		Register(NewFakeProvider(ChannelPaystack))
+		return
+	}
+	cfg := config.Get().Paystack
+	Register(NewPaystackProvider(cfg.BaseURL, cfg.SecretKey))
+}
+
+// UseFake reports whether PAYMENT_PROVIDER asks for fake providers
+func UseFake() bool {
+	return strings.EqualFold(config.Get().PaymentProvider, "fake")
+}
+
+// ToMinor converts a major-unit amount (e.g. 12.50 KES) to subunits (1250)
+func ToMinor(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// FromMinor converts subunits back to a major-unit amount
+func FromMinor(amount int64) float64 {
+	return float64(amount) / 100.0
+}
+
+// RoundMinor rounds a major-unit amount to whole subunits. Prices are rounded only
+// through here and ToMinor, so they always agree with what the ledger posts.
+func RoundMinor(amount float64) float64 {
+	return FromMinor(ToMinor(amount))
+}
@@ -2,104 +2,43 @@ package paystack
 
 import (
 	"bytes"
-	"crypto/hmac"
-	"crypto/sha512"
-	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 
 	"Api/database"
+	"Api/internal/config"
 	"Api/models"
+	"Api/payments"
+	"Api/services"
 )
 
-// PaystackSubaccount represents the subaccount object in Paystack response
-type PaystackSubaccount struct {
-	ID               int     `json:"id"`
-	SubaccountCode   string  `json:"subaccount_code"`
-	BusinessName     string  `json:"business_name"`
-	Description      string  `json:"description"`
-	PercentageCharge float64 `json:"percentage_charge"`
-	SettlementBank   string  `json:"settlement_bank"`
-	AccountNumber    string  `json:"account_number"`
-	Currency         string  `json:"currency"`
-	Active           bool    `json:"active"`
-	IsVerified       bool    `json:"is_verified"`
+// verifyWithProvider asks the payment provider for the state of a reference
+func verifyWithProvider(reference string) (*payments.VerifyResult, error) {
+	return payments.Default().Verify(reference)
 }
 
-// PaystackVerifyResponse struct for Paystack API response
-type PaystackVerifyResponse struct {
-	Status  bool   `json:"status"`
-	Message string `json:"message"`
-	Data    struct {
-		Status     string             `json:"status"`
-		Amount     int                `json:"amount"`
-		Reference  string             `json:"reference"`
-		Subaccount PaystackSubaccount `json:"subaccount"`
-		Fees       int                `json:"fees"`
-		Customer   struct {
-			Email string `json:"email"`
-		} `json:"customer"`
-	} `json:"data"`
-}
-
-// CreatePaystackSubaccount creates a subaccount for an admin
-func CreatePaystackSubaccount(admin *models.Admin) error {
-	log.Printf("Creating Paystack subaccount for admin ID %d", admin.ID)
-	payload := map[string]interface{}{
-		"business_name":     admin.AccountName,
-		"settlement_bank":   admin.BankCode,
-		"account_number":    admin.AccountNumber,
-		"percentage_charge": 10,
-	}
-
-	body, _ := json.Marshal(payload)
-	log.Printf("Subaccount payload: %s", string(body))
-	req, _ := http.NewRequest("POST", "https://api.paystack.co/subaccount", bytes.NewReader(body))
-	req.Header.Add("Authorization", "Bearer "+os.Getenv("PAYSTACK_SECRET_KEY"))
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Cache-Control", "no-cache")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// fulfilPayment grants the paid bot, books the provider fee and, for rentals paid by card,
+// saves the card for automatic renewal
+func fulfilPayment(reference string, result *payments.VerifyResult) (*services.FulfilmentResult, error) {
+	fulfilment, err := services.FulfilOrder(reference, payments.FromMinor(result.Amount), result.Currency)
 	if err != nil {
-		log.Printf("Paystack subaccount creation failed: %v", err)
-		return fmt.Errorf("Paystack API error: %v", err)
-	}
-	defer resp.Body.Close()
-
-	respBody, _ := io.ReadAll(resp.Body)
-	log.Printf("Subaccount response: %s", string(respBody))
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return fmt.Errorf("Paystack error: %s", string(respBody))
+		return nil, err
 	}
-
-	var result map[string]interface{}
-	if err := json.Unmarshal(respBody, &result); err != nil {
-		log.Printf("Failed to parse subaccount response: %v", err)
-		return fmt.Errorf("failed to parse Paystack response: %v", err)
+	if err := services.RecordProviderFee(reference, result.Fees); err != nil {
+		log.Printf("Failed to record fee on %s: %v", reference, err)
 	}
-
-	if data, ok := result["data"].(map[string]interface{}); ok {
-		admin.PaystackSubaccountCode = data["subaccount_code"].(string)
-		if err := database.DB.Save(admin).Error; err != nil {
-			log.Printf("Failed to save admin subaccount: %v", err)
-			return fmt.Errorf("failed to save admin subaccount: %v", err)
-		}
-		log.Printf("Subaccount created: %s", admin.PaystackSubaccountCode)
-		return nil
+	if err := services.StartSubscription(fulfilment, result.Authorization, result.CustomerEmail); err != nil {
+		// Access is already granted, the renter can still renew by hand
+		log.Printf("Failed to start subscription for %s: %v", reference, err)
 	}
-
-	log.Printf("No subaccount_code in response")
-	return fmt.Errorf("failed to parse Paystack response: no data field")
+	return fulfilment, nil
 }
 
 // InitializePayment handles payment initialization
@@ -109,6 +48,10 @@ func InitializePayment(ctx *gin.Context) {
 		BotID       uint    `json:"bot_id"`
 		PaymentType string  `json:"payment_type"`
 		Description string  `json:"description"`
+		PlanID      uint    `json:"plan_id"`     // rental plan, required when the bot has more than one
+		AutoRenew   *bool   `json:"auto_renew"`  // rentals renew automatically unless set to false
+		CouponCode  string  `json:"coupon_code"` // optional promo code
+		Currency    string  `json:"currency"`    // currency to pay in, defaults to the bot's
 	}
 
 	if err := ctx.ShouldBindJSON(&input); err != nil {
@@ -120,171 +63,93 @@ func InitializePayment(ctx *gin.Context) {
 	userID := ctx.GetUint("user_id")
 	log.Printf("Initializing payment for user_id: %d, bot_id: %d, payment_type: %s", userID, input.BotID, input.PaymentType)
 
-	var user models.Person
-	if err := database.DB.First(&user, userID).Error; err != nil {
-		log.Printf("User not found: %v", err)
-		ctx.JSON(http.StatusNotFound, gin.H{"message": "User not found"})
-		return
-	}
-
-	var bot models.Bot
-	if err := database.DB.First(&bot, input.BotID).Error; err != nil {
-		log.Printf("Bot not found: %v", err)
-		ctx.JSON(http.StatusNotFound, gin.H{"message": "Bot not found"})
-		return
-	}
-
-	var admin models.Admin
-	if err := database.DB.Where("person_id = ?", bot.OwnerID).First(&admin).Error; err != nil {
-		log.Printf("Admin not found: %v", err)
-		ctx.JSON(http.StatusNotFound, gin.H{"message": "Admin not found"})
-		return
-	}
-
-	var existing models.Transaction
-	if err := database.DB.
-		Where("user_id = ? AND bot_id = ? AND payment_type = ? AND status = ?", userID, input.BotID, input.PaymentType, "pending").
-		First(&existing).Error; err == nil {
-		log.Printf("Pending transaction exists: %s", existing.Reference)
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"message":   "A pending transaction already exists for this bot",
-			"reference": existing.Reference,
-		})
-		return
-	}
-
-	var subaccountCode string
-	var companyPercent float64
-	if admin.PaystackSubaccountCode == "" && (admin.BankCode == "" || admin.AccountNumber == "" || admin.AccountName == "") {
-		log.Printf("No subaccount or bank details for admin ID %d, company takes 100%%", admin.ID)
-		companyPercent = 1.0
-	} else {
-		if admin.PaystackSubaccountCode == "" {
-			log.Printf("Creating subaccount for admin ID %d", admin.ID)
-			if err := CreatePaystackSubaccount(&admin); err != nil {
-				log.Printf("Failed to create Paystack subaccount: %v", err)
-				ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to create Paystack subaccount", "error": err.Error()})
-				return
-			}
-		}
-		subaccountCode = admin.PaystackSubaccountCode
-		switch input.PaymentType {
-		case "purchase":
-			companyPercent = 0.30
-		case "rent":
-			companyPercent = 0.20
-		default:
-			log.Printf("Invalid payment type: %s", input.PaymentType)
-			ctx.JSON(http.StatusBadRequest, gin.H{"message": "Invalid payment type"})
-			return
-		}
-	}
-
-	if input.PaymentType == "purchase" {
-		var existing models.Transaction
-		if err := database.DB.
-			Where("user_id = ? AND bot_id = ? AND payment_type = ? AND status = ?", userID, input.BotID, "purchase", "success").
-			First(&existing).Error; err == nil {
-			log.Printf("Bot already purchased: %s", existing.Reference)
-			ctx.JSON(http.StatusBadRequest, gin.H{"message": "You already purchased this bot"})
-			return
-		}
-	}
-
-	var expectedPrice float64
-	if input.PaymentType == "purchase" {
-		expectedPrice = bot.Price
-	} else if input.PaymentType == "rent" {
-		expectedPrice = bot.RentPrice
-	} else {
-		log.Printf("Invalid payment type: %s", input.PaymentType)
-		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Invalid payment type"})
+	checkout, err := services.PrepareCheckout(services.CheckoutInput{
+		UserID:      userID,
+		BotID:       input.BotID,
+		PaymentType: input.PaymentType,
+		PlanID:      input.PlanID,
+		CouponCode:  input.CouponCode,
+		Currency:    input.Currency,
+		Channel:     payments.Default().Name(),
+		Description: input.Description,
+		AutoRenew:   input.AutoRenew,
+	})
+	if err != nil {
+		log.Printf("Checkout refused for user %d, bot %d: %v", userID, input.BotID, err)
+		respondCheckoutError(ctx, err)
 		return
 	}
-	if input.Amount < expectedPrice {
-		log.Printf("Invalid amount: %f, expected >= %f", input.Amount, expectedPrice)
-		ctx.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Amount must be at least KES %.2f", expectedPrice)})
+	if err := checkout.CheckAmount(input.Amount); err != nil {
+		log.Printf("Invalid amount: %f, expected >= %f", input.Amount, checkout.Quote.Amount)
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 		return
 	}
 
-	companyShare := input.Amount * companyPercent
-	adminShare := input.Amount - companyShare
 	reference := fmt.Sprintf("ALG_%d_%d", userID, time.Now().Unix())
-
-	payload := map[string]interface{}{
-		"email":        user.Email,
-		"amount":       int(input.Amount * 100),
-		"reference":    reference,
-		"callback_url": os.Getenv("PAYSTACK_CALLBACK_URL"),
-		"currency":     "KES",
-	}
-	if subaccountCode != "" {
-		payload["subaccount"] = subaccountCode
-		payload["bearer"] = "subaccount"
-		payload["transaction_charge"] = int(companyShare * 100)
-	}
-
-	body, _ := json.Marshal(payload)
-	log.Printf("Paystack payload: %s", string(body))
-	req, _ := http.NewRequest("POST", "https://api.paystack.co/transaction/initialize", bytes.NewReader(body))
-	req.Header.Add("Authorization", "Bearer "+os.Getenv("PAYSTACK_SECRET_KEY"))
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Cache-Control", "no-cache")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	result, err := payments.Default().Initialize(payments.InitializeRequest{
+		Email:             checkout.Buyer.Email,
+		Amount:            payments.ToMinor(input.Amount),
+		Currency:          checkout.Quote.Currency,
+		Reference:         reference,
+		CallbackURL:       config.Get().Paystack.CallbackURL,
+		Subaccount:        checkout.Subaccount,
+		TransactionCharge: checkout.PlatformCharge(input.Amount),
+	})
 	if err != nil {
-		log.Printf("Paystack request error: %v", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Paystack request failed", "error": err.Error()})
-		return
-	}
-	defer resp.Body.Close()
-
-	respBody, _ := io.ReadAll(resp.Body)
-	log.Printf("Paystack raw response: %s", string(respBody))
-	var result map[string]interface{}
-	if err := json.Unmarshal(respBody, &result); err != nil {
-		log.Printf("Failed to parse Paystack response: %v", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to parse Paystack response"})
-		return
-	}
-
-	if result["status"] != true {
-		log.Printf("Paystack initialization failed: %v", result["message"])
-		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Failed to initialize payment", "error": result["message"]})
+		log.Printf("Payment initialization failed: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Failed to initialize payment", "error": err.Error()})
 		return
 	}
 
-	transaction := models.Transaction{
-		UserID:         userID,
-		AdminID:        admin.ID,
-		BotID:          input.BotID,
-		Amount:         input.Amount,
-		CompanyShare:   companyShare,
-		AdminShare:     adminShare,
-		Status:         "pending",
-		Reference:      reference,
-		PaymentChannel: "Paystack",
-		PaymentType:    input.PaymentType,
-		Description:    input.Description,
-		CreatedAt:      time.Now(),
-	}
-
-	if err := database.DB.Create(&transaction).Error; err != nil {
+	if _, err := checkout.Record(reference, input.Amount); err != nil {
 		log.Printf("Failed to save transaction: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to save transaction"})
 		return
 	}
 
-	data := result["data"].(map[string]interface{})
-	log.Printf("Paystack response data: %v", data)
 	ctx.JSON(http.StatusOK, gin.H{
 		"message": "Payment initialized",
-		"data":    data,
+		"data":    result,
 	})
 }
 
+// respondCheckoutError maps a refused checkout to an HTTP response
+func respondCheckoutError(ctx *gin.Context, err error) {
+	var pending *services.PendingCheckoutError
+	switch {
+	case errors.As(err, &pending):
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": pending.Error(), "reference": pending.Reference})
+	case errors.Is(err, services.ErrBotNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "Bot not found"})
+	case errors.Is(err, services.ErrCreatorNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "Admin not found"})
+	case errors.Is(err, services.ErrSellerUnavailable):
+		ctx.JSON(http.StatusForbidden, gin.H{"message": err.Error()})
+	case errors.Is(err, services.ErrSubaccountFailed):
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to create Paystack subaccount", "error": err.Error()})
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+	}
+}
+
+// respondFulfilmentError maps a fulfilment failure to an HTTP response
+func respondFulfilmentError(ctx *gin.Context, err error) {
+	var tooLow *services.AmountTooLowError
+	switch {
+	case errors.As(err, &tooLow):
+		ctx.JSON(http.StatusForbidden, gin.H{"message": tooLow.Error()})
+	case errors.Is(err, services.ErrTransactionNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "Transaction not found"})
+	case errors.Is(err, services.ErrBotNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "Bot not found"})
+	case errors.Is(err, services.ErrTransactionClosed), errors.Is(err, services.ErrNotRefundable),
+		errors.Is(err, services.ErrCouponUsedUp), errors.Is(err, services.ErrCouponUserLimit):
+		ctx.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to fulfil payment", "error": err.Error()})
+	}
+}
+
 // VerifyPayment verifies a transaction with Paystack
 func VerifyPayment(ctx *gin.Context) {
 	reference := ctx.Query("reference")
@@ -295,169 +160,35 @@ func VerifyPayment(ctx *gin.Context) {
 	}
 	log.Printf("Verifying payment for reference: %s", reference)
 
-	req, _ := http.NewRequest("GET", fmt.Sprintf("https://api.paystack.co/transaction/verify/%s", reference), nil)
-	req.Header.Add("Authorization", "Bearer "+os.Getenv("PAYSTACK_SECRET_KEY"))
-	req.Header.Add("Accept", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	result, err := verifyWithProvider(reference)
 	if err != nil {
-		log.Printf("Paystack verify request failed: %v", err)
+		log.Printf("Payment verify request failed: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to verify payment", "error": err.Error()})
 		return
 	}
-	defer resp.Body.Close()
-
-	respBody, _ := io.ReadAll(resp.Body)
-	log.Printf("Paystack verify response: %s", string(respBody))
-	var result PaystackVerifyResponse
-	if err := json.Unmarshal(respBody, &result); err != nil {
-		log.Printf("Failed to parse Paystack verify response: %v", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to parse Paystack response", "error": err.Error()})
-		return
-	}
 
-	if !result.Status || result.Data.Status != "success" {
-		log.Printf("Payment verification failed: status=%v, message=%s, transaction_status=%s", result.Status, result.Message, result.Data.Status)
+	if result.Status != "success" {
+		log.Printf("Payment verification failed: message=%s, transaction_status=%s", result.Message, result.Status)
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"message": "Payment verification failed",
 			"error":   result.Message,
-			"status":  result.Data.Status,
-		})
-		return
-	}
-
-	tx := database.DB.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("Panic in VerifyPayment: %v", r)
-			tx.Rollback()
-			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
-		}
-	}()
-
-	var transaction models.Transaction
-	if err := tx.Where("reference = ?", reference).First(&transaction).Error; err != nil {
-		log.Printf("Transaction not found: %s", reference)
-		tx.Rollback()
-		ctx.JSON(http.StatusNotFound, gin.H{"message": "Transaction not found"})
-		return
-	}
-
-	var bot models.Bot
-	if err := tx.First(&bot, transaction.BotID).Error; err != nil {
-		log.Printf("Bot not found: %d", transaction.BotID)
-		tx.Rollback()
-		ctx.JSON(http.StatusNotFound, gin.H{"message": "Bot not found"})
-		return
-	}
-	amountPaid := float64(result.Data.Amount) / 100.0
-	expectedPrice := bot.Price
-	if transaction.PaymentType == "rent" {
-		expectedPrice = bot.RentPrice
-	}
-	if amountPaid < expectedPrice {
-		log.Printf("Payment amount too low: paid=%.2f, expected=%.2f", amountPaid, expectedPrice)
-		tx.Rollback()
-		ctx.JSON(http.StatusForbidden, gin.H{
-			"message": fmt.Sprintf("Payment amount (KES %.2f) is less than expected (KES %.2f)", amountPaid, expectedPrice),
+			"status":  result.Status,
 		})
 		return
 	}
 
-	transaction.Status = "success"
-	if err := tx.Save(&transaction).Error; err != nil {
-		log.Printf("Failed to update transaction: %v", err)
-		tx.Rollback()
-		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to update transaction"})
-		return
-	}
-
-	if transaction.PaymentType == "purchase" {
-		originalOwnerID := bot.OwnerID
-		bot.OwnerID = transaction.UserID
-		if err := tx.Save(&bot).Error; err != nil {
-			log.Printf("Failed to update bot ownership: %v", err)
-			tx.Rollback()
-			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to update bot ownership"})
-			return
-		}
-
-		sale := models.Sale{
-			BotID:     transaction.BotID,
-			SellerID:  originalOwnerID,
-			BuyerID:   transaction.UserID,
-			Amount:    transaction.Amount,
-			SaleType:  "purchase",
-			SaleDate:  time.Now(),
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}
-		if err := tx.Create(&sale).Error; err != nil {
-			log.Printf("Failed to record sale: %v", err)
-			tx.Rollback()
-			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to record sale"})
-			return
-		}
-
-		if err := tx.Where("user_id = ? AND bot_id = ?", originalOwnerID, bot.ID).Delete(&models.UserBot{}).Error; err != nil {
-			log.Printf("Failed to remove old owner access: %v", err)
-			tx.Rollback()
-			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to remove old owner access"})
-			return
-		}
-
-		var existing models.UserBot
-		if err := tx.Where("user_id = ? AND bot_id = ?", transaction.UserID, transaction.BotID).First(&existing).Error; err == gorm.ErrRecordNotFound {
-			userBot := models.UserBot{
-				UserID:       transaction.UserID,
-				BotID:        transaction.BotID,
-				AccessType:   "purchase",
-				IsActive:     true,
-				PurchaseDate: time.Now(),
-				CreatedAt:    time.Now(),
-				UpdatedAt:    time.Now(),
-			}
-			if err := tx.Create(&userBot).Error; err != nil {
-				log.Printf("Failed to create user_bot entry: %v", err)
-				tx.Rollback()
-				ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to create user_bot entry"})
-				return
-			}
-		}
-	} else if transaction.PaymentType == "rent" {
-		var existing models.UserBot
-		if err := tx.Where("user_id = ? AND bot_id = ?", transaction.UserID, transaction.BotID).First(&existing).Error; err == gorm.ErrRecordNotFound {
-			expiry := time.Now().Add(30 * 24 * time.Hour)
-			userBot := models.UserBot{
-				UserID:       transaction.UserID,
-				BotID:        transaction.BotID,
-				AccessType:   "rent",
-				IsActive:     true,
-				PurchaseDate: time.Now(),
-				ExpiryDate:   &expiry,
-				CreatedAt:    time.Now(),
-				UpdatedAt:    time.Now(),
-			}
-			if err := tx.Create(&userBot).Error; err != nil {
-				log.Printf("Failed to create user_bot entry for rent: %v", err)
-				tx.Rollback()
-				ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to create user_bot entry"})
-				return
-			}
-		}
-	}
-
-	if err := tx.Commit().Error; err != nil {
-		log.Printf("Failed to commit transaction: %v", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to commit transaction"})
+	fulfilment, err := fulfilPayment(reference, result)
+	if err != nil {
+		log.Printf("Failed to fulfil %s: %v", reference, err)
+		respondFulfilmentError(ctx, err)
 		return
 	}
 
 	log.Printf("Payment verified successfully for reference: %s", reference)
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": "Payment verified and bot access updated",
-		"data":    result.Data,
+		"message":    "Payment verified and bot access updated",
+		"data":       result,
+		"fulfilment": fulfilment,
 	})
 }
 
@@ -468,6 +199,8 @@ func FrontendCallback(ctx *gin.Context) {
 		BotID       uint    `json:"bot_id"`
 		AmountPaid  float64 `json:"amount_paid"`
 		PaymentType string  `json:"payment_type"`
+		PlanID      uint    `json:"plan_id"`
+		CouponCode  string  `json:"coupon_code"`
 	}
 
 	if err := ctx.ShouldBindJSON(&input); err != nil {
@@ -483,251 +216,86 @@ func FrontendCallback(ctx *gin.Context) {
 		return
 	}
 
-	req, _ := http.NewRequest("GET", fmt.Sprintf("https://api.paystack.co/transaction/verify/%s", input.Reference), nil)
-	req.Header.Add("Authorization", "Bearer "+os.Getenv("PAYSTACK_SECRET_KEY"))
-	req.Header.Add("Accept", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	result, err := verifyWithProvider(input.Reference)
 	if err != nil {
-		log.Printf("Paystack verify request failed: %v", err)
+		log.Printf("Payment verify request failed: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to verify payment", "error": err.Error()})
 		return
 	}
-	defer resp.Body.Close()
-
-	respBody, _ := io.ReadAll(resp.Body)
-	log.Printf("Paystack verify response: %s", string(respBody))
-	var result PaystackVerifyResponse
-	if err := json.Unmarshal(respBody, &result); err != nil {
-		log.Printf("Failed to parse Paystack verify response: %v", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to parse Paystack response", "error": err.Error()})
-		return
-	}
 
-	if !result.Status || result.Data.Status != "success" {
-		log.Printf("Payment verification failed: status=%v, message=%s, transaction_status=%s", result.Status, result.Message, result.Data.Status)
+	if result.Status != "success" {
+		log.Printf("Payment verification failed: message=%s, transaction_status=%s", result.Message, result.Status)
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"message": "Payment verification failed",
 			"error":   result.Message,
-			"status":  result.Data.Status,
+			"status":  result.Status,
 		})
 		return
 	}
 
-	tx := database.DB.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("Panic in FrontendCallback: %v", r)
-			tx.Rollback()
-			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
-		}
-	}()
-
+	// Popup payments may reach us before a transaction row exists, so record one first
 	var transaction models.Transaction
-	if err := tx.Where("reference = ?", input.Reference).First(&transaction).Error; err != nil {
+	if err := database.DB.Where("reference = ?", input.Reference).First(&transaction).Error; err != nil {
 		if err != gorm.ErrRecordNotFound {
 			log.Printf("Error finding transaction: %v", err)
-			tx.Rollback()
 			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Error finding transaction"})
 			return
 		}
 
-		userID := ctx.GetUint("user_id")
-		var bot models.Bot
-		if err := tx.First(&bot, input.BotID).Error; err != nil {
-			log.Printf("Bot not found: %d", input.BotID)
-			tx.Rollback()
-			ctx.JSON(http.StatusNotFound, gin.H{"message": "Bot not found"})
-			return
-		}
-
-		var admin models.Admin
-		if err := tx.Where("person_id = ?", bot.OwnerID).First(&admin).Error; err != nil {
-			log.Printf("Admin not found: %v", err)
-			tx.Rollback()
-			ctx.JSON(http.StatusNotFound, gin.H{"message": "Admin not found"})
+		// Price the bot in whatever currency the popup actually charged
+		checkout, err := services.PrepareCheckout(services.CheckoutInput{
+			UserID:      ctx.GetUint("user_id"),
+			BotID:       input.BotID,
+			PaymentType: input.PaymentType,
+			PlanID:      input.PlanID,
+			CouponCode:  input.CouponCode,
+			Currency:    result.Currency,
+			Channel:     payments.Default().Name(),
+			Paid:        true,
+		})
+		if err != nil {
+			log.Printf("Cannot record popup payment %s: %v", input.Reference, err)
+			respondCheckoutError(ctx, err)
 			return
 		}
-
-		expectedPrice := bot.Price
-		if input.PaymentType == "rent" {
-			expectedPrice = bot.RentPrice
-		}
-		if input.AmountPaid < expectedPrice {
-			log.Printf("Invalid amount: paid=%.2f, expected=%.2f", input.AmountPaid, expectedPrice)
-			tx.Rollback()
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": fmt.Sprintf("Amount must be at least KES %.2f", expectedPrice),
-			})
+		if err := checkout.CheckAmount(input.AmountPaid); err != nil {
+			log.Printf("Invalid amount: paid=%.2f, expected=%.2f", input.AmountPaid, checkout.Quote.Amount)
+			ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 			return
 		}
-
-		var companyPercent float64
-		if admin.PaystackSubaccountCode == "" && (admin.BankCode == "" || admin.AccountNumber == "" || admin.AccountName == "") {
-			companyPercent = 1.0
-		} else {
-			if admin.PaystackSubaccountCode == "" {
-				log.Printf("Creating subaccount for admin ID %d", admin.ID)
-				if err := CreatePaystackSubaccount(&admin); err != nil {
-					log.Printf("Failed to create Paystack subaccount: %v", err)
-					tx.Rollback()
-					ctx.JSON(http.StatusInternalServerError, gin.H{
-						"message": "Failed to create Paystack subaccount",
-						"error":   err.Error(),
-					})
-					return
-				}
-			}
-			companyPercent = 0.30
-			if input.PaymentType == "rent" {
-				companyPercent = 0.20
-			}
-		}
-
-		transaction = models.Transaction{
-			UserID:         userID,
-			AdminID:        admin.ID,
-			BotID:          input.BotID,
-			Amount:         input.AmountPaid,
-			CompanyShare:   input.AmountPaid * companyPercent,
-			AdminShare:     input.AmountPaid * (1 - companyPercent),
-			Status:         "pending",
-			Reference:      input.Reference,
-			PaymentChannel: "Paystack",
-			PaymentType:    input.PaymentType,
-			Description:    fmt.Sprintf("Payment for bot %d (%s)", input.BotID, input.PaymentType),
-			CreatedAt:      time.Now(),
-		}
-		if err := tx.Create(&transaction).Error; err != nil {
+		if _, err := checkout.Record(input.Reference, input.AmountPaid); err != nil {
 			log.Printf("Failed to create transaction: %v", err)
-			tx.Rollback()
 			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to save transaction"})
 			return
 		}
 	}
 
-	if transaction.Status == "pending" {
-		transaction.Status = "success"
-		transaction.Amount = input.AmountPaid
-		if err := tx.Save(&transaction).Error; err != nil {
-			log.Printf("Failed to update transaction: %v", err)
-			tx.Rollback()
-			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to update transaction"})
-			return
-		}
-
-		var bot models.Bot
-		if err := tx.First(&bot, transaction.BotID).Error; err != nil {
-			log.Printf("Bot not found: %d", transaction.BotID)
-			tx.Rollback()
-			ctx.JSON(http.StatusNotFound, gin.H{"message": "Bot not found"})
-			return
-		}
-		amountPaid := float64(result.Data.Amount) / 100.0
-		expectedPrice := bot.Price
-		if transaction.PaymentType == "rent" {
-			expectedPrice = bot.RentPrice
-		}
-		if amountPaid < expectedPrice {
-			log.Printf("Payment amount too low: paid=%.2f, expected=%.2f", amountPaid, expectedPrice)
-			tx.Rollback()
-			ctx.JSON(http.StatusForbidden, gin.H{
-				"message": fmt.Sprintf("Payment amount (KES %.2f) is less than expected (KES %.2f)", amountPaid, expectedPrice),
-			})
-			return
-		}
-
-		if transaction.PaymentType == "purchase" {
-			originalOwnerID := bot.OwnerID
-			bot.OwnerID = transaction.UserID
-			if err := tx.Save(&bot).Error; err != nil {
-				log.Printf("Failed to update bot ownership: %v", err)
-				tx.Rollback()
-				ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to update bot ownership"})
-				return
-			}
-
-			sale := models.Sale{
-				BotID:     transaction.BotID,
-				SellerID:  originalOwnerID,
-				BuyerID:   transaction.UserID,
-				Amount:    transaction.Amount,
-				SaleType:  "purchase",
-				SaleDate:  time.Now(),
-				CreatedAt: time.Now(),
-				UpdatedAt: time.Now(),
-			}
-			if err := tx.Create(&sale).Error; err != nil {
-				log.Printf("Failed to record sale: %v", err)
-				tx.Rollback()
-				ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to record sale"})
-				return
-			}
-
-			if err := tx.Where("user_id = ? AND bot_id = ?", originalOwnerID, bot.ID).Delete(&models.UserBot{}).Error; err != nil {
-				log.Printf("Failed to remove old owner access: %v", err)
-				tx.Rollback()
-				ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to remove old owner access"})
-				return
-			}
-
-			var existing models.UserBot
-			if err := tx.Where("user_id = ? AND bot_id = ?", transaction.UserID, transaction.BotID).First(&existing).Error; err == gorm.ErrRecordNotFound {
-				userBot := models.UserBot{
-					UserID:       transaction.UserID,
-					BotID:        transaction.BotID,
-					AccessType:   "purchase",
-					IsActive:     true,
-					PurchaseDate: time.Now(),
-					CreatedAt:    time.Now(),
-					UpdatedAt:    time.Now(),
-				}
-				if err := tx.Create(&userBot).Error; err != nil {
-					log.Printf("Failed to create user_bot entry: %v", err)
-					tx.Rollback()
-					ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to create user_bot entry"})
-					return
-				}
-			}
-		} else if transaction.PaymentType == "rent" {
-			var existing models.UserBot
-			if err := tx.Where("user_id = ? AND bot_id = ?", transaction.UserID, transaction.BotID).First(&existing).Error; err == gorm.ErrRecordNotFound {
-				expiry := time.Now().Add(30 * 24 * time.Hour)
-				userBot := models.UserBot{
-					UserID:       transaction.UserID,
-					BotID:        transaction.BotID,
-					AccessType:   "rent",
-					IsActive:     true,
-					PurchaseDate: time.Now(),
-					ExpiryDate:   &expiry,
-					CreatedAt:    time.Now(),
-					UpdatedAt:    time.Now(),
-				}
-				if err := tx.Create(&userBot).Error; err != nil {
-					log.Printf("Failed to create user_bot entry for rent: %v", err)
-					tx.Rollback()
-					ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to create user_bot entry"})
-					return
-				}
-			}
-		}
-	}
-
-	if err := tx.Commit().Error; err != nil {
-		log.Printf("Failed to commit transaction: %v", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to commit transaction"})
+	fulfilment, err := fulfilPayment(input.Reference, result)
+	if err != nil {
+		log.Printf("Failed to fulfil %s: %v", input.Reference, err)
+		respondFulfilmentError(ctx, err)
 		return
 	}
 
 	log.Printf("Payment processed successfully for reference: %s", input.Reference)
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": "Payment verified and bot access updated",
-		"data":    result.Data,
+		"message":    "Payment verified and bot access updated",
+		"data":       result,
+		"fulfilment": fulfilment,
 	})
 }
 
-// PaystackCallback handles webhook calls from Paystack
+// WebhookSource is the source stored on events received by PaystackCallback
+const WebhookSource = "paystack"
+
+// RegisterWebhookProcessor lets stored Paystack events be retried and replayed
+func RegisterWebhookProcessor() {
+	services.RegisterWebhookProcessor(WebhookSource, processEvent)
+}
+
+// PaystackCallback handles webhook calls from Paystack. Every event is stored before it
+// is processed; duplicates are acknowledged without running again and failures are
+// retried in the background.
 func PaystackCallback(ctx *gin.Context) {
 	signature := ctx.GetHeader("X-Paystack-Signature")
 	body, err := io.ReadAll(ctx.Request.Body)
@@ -738,206 +306,118 @@ func PaystackCallback(ctx *gin.Context) {
 	}
 	ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
 
-	h := hmac.New(sha512.New, []byte(os.Getenv("PAYSTACK_SECRET_KEY")))
-	h.Write(body)
-	expectedSignature := hex.EncodeToString(h.Sum(nil))
-	if signature != expectedSignature {
-		log.Printf("Invalid Paystack webhook signature: expected %s, got %s", expectedSignature, signature)
+	provider := payments.Default()
+	event, err := provider.ParseWebhook(body, signature)
+	if err == payments.ErrInvalidSignature {
+		log.Printf("Invalid webhook signature")
+		if _, _, err := services.RecordWebhook(services.WebhookDelivery{
+			Provider: provider.Name(),
+			Source:   WebhookSource,
+			Body:     body,
+		}); err != nil {
+			log.Printf("Failed to store rejected webhook: %v", err)
+		}
 		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid signature"})
 		return
 	}
-
-	var event struct {
-		Event string                 `json:"event"`
-		Data  map[string]interface{} `json:"data"`
-	}
-	if err := json.Unmarshal(body, &event); err != nil {
+	if err != nil {
 		log.Printf("Invalid webhook payload: %v", err)
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Invalid JSON"})
 		return
 	}
-	log.Printf("Webhook received: event=%s, data=%v", event.Event, event.Data)
+	log.Printf("Webhook received: event=%s, reference=%s", event.Type, event.Reference)
 
-	if event.Event != "charge.success" {
-		log.Printf("Ignoring non-success event: %s", event.Event)
-		ctx.JSON(http.StatusOK, gin.H{"message": "Event ignored"})
+	stored, created, err := services.RecordWebhook(services.WebhookDelivery{
+		Provider:       provider.Name(),
+		Source:         WebhookSource,
+		EventID:        event.ID,
+		Type:           event.Type,
+		Reference:      event.Reference,
+		Body:           body,
+		SignatureValid: true,
+	})
+	if err != nil {
+		// Not stored yet, so let Paystack send it again
+		log.Printf("Failed to store webhook: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to store event"})
 		return
 	}
-
-	reference, ok := event.Data["reference"].(string)
-	if !ok || reference == "" {
-		log.Printf("Missing or invalid reference in webhook")
-		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Invalid reference"})
+	if !created && stored.Status == "processed" {
+		log.Printf("Duplicate webhook %s ignored", stored.EventID)
+		ctx.JSON(http.StatusOK, gin.H{"message": "Event already processed"})
 		return
 	}
 
-	tx := database.DB.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("Panic in PaystackCallback: %v", r)
-			tx.Rollback()
-			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
-		}
-	}()
-
-	var transaction models.Transaction
-	if err := tx.Where("reference = ?", reference).First(&transaction).Error; err != nil {
-		log.Printf("Transaction not found: %s", reference)
-		tx.Rollback()
-		ctx.JSON(http.StatusNotFound, gin.H{"message": "Transaction not found"})
+	if stored, err = services.ProcessWebhook(stored.ID, false); err != nil {
+		log.Printf("Webhook %d for %s failed, will retry: %v", stored.ID, event.Reference, err)
+		ctx.JSON(http.StatusOK, gin.H{"message": "Event stored, processing will be retried"})
 		return
 	}
 
-	req, _ := http.NewRequest("GET", fmt.Sprintf("https://api.paystack.co/transaction/verify/%s", reference), nil)
-	req.Header.Add("Authorization", "Bearer "+os.Getenv("PAYSTACK_SECRET_KEY"))
-	req.Header.Add("Accept", "application/json")
+	log.Printf("Webhook processed successfully for reference: %s", event.Reference)
+	ctx.JSON(http.StatusOK, gin.H{"message": "Webhook processed successfully"})
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// processEvent applies a stored Paystack event. Each branch is idempotent, so the
+// same event can be retried or replayed.
+func processEvent(body []byte) error {
+	event, err := payments.DecodePaystackEvent(body)
 	if err != nil {
-		log.Printf("Paystack verify request failed: %v", err)
-		tx.Rollback()
-		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to verify payment", "error": err.Error()})
-		return
-	}
-	defer resp.Body.Close()
-
-	respBody, _ := io.ReadAll(resp.Body)
-	log.Printf("Paystack verify response: %s", string(respBody))
-	var result PaystackVerifyResponse
-	if err := json.Unmarshal(respBody, &result); err != nil {
-		log.Printf("Failed to parse Paystack verify response: %v", err)
-		tx.Rollback()
-		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to parse Paystack response", "error": err.Error()})
-		return
-	}
-
-	if !result.Status || result.Data.Status != "success" {
-		log.Printf("Payment verification failed: status=%v, message=%s, transaction_status=%s", result.Status, result.Message, result.Data.Status)
-		tx.Rollback()
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"message": "Payment verification failed",
-			"error":   result.Message,
-			"status":  result.Data.Status,
-		})
-		return
+		return fmt.Errorf("invalid payload: %w", err)
 	}
 
-	transaction.Status = "success"
-	if err := tx.Save(&transaction).Error; err != nil {
-		log.Printf("Failed to update transaction: %v", err)
-		tx.Rollback()
-		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to update transaction"})
-		return
-	}
-
-	var bot models.Bot
-	if err := tx.First(&bot, transaction.BotID).Error; err != nil {
-		log.Printf("Bot not found: %d", transaction.BotID)
-		tx.Rollback()
-		ctx.JSON(http.StatusNotFound, gin.H{"message": "Bot not found"})
-		return
-	}
-	amountPaid := float64(result.Data.Amount) / 100.0
-	expectedPrice := bot.Price
-	if transaction.PaymentType == "rent" {
-		expectedPrice = bot.RentPrice
-	}
-	if amountPaid < expectedPrice {
-		log.Printf("Payment amount too low: paid=%.2f, expected=%.2f", amountPaid, expectedPrice)
-		tx.Rollback()
-		ctx.JSON(http.StatusForbidden, gin.H{
-			"message": fmt.Sprintf("Payment amount (KES %.2f) is less than expected (KES %.2f)", amountPaid, expectedPrice),
-		})
-		return
+	reference := event.Reference
+	if reference == "" {
+		return errors.New("missing or invalid reference")
 	}
 
-	if transaction.PaymentType == "purchase" {
-		originalOwnerID := bot.OwnerID
-		bot.OwnerID = transaction.UserID
-		if err := tx.Save(&bot).Error; err != nil {
-			log.Printf("Failed to update bot ownership: %v", err)
-			tx.Rollback()
-			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to update bot ownership"})
-			return
-		}
-
-		sale := models.Sale{
-			BotID:     transaction.BotID,
-			SellerID:  originalOwnerID,
-			BuyerID:   transaction.UserID,
-			Amount:    transaction.Amount,
-			SaleType:  "purchase",
-			SaleDate:  time.Now(),
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
+	switch event.Type {
+	case "charge.success":
+		// handled below
+	case "refund.processed":
+		// JSON numbers decode as float64; format the id the way Refund reports it
+		refundRef := ""
+		if id, ok := event.Data["id"].(float64); ok {
+			refundRef = fmt.Sprintf("%d", int64(id))
 		}
-		if err := tx.Create(&sale).Error; err != nil {
-			log.Printf("Failed to record sale: %v", err)
-			tx.Rollback()
-			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to record sale"})
-			return
+		if _, err := services.ReverseOrder(reference, refundRef, "refund processed by provider"); err != nil {
+			return fmt.Errorf("failed to reverse %s: %w", reference, err)
 		}
-
-		if err := tx.Where("user_id = ? AND bot_id = ?", originalOwnerID, bot.ID).Delete(&models.UserBot{}).Error; err != nil {
-			log.Printf("Failed to remove old owner access: %v", err)
-			tx.Rollback()
-			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to remove old owner access"})
-			return
+		return nil
+	case "charge.dispute.create", "charge.dispute.remind":
+		if err := services.OpenDispute(reference); err != nil {
+			return fmt.Errorf("failed to open dispute on %s: %w", reference, err)
 		}
-
-		var existing models.UserBot
-		if err := tx.Where("user_id = ? AND bot_id = ?", transaction.UserID, transaction.BotID).First(&existing).Error; err == gorm.ErrRecordNotFound {
-			userBot := models.UserBot{
-				UserID:       transaction.UserID,
-				BotID:        transaction.BotID,
-				AccessType:   "purchase",
-				IsActive:     true,
-				PurchaseDate: time.Now(),
-				CreatedAt:    time.Now(),
-				UpdatedAt:    time.Now(),
-			}
-			if err := tx.Create(&userBot).Error; err != nil {
-				log.Printf("Failed to create user_bot entry: %v", err)
-				tx.Rollback()
-				ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to create user_bot entry"})
-				return
-			}
+		return nil
+	case "charge.dispute.resolve":
+		// "merchant-accepted" means we accepted the claim and the buyer gets the money back
+		buyerWon := event.Data["resolution"] == "merchant-accepted"
+		if err := services.ResolveDispute(reference, buyerWon); err != nil {
+			return fmt.Errorf("failed to resolve dispute on %s: %w", reference, err)
 		}
-	} else if transaction.PaymentType == "rent" {
-		var existing models.UserBot
-		if err := tx.Where("user_id = ? AND bot_id = ?", transaction.UserID, transaction.BotID).First(&existing).Error; err == gorm.ErrRecordNotFound {
-			expiry := time.Now().Add(30 * 24 * time.Hour)
-			userBot := models.UserBot{
-				UserID:       transaction.UserID,
-				BotID:        transaction.BotID,
-				AccessType:   "rent",
-				IsActive:     true,
-				PurchaseDate: time.Now(),
-				ExpiryDate:   &expiry,
-				CreatedAt:    time.Now(),
-				UpdatedAt:    time.Now(),
-			}
-			if err := tx.Create(&userBot).Error; err != nil {
-				log.Printf("Failed to create user_bot entry for rent: %v", err)
-				tx.Rollback()
-				ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to create user_bot entry"})
-				return
-			}
+		return nil
+	case "transfer.success", "transfer.failed", "transfer.reversed":
+		if err := services.CompletePayout(reference, event.Type == "transfer.success", "provider reported "+event.Type); err != nil {
+			return fmt.Errorf("failed to complete payout %s: %w", reference, err)
 		}
+		return nil
+	default:
+		log.Printf("Ignoring unhandled event: %s", event.Type)
+		return nil
 	}
 
-	if err := tx.Commit().Error; err != nil {
-		log.Printf("Failed to commit transaction: %v", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to commit transaction"})
-		return
+	// Never trust the webhook body for the amount, ask the provider
+	result, err := verifyWithProvider(reference)
+	if err != nil {
+		return fmt.Errorf("payment verify request failed: %w", err)
 	}
-
-	log.Printf("Webhook processed successfully for reference: %s", reference)
-	ctx.JSON(http.StatusOK, gin.H{
-		"message": "Webhook processed successfully",
-		"data":    result.Data,
-	})
+	if result.Status != "success" {
+		return fmt.Errorf("payment verification failed: message=%s, transaction_status=%s", result.Message, result.Status)
+	}
+	if _, err := fulfilPayment(reference, result); err != nil {
+		return fmt.Errorf("failed to fulfil %s: %w", reference, err)
+	}
+	return nil
 }
 
 // UpdateTransaction updates the status of a transaction
@@ -989,6 +469,7 @@ func UpdateTransaction(ctx *gin.Context) {
 	})
 }
 
+// HandleCallbackRedirect completes checkout when Paystack redirects the buyer back to us
 func HandleCallbackRedirect(ctx *gin.Context) {
 	reference := ctx.Query("reference")
 	if reference == "" {
@@ -1002,176 +483,36 @@ func HandleCallbackRedirect(ctx *gin.Context) {
 	log.Printf("Handling callback redirect for reference: %s", reference)
 
 	// Verify the payment
-	req, _ := http.NewRequest("GET", fmt.Sprintf("https://api.paystack.co/transaction/verify/%s", reference), nil)
-	req.Header.Add("Authorization", "Bearer "+os.Getenv("PAYSTACK_SECRET_KEY"))
-	req.Header.Add("Accept", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	result, err := verifyWithProvider(reference)
 	if err != nil {
-		log.Printf("Paystack verify request failed: %v", err)
+		log.Printf("Payment verify request failed: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to verify payment", "error": err.Error()})
 		return
 	}
-	defer resp.Body.Close()
-
-	respBody, _ := io.ReadAll(resp.Body)
-	log.Printf("Paystack verify response: %s", string(respBody))
-	var result PaystackVerifyResponse
-	if err := json.Unmarshal(respBody, &result); err != nil {
-		log.Printf("Failed to parse Paystack verify response: %v", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to parse Paystack response", "error": err.Error()})
-		return
-	}
 
-	if !result.Status || result.Data.Status != "success" {
-		log.Printf("Payment verification failed: status=%v, message=%s, transaction_status=%s", result.Status, result.Message, result.Data.Status)
+	if result.Status != "success" {
+		log.Printf("Payment verification failed: message=%s, transaction_status=%s", result.Message, result.Status)
 		// Update transaction to failed if abandoned
-		if result.Data.Status == "abandoned" {
-			var transaction models.Transaction
-			if err := database.DB.Where("reference = ?", reference).First(&transaction).Error; err == nil {
-				transaction.Status = "failed"
-				transaction.UpdatedAt = time.Now()
-				if err := database.DB.Save(&transaction).Error; err != nil {
-					log.Printf("Failed to update transaction to failed: %v", err)
-				} else {
-					log.Printf("Transaction updated to failed for reference: %s", reference)
-				}
+		if result.Status == "abandoned" {
+			if err := database.DB.Model(&models.Transaction{}).
+				Where("reference = ? AND status = ?", reference, "pending").
+				Updates(map[string]interface{}{"status": "failed", "updated_at": time.Now()}).Error; err != nil {
+				log.Printf("Failed to update transaction to failed: %v", err)
+			} else {
+				log.Printf("Transaction updated to failed for reference: %s", reference)
 			}
 		}
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"message": "Payment verification failed",
 			"error":   result.Message,
-			"status":  result.Data.Status,
+			"status":  result.Status,
 		})
 		return
 	}
 
-	tx := database.DB.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("Panic in HandleCallbackRedirect: %v", r)
-			tx.Rollback()
-			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
-		}
-	}()
-
-	var transaction models.Transaction
-	if err := tx.Where("reference = ?", reference).First(&transaction).Error; err != nil {
-		log.Printf("Transaction not found: %s", reference)
-		tx.Rollback()
-		ctx.JSON(http.StatusNotFound, gin.H{"message": "Transaction not found"})
-		return
-	}
-
-	var bot models.Bot
-	if err := tx.First(&bot, transaction.BotID).Error; err != nil {
-		log.Printf("Bot not found: %d", transaction.BotID)
-		tx.Rollback()
-		ctx.JSON(http.StatusNotFound, gin.H{"message": "Bot not found"})
-		return
-	}
-
-	amountPaid := float64(result.Data.Amount) / 100.0
-	expectedPrice := bot.Price
-	if transaction.PaymentType == "rent" {
-		expectedPrice = bot.RentPrice
-	}
-	if amountPaid < expectedPrice {
-		log.Printf("Payment amount too low: paid=%.2f, expected=%.2f", amountPaid, expectedPrice)
-		tx.Rollback()
-		ctx.JSON(http.StatusForbidden, gin.H{
-			"message": fmt.Sprintf("Payment amount (KES %.2f) is less than expected (KES %.2f)", amountPaid, expectedPrice),
-		})
-		return
-	}
-
-	transaction.Status = "success"
-	if err := tx.Save(&transaction).Error; err != nil {
-		log.Printf("Failed to update transaction: %v", err)
-		tx.Rollback()
-		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to update transaction"})
-		return
-	}
-
-	if transaction.PaymentType == "purchase" {
-		originalOwnerID := bot.OwnerID
-		bot.OwnerID = transaction.UserID
-		if err := tx.Save(&bot).Error; err != nil {
-			log.Printf("Failed to update bot ownership: %v", err)
-			tx.Rollback()
-			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to update bot ownership"})
-			return
-		}
-
-		sale := models.Sale{
-			BotID:     transaction.BotID,
-			SellerID:  originalOwnerID,
-			BuyerID:   transaction.UserID,
-			Amount:    transaction.Amount,
-			SaleType:  "purchase",
-			SaleDate:  time.Now(),
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}
-		if err := tx.Create(&sale).Error; err != nil {
-			log.Printf("Failed to record sale: %v", err)
-			tx.Rollback()
-			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to record sale"})
-			return
-		}
-
-		if err := tx.Where("user_id = ? AND bot_id = ?", originalOwnerID, bot.ID).Delete(&models.UserBot{}).Error; err != nil {
-			log.Printf("Failed to remove old owner access: %v", err)
-			tx.Rollback()
-			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to remove old owner access"})
-			return
-		}
-
-		var existing models.UserBot
-		if err := tx.Where("user_id = ? AND bot_id = ?", transaction.UserID, transaction.BotID).First(&existing).Error; err == gorm.ErrRecordNotFound {
-			userBot := models.UserBot{
-				UserID:       transaction.UserID,
-				BotID:        transaction.BotID,
-				AccessType:   "purchase",
-				IsActive:     true,
-				PurchaseDate: time.Now(),
-				CreatedAt:    time.Now(),
-				UpdatedAt:    time.Now(),
-			}
-			if err := tx.Create(&userBot).Error; err != nil {
-				log.Printf("Failed to create user_bot entry: %v", err)
-				tx.Rollback()
-				ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to create user_bot entry"})
-				return
-			}
-		}
-	} else if transaction.PaymentType == "rent" {
-		var existing models.UserBot
-		if err := tx.Where("user_id = ? AND bot_id = ?", transaction.UserID, transaction.BotID).First(&existing).Error; err == gorm.ErrRecordNotFound {
-			expiry := time.Now().Add(30 * 24 * time.Hour)
-			userBot := models.UserBot{
-				UserID:       transaction.UserID,
-				BotID:        transaction.BotID,
-				AccessType:   "rent",
-				IsActive:     true,
-				PurchaseDate: time.Now(),
-				ExpiryDate:   &expiry,
-				CreatedAt:    time.Now(),
-				UpdatedAt:    time.Now(),
-			}
-			if err := tx.Create(&userBot).Error; err != nil {
-				log.Printf("Failed to create user_bot entry for rent: %v", err)
-				tx.Rollback()
-				ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to create user_bot entry"})
-				return
-			}
-		}
-	}
-
-	if err := tx.Commit().Error; err != nil {
-		log.Printf("Failed to commit transaction: %v", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to commit transaction"})
+	if _, err := fulfilPayment(reference, result); err != nil {
+		log.Printf("Failed to fulfil %s: %v", reference, err)
+		respondFulfilmentError(ctx, err)
 		return
 	}
 
@@ -104,8 +104,12 @@
 package routes
 
 import (
+	"path"
+
 	"Api/handlers"
 	"Api/middleware"
+	"Api/models"
+	"Api/mpesa"
 	"Api/paystack"
 
 	"github.com/gin-gonic/gin"
@@ -130,19 +134,55 @@ func SetUpRouter(router *gin.Engine) {
 		{
 			auth.POST("/login", handlers.LoginHandler)
 			auth.POST("/register", handlers.SignupHandler)
+			auth.POST("/refresh", handlers.RefreshTokenHandler)
+			auth.POST("/logout", handlers.LogoutHandler)
+			auth.POST("/forgot-password", handlers.ForgotPasswordHandler)
+			auth.POST("/reset-password", handlers.ResetPasswordHandler)
+			auth.GET("/verify-email", handlers.VerifyEmailLinkHandler)
+			auth.POST("/verify-email", handlers.VerifyEmailHandler)
+			auth.POST("/2fa/verify", handlers.VerifyLoginChallengeHandler)
+			auth.POST("/2fa/setup", handlers.ChallengeSetupHandler)
+			auth.POST("/2fa/setup/confirm", handlers.ChallengeSetupConfirmHandler)
+			auth.GET("/unlock", handlers.UnlockAccountLinkHandler)
 		}
 		api.GET("/bots/:id", handlers.GetBotDetails)
+		api.GET("/bots/:id/plans", handlers.ListRentalPlansHandler)
+		api.GET("/exchange-rates", handlers.GetExchangeRates)
 		// -----------------------------
 		// 🔐 USER ROUTES
 		// -----------------------------
 		user := api.Group("/user")
-		user.Use(middleware.AuthMiddleware()) // user must be logged in
+		user.Use(middleware.AuthMiddleware(), middleware.RequirePermission(models.PermMarketplace)) // user must be logged in
 		{
 			user.GET("/me", handlers.ProfileHandler)
 			user.GET("/me/favorites", handlers.GetUserFavorites)
 			user.POST("/favorites/:bot_id", handlers.ToggleFavorite)
 			user.POST("/request-upgrade", handlers.RequestAdminUpgrade)
 			user.GET("/ws", handlers.WebSocketHandler)
+			user.GET("/subscriptions", handlers.GetUserSubscriptions)
+			user.POST("/subscriptions/:id/cancel", handlers.CancelSubscription)
+			user.POST("/coupons/validate", handlers.ValidateCouponHandler)
+			user.GET("/invoices", handlers.GetUserInvoices)
+			user.GET("/invoices/:id", handlers.DownloadInvoice)
+			user.PUT("/tax-details", handlers.UpdateTaxDetails)
+			user.POST("/transactions/:id/dispute", handlers.OpenDisputeHandler)
+			user.GET("/disputes", handlers.GetUserDisputes)
+			user.GET("/wallet", handlers.GetWallet)
+			user.GET("/wallet/entries", handlers.GetWalletEntries)
+			purchaseRoute(user, "POST", "/wallet/topup", handlers.TopUpWallet)
+			purchaseRoute(user, "POST", "/wallet/pay", handlers.PayFromWallet)
+			user.GET("/sessions", handlers.ListSessionsHandler)
+			user.DELETE("/sessions/:id", handlers.RevokeSessionHandler)
+			user.POST("/sessions/revoke-others", handlers.RevokeOtherSessionsHandler)
+			user.POST("/verify-email/resend", handlers.ResendVerificationHandler)
+			user.GET("/2fa", handlers.TwoFactorStatusHandler)
+			user.POST("/2fa/setup", handlers.TwoFactorSetupHandler)
+			user.POST("/2fa/enable", handlers.TwoFactorEnableHandler)
+			user.POST("/2fa/disable", handlers.TwoFactorDisableHandler)
+			user.POST("/2fa/recovery-codes", handlers.RegenerateRecoveryCodesHandler)
+			user.GET("/api-keys", handlers.ListAPIKeysHandler)
+			user.POST("/api-keys", handlers.CreateAPIKeyHandler)
+			user.DELETE("/api-keys/:id", handlers.RevokeAPIKeyHandler)
 		}
 
 		// -----------------------------
@@ -150,35 +190,59 @@ func SetUpRouter(router *gin.Engine) {
 		// -----------------------------
 		paystackGroup := api.Group("/paystack")
 		{
+			// Unauthenticated route for Paystack webhook, registered before Use so it skips auth
+			paystackGroup.POST("/webhook", paystack.PaystackCallback) // charge, refund and dispute events
+
 			// Authenticated routes (require logged-in user)
-			paystackGroup.Use(middleware.AuthMiddleware())
+			paystackGroup.Use(middleware.AuthMiddleware(), middleware.RequirePermission(models.PermMarketplace))
 			{
-				paystackGroup.POST("/initialize", paystack.InitializePayment) // Initialize payment
-				paystackGroup.GET("/verify", paystack.VerifyPayment)          // Verify payment via reference
-				paystackGroup.POST("/callback", paystack.FrontendCallback)    // Handle frontend callback
+				purchaseRoute(paystackGroup, "POST", "/initialize", paystack.InitializePayment) // Initialize payment
+				paystackGroup.GET("/verify", paystack.VerifyPayment)                            // Verify payment via reference
+				purchaseRoute(paystackGroup, "POST", "/callback", paystack.FrontendCallback)    // Handle frontend callback
 				paystackGroup.POST("update-transaction", paystack.UpdateTransaction)
 
 			}
+		}
 
-			// Unauthenticated route for Paystack webhook
-			paystackGroup.POST("/webhook", paystack.PaystackCallback) // Paystack webhook for charge.success
+		// -----------------------------
+		// 📱 M-PESA ROUTES
+		// -----------------------------
+		mpesaGroup := api.Group("/mpesa")
+		{
+			// Send STK prompt to buyer's phone
+			purchaseRoute(mpesaGroup, "POST", "/stkpush", middleware.AuthMiddleware(), middleware.RequirePermission(models.PermMarketplace), mpesa.STKPushHandler)
+			mpesaGroup.POST("/callback", mpesa.CallbackHandler)      // Daraja result callback
+			mpesaGroup.POST("/b2c/result", mpesa.B2CResultHandler)   // Daraja B2C payout result
+			mpesaGroup.POST("/b2c/timeout", mpesa.B2CTimeoutHandler) // Daraja B2C queue timeout
 		}
 
 		// -----------------------------
 		// 🛠 ADMIN ROUTES
 		// -----------------------------
 		admin := api.Group("/admin")
-		admin.Use(middleware.AuthMiddleware())
+		admin.Use(middleware.AuthMiddleware(), middleware.Audit(), middleware.RequirePermission(models.PermCreator))
 		{
 			admin.GET("/dashboard", handlers.AdminDashboardHandler)
-			admin.POST("/create-bot", handlers.CreateBotHandler)
-			admin.PUT("/update-bot/:id", handlers.UpdateBotHandler)
-			admin.DELETE("/delete-bot/:id", handlers.DeleteBotHandler)
-			admin.GET("/bots", handlers.ListAdminBotsHandler)
+			keyRoute(admin, models.ScopeBotsWrite, "POST", "/create-bot", handlers.CreateBotHandler)
+			keyRoute(admin, models.ScopeBotsWrite, "PUT", "/update-bot/:id", handlers.UpdateBotHandler)
+			keyRoute(admin, models.ScopeBotsWrite, "DELETE", "/delete-bot/:id", handlers.DeleteBotHandler)
+			keyRoute(admin, models.ScopeBotsRead, "GET", "/bots", handlers.ListAdminBotsHandler)
+			keyRoute(admin, models.ScopeBotsWrite, "POST", "/bots/:id/plans", handlers.CreateRentalPlanHandler)
+			keyRoute(admin, models.ScopeBotsWrite, "PUT", "/bots/:id/plans/:plan_id", handlers.UpdateRentalPlanHandler)
+			keyRoute(admin, models.ScopeBotsWrite, "DELETE", "/bots/:id/plans/:plan_id", handlers.DeleteRentalPlanHandler)
 			admin.GET("/profile", handlers.AdminProfileHandler)
 			admin.PUT("/bank-details", handlers.UpdateAdminBankDetails)
-			admin.GET("/transactions", handlers.GetAdminTransactions)
+			keyRoute(admin, models.ScopeTransactionsRead, "GET", "/transactions", handlers.GetAdminTransactions)
 			admin.POST("/transactions", handlers.RecordTransaction)
+			keyRoute(admin, models.ScopePayoutsRead, "GET", "/balance", handlers.GetCreatorBalance)
+			keyRoute(admin, models.ScopePayoutsRead, "GET", "/payouts", handlers.GetCreatorPayouts)
+			admin.POST("/payouts", handlers.RequestPayout)
+			admin.GET("/coupons", handlers.ListCreatorCouponsHandler)
+			admin.POST("/coupons", handlers.CreateCreatorCouponHandler)
+			admin.PUT("/coupons/:id", handlers.UpdateCreatorCouponHandler)
+			admin.DELETE("/coupons/:id", handlers.DeleteCreatorCouponHandler)
+			keyRoute(admin, models.ScopeTransactionsRead, "GET", "/invoices", handlers.GetCreatorInvoices)
+			admin.GET("/disputes", handlers.GetCreatorDisputes)
 		}
 
 		// -----------------------------
@@ -190,29 +254,89 @@ func SetUpRouter(router *gin.Engine) {
 			superAdmin.POST("/register", handlers.SuperAdminRegisterHandler)
 			superAdmin.POST("/login", handlers.SuperAdminLoginHandler)
 
-			// Protected routes
-			superAdmin.Use(middleware.AuthMiddleware())
+			// Protected routes, each group checks the permission it needs; every
+			// change, and every refused attempt, goes to the audit log
+			superAdmin.Use(middleware.AuthMiddleware(), middleware.Audit())
 			{
-				superAdmin.GET("/profile", handlers.SuperAdminProfileHandler)
-				superAdmin.GET("/dashboard", handlers.SuperAdminDashboardHandler)
-				superAdmin.GET("/users", handlers.GetAllUsers)
-				superAdmin.POST("/create-user", handlers.CreateUser)
-				superAdmin.POST("/update-user", handlers.UpdateUser)
-				superAdmin.DELETE("/delete-user", handlers.DeleteUser)
-				superAdmin.GET("/pending-requests", handlers.GetPendingRequests)
-				superAdmin.POST("/promote/:id", handlers.ApproveUpgrade)
-				superAdmin.POST("/reject/:id", handlers.RejectUpgrade)
-				superAdmin.GET("/admins", handlers.GetAllAdmins)
-				superAdmin.POST("/create-admin", handlers.CreateAdmin)
-				superAdmin.PUT("/update-admin/:id", handlers.UpdateAdmin)
-				superAdmin.PATCH("/toggle-admin/:id", handlers.ToggleAdminStatus)
-				superAdmin.DELETE("/delete-admin/:id", handlers.DeleteAdmin)
-				superAdmin.GET("/bots", handlers.GetBotsHandler)
-				superAdmin.Handle("GET", "/scan-bots", handlers.ScanAllBotsHandler)
-				superAdmin.Handle("POST", "/scan-bots", handlers.ScanAllBotsHandler)
-				superAdmin.GET("/ws", handlers.WebSocketHandler)
-				superAdmin.GET("/transactions", handlers.GetAllTransactions)
+				platform := superAdmin.Group("", middleware.RequirePermission(models.PermPlatform))
+				platform.GET("/profile", handlers.SuperAdminProfileHandler)
+				platform.GET("/dashboard", handlers.SuperAdminDashboardHandler)
+				platform.GET("/ws", handlers.WebSocketHandler)
+				platform.GET("/audit-log", handlers.GetAuditLog)
+				platform.GET("/audit-log/verify", handlers.VerifyAuditLog)
+
+				users := superAdmin.Group("", middleware.RequirePermission(models.PermManageUsers))
+				users.GET("/users", handlers.GetAllUsers)
+				users.POST("/create-user", handlers.CreateUser)
+				users.POST("/update-user", handlers.UpdateUser)
+				users.DELETE("/delete-user", handlers.DeleteUser)
+				users.GET("/pending-requests", handlers.GetPendingRequests)
+				users.POST("/promote/:id", handlers.ApproveUpgrade)
+				users.POST("/reject/:id", handlers.RejectUpgrade)
+				users.GET("/admins", handlers.GetAllAdmins)
+				users.POST("/create-admin", handlers.CreateAdmin)
+				users.PUT("/update-admin/:id", handlers.UpdateAdmin)
+				users.PATCH("/toggle-admin/:id", handlers.ToggleAdminStatus)
+				users.PUT("/users/:id/status", handlers.SetAccountStatusHandler)
+				users.DELETE("/delete-admin/:id", handlers.DeleteAdmin)
+				users.GET("/2fa-policy", handlers.GetTwoFactorPoliciesHandler)
+				users.PUT("/2fa-policy/:role", handlers.SetTwoFactorPolicyHandler)
+				users.GET("/locked-accounts", handlers.GetLockedAccountsHandler)
+				users.POST("/locked-accounts/:id/unlock", handlers.UnlockAccountHandler)
+
+				bots := superAdmin.Group("", middleware.RequirePermission(models.PermManageBots))
+				bots.GET("/bots", handlers.GetBotsHandler)
+				bots.Handle("GET", "/scan-bots", handlers.ScanAllBotsHandler)
+				bots.Handle("POST", "/scan-bots", handlers.ScanAllBotsHandler)
+
+				finance := superAdmin.Group("", middleware.RequirePermission(models.PermFinance))
+				keyRoute(finance, models.ScopeTransactionsRead, "GET", "/transactions", handlers.GetAllTransactions)
+				finance.POST("/transactions/:id/refund", handlers.RefundTransaction)
+				finance.GET("/ledger/balances", handlers.GetLedgerBalances)
+				finance.GET("/ledger/entries", handlers.GetLedgerEntries)
+				finance.GET("/reconciliations", handlers.GetReconciliationReports)
+				finance.POST("/reconciliations", handlers.RunReconciliation)
+				finance.GET("/reconciliations/:id", handlers.GetReconciliationReport)
+				finance.GET("/reconciliations/:id/download", handlers.DownloadReconciliationReport)
+				finance.GET("/payouts", handlers.GetAllPayouts)
+				finance.POST("/payouts/:id/approve", handlers.ApprovePayout)
+				finance.POST("/payouts/:id/reject", handlers.RejectPayout)
+				finance.GET("/coupons", handlers.ListAllCouponsHandler)
+				finance.POST("/coupons", handlers.CreateCouponHandler)
+				finance.PUT("/coupons/:id", handlers.UpdateCouponHandler)
+				finance.DELETE("/coupons/:id", handlers.DeleteCouponHandler)
+				finance.GET("/exchange-rates", handlers.GetExchangeRates)
+				finance.PUT("/exchange-rates/:currency", handlers.SetExchangeRate)
+				finance.POST("/exchange-rates/import", handlers.ImportExchangeRates)
+				finance.GET("/tax-rules", handlers.ListTaxRules)
+				finance.POST("/tax-rules", handlers.CreateTaxRule)
+				finance.PUT("/tax-rules/:id", handlers.UpdateTaxRule)
+				finance.DELETE("/tax-rules/:id", handlers.DeleteTaxRule)
+				finance.GET("/tax-report", handlers.GetTaxReport)
+				finance.GET("/disputes", handlers.GetAllDisputes)
+				finance.POST("/disputes/:id/resolve", handlers.ResolveDisputeHandler)
+				finance.GET("/webhook-events", handlers.ListWebhookEvents)
+				finance.GET("/webhook-events/:id", handlers.GetWebhookEvent)
+				finance.POST("/webhook-events/:id/replay", handlers.ReplayWebhookEvent)
 			}
 		}
 	}
 }
+
+// keyRoute registers a route that also accepts API keys holding scope
+func keyRoute(group *gin.RouterGroup, scope models.Scope, method, relativePath string, h ...gin.HandlerFunc) {
+	group.Handle(method, relativePath, h...)
+	middleware.AllowAPIKey(method, path.Join(group.BasePath(), relativePath), scope)
+}
+
+// purchaseRoutes holds every route registered through purchaseRoute
+var purchaseRoutes = map[string]bool{}
+
+// purchaseRoute registers a route that starts a payment. Buyers need a confirmed email
+// address, checked after the route's own middleware has identified them.
+func purchaseRoute(group *gin.RouterGroup, method, relativePath string, h ...gin.HandlerFunc) {
+	last := len(h) - 1
+	chain := append(append([]gin.HandlerFunc{}, h[:last]...), middleware.RequireVerifiedEmail(), h[last])
+	group.Handle(method, relativePath, chain...)
+	purchaseRoutes[method+" "+path.Join(group.BasePath(), relativePath)] = true
+}
@@ -0,0 +1,44 @@
+package routes
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Every route that starts a payment must sit behind the verified email check
+func TestPurchaseRoutesRequireVerifiedEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	SetUpRouter(router)
+
+	want := []string{
+		"POST /api/paystack/initialize",
+		"POST /api/paystack/callback",
+		"POST /api/mpesa/stkpush",
+		"POST /api/user/wallet/topup",
+		"POST /api/user/wallet/pay",
+	}
+	for _, route := range want {
+		if !purchaseRoutes[route] {
+			t.Errorf("%s is not registered as a purchase route", route)
+		}
+	}
+
+	// The same handlers mounted anywhere else would skip the check
+	purchaseHandlers := []string{
+		"paystack.InitializePayment",
+		"paystack.FrontendCallback",
+		"mpesa.STKPushHandler",
+		"handlers.TopUpWallet",
+		"handlers.PayFromWallet",
+	}
+	for _, r := range router.Routes() {
+		for _, h := range purchaseHandlers {
+			if strings.HasSuffix(r.Handler, "/"+h) && !purchaseRoutes[r.Method+" "+r.Path] {
+				t.Errorf("%s %s serves %s without the verified email check", r.Method, r.Path, h)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"Api/database"
+	"Api/internal/config"
+	"Api/mailer"
+	"Api/models"
+	"Api/utils"
+)
+
+const (
+	EmailVerificationTTL = 24 * time.Hour
+	PasswordResetTTL     = 15 * time.Minute
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification link")
+	ErrEmailAlreadyVerified     = errors.New("email address is already verified")
+)
+
+// SendVerificationEmail mails the user a new verification link, replacing any
+// earlier one
+func SendVerificationEmail(user *models.Person) error {
+	if user.EmailVerifiedAt != nil {
+		return ErrEmailAlreadyVerified
+	}
+	token := utils.GenerateResetToken()
+	err := database.DB.Model(&models.Person{}).Where("id = ?", user.ID).UpdateColumns(map[string]interface{}{
+		"email_verify_token":  token,
+		"email_verify_expiry": time.Now().Add(EmailVerificationTTL),
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to save verification token: %w", err)
+	}
+	return mailer.SendTemplate(user.Email, mailer.TemplateVerifyEmail, mailer.Data{
+		"Name":      user.Name,
+		"Email":     user.Email,
+		"Link":      config.Get().PublicURL + "/api/auth/verify-email?token=" + url.QueryEscape(token),
+		"ExpiresIn": "24 hours",
+	})
+}
+
+// VerifyEmail confirms the address the token was mailed to
+func VerifyEmail(token string) (*models.Person, error) {
+	var user models.Person
+	if token == "" || database.DB.Where("email_verify_token = ?", token).First(&user).Error != nil {
+		return nil, ErrInvalidVerificationToken
+	}
+	if time.Now().After(user.EmailVerifyExpiry) {
+		return nil, ErrInvalidVerificationToken
+	}
+	now := time.Now()
+	err := database.DB.Model(&models.Person{}).Where("id = ?", user.ID).UpdateColumns(map[string]interface{}{
+		"email_verified_at":  now,
+		"email_verify_token": "",
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify email: %w", err)
+	}
+	user.EmailVerifiedAt = &now
+	user.EmailVerifyToken = ""
+	return &user, nil
+}
+
+// RequestPasswordReset mails a reset link to the account with this email. It
+// does nothing when there is no such account, so callers reveal nothing either way.
+func RequestPasswordReset(email string) error {
+	var user models.Person
+	if database.DB.Where("email = ?", email).First(&user).Error != nil {
+		return nil
+	}
+	token := utils.GenerateResetToken()
+	err := database.DB.Model(&models.Person{}).Where("id = ?", user.ID).UpdateColumns(map[string]interface{}{
+		"reset_token":  token,
+		"reset_expiry": time.Now().Add(PasswordResetTTL),
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to save reset token: %w", err)
+	}
+	return mailer.SendTemplate(user.Email, mailer.TemplatePasswordReset, mailer.Data{
+		"Name":      user.Name,
+		"Link":      config.Get().FrontendURL + "/auth?reset_token=" + url.QueryEscape(token),
+		"ExpiresIn": "15 minutes",
+	})
+}
+
+// SendUpgradeApproved tells a user their request to become a creator was approved
+func SendUpgradeApproved(user *models.Person) error {
+	return mailer.SendTemplate(user.Email, mailer.TemplateUpgradeApproved, mailer.Data{
+		"Name": user.Name,
+		"Link": config.Get().FrontendURL + "/admin_dashboard",
+	})
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"Api/database"
+	"Api/internal/config"
+	"Api/mailer"
+	"Api/models"
+)
+
+var (
+	ErrStatusReasonRequired = errors.New("a reason is required to suspend, ban or delete an account")
+	ErrStatusUntilInvalid   = errors.New("until must be in the future and only applies to suspensions")
+	ErrOwnAccountStatus     = errors.New("you cannot change the status of your own account")
+	ErrSellerUnavailable    = errors.New("this bot is not available for purchase")
+)
+
+// AccountStatusError stops an account that is not active from signing in or
+// making requests
+type AccountStatusError struct {
+	Status models.AccountStatus `json:"status"`
+	Reason string               `json:"reason,omitempty"`
+	Until  *time.Time           `json:"until,omitempty"`
+}
+
+func (e *AccountStatusError) Error() string {
+	if e.Until != nil {
+		return fmt.Sprintf("account is %s until %s", e.Status, e.Until.UTC().Format(time.RFC1123))
+	}
+	return fmt.Sprintf("account is %s", e.Status)
+}
+
+// CheckAccountActive returns an *AccountStatusError unless user may use the platform now
+func CheckAccountActive(user *models.Person) error {
+	if status := user.CurrentStatus(time.Now()); status != models.AccountActive {
+		return &AccountStatusError{Status: status, Reason: user.StatusReason, Until: user.StatusUntil}
+	}
+	return nil
+}
+
+// CheckAccountActiveByID is CheckAccountActive for a request that only carries the
+// user's id. An account that no longer exists counts as deleted.
+func CheckAccountActiveByID(userID uint) error {
+	var user models.Person
+	if err := database.DB.Select("id", "status", "status_reason", "status_until").First(&user, userID).Error; err != nil {
+		return &AccountStatusError{Status: models.AccountDeleted}
+	}
+	return CheckAccountActive(&user)
+}
+
+// InactiveAccountIDs selects the ids of accounts that are not active right now,
+// for use as a subquery
+func InactiveAccountIDs() *gorm.DB {
+	return database.DB.Model(&models.Person{}).Select("id").
+		Where("status IN ? AND (status <> ? OR status_until IS NULL OR status_until > ?)",
+			[]models.AccountStatus{models.AccountSuspended, models.AccountBanned, models.AccountDeleted},
+			models.AccountSuspended, time.Now())
+}
+
+// CheckSellerActive refuses checkout for a bot whose creator is not active
+func CheckSellerActive(bot *models.Bot) error {
+	if err := CheckAccountActiveByID(bot.OwnerID); err != nil {
+		return ErrSellerUnavailable
+	}
+	return nil
+}
+
+// SetAccountStatus changes a person's account status, signs them out everywhere
+// when they lose access and emails them the outcome. until only applies to
+// suspensions; nil suspends until a superadmin lifts it.
+func SetAccountStatus(userID uint, status models.AccountStatus, reason string, until *time.Time, actorID uint) (*models.Person, error) {
+	reason = strings.TrimSpace(reason)
+	if userID == actorID {
+		return nil, ErrOwnAccountStatus
+	}
+	if status != models.AccountActive && reason == "" {
+		return nil, ErrStatusReasonRequired
+	}
+	if until != nil && (status != models.AccountSuspended || !until.After(time.Now())) {
+		return nil, ErrStatusUntilInvalid
+	}
+
+	var user models.Person
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	if status == models.AccountActive {
+		reason = ""
+	}
+	now := time.Now()
+	err := database.DB.Model(&user).Updates(map[string]interface{}{
+		"status":            status,
+		"status_reason":     reason,
+		"status_until":      until,
+		"status_changed_at": now,
+		"status_changed_by": actorID,
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to update account status: %w", err)
+	}
+	user.Status = status
+	user.StatusReason = reason
+	user.StatusUntil = until
+	user.StatusChangedAt = &now
+	user.StatusChangedBy = actorID
+
+	if status != models.AccountActive {
+		if _, err := revokeSessions(database.DB.Where("user_id = ?", user.ID), "account "+string(status)); err != nil {
+			log.Printf("Failed to sign out user %d after their account was %s: %v", user.ID, status, err)
+		}
+	}
+	if err := SendAccountStatusNotice(&user); err != nil {
+		log.Printf("Failed to email account status to user %d: %v", user.ID, err)
+	}
+	return &user, nil
+}
+
+// SendAccountStatusNotice tells a person their account status changed
+func SendAccountStatusNotice(user *models.Person) error {
+	until := ""
+	if user.StatusUntil != nil {
+		until = user.StatusUntil.UTC().Format("2 January 2006, 15:04 MST")
+	}
+	return mailer.SendTemplate(user.Email, mailer.TemplateAccountStatus, mailer.Data{
+		"Name":   user.Name,
+		"Status": string(user.Status),
+		"Reason": user.StatusReason,
+		"Until":  until,
+		"Link":   config.Get().FrontendURL + "/support",
+	})
+}
+
+// LiftExpiredSuspensions reactivates accounts whose suspension has run out and
+// lets their owners know
+func LiftExpiredSuspensions() error {
+	var users []models.Person
+	err := database.DB.Where("status = ? AND status_until IS NOT NULL AND status_until <= ?", models.AccountSuspended, time.Now()).
+		Find(&users).Error
+	if err != nil {
+		return err
+	}
+	for i := range users {
+		user := &users[i]
+		now := time.Now()
+		res := database.DB.Model(user).Where("status = ?", models.AccountSuspended).Updates(map[string]interface{}{
+			"status":            models.AccountActive,
+			"status_reason":     "",
+			"status_until":      nil,
+			"status_changed_at": now,
+			"status_changed_by": 0,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			continue // changed by a superadmin in the meantime
+		}
+		user.Status = models.AccountActive
+		user.StatusReason = ""
+		user.StatusUntil = nil
+		log.Printf("Suspension of user %d has ended", user.ID)
+		if err := SendAccountStatusNotice(user); err != nil {
+			log.Printf("Failed to email account status to user %d: %v", user.ID, err)
+		}
+	}
+	return nil
+}
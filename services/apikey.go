@@ -0,0 +1,156 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"Api/database"
+	"Api/models"
+)
+
+const (
+	// APIKeyPrefix starts every API key, so keys are easy to tell from access
+	// tokens and to find with secret scanners
+	APIKeyPrefix = "acdk_"
+
+	DefaultAPIKeyTTL  = 90 * 24 * time.Hour
+	MaxAPIKeyTTL      = 365 * 24 * time.Hour
+	maxAPIKeysPerUser = 20
+
+	// apiKeyTouchInterval limits how often last_used_at is written for a busy key
+	apiKeyTouchInterval = time.Minute
+)
+
+var (
+	ErrInvalidAPIKey    = errors.New("invalid, expired or revoked API key")
+	ErrAPIKeyNotFound   = errors.New("API key not found")
+	ErrTooManyAPIKeys   = fmt.Errorf("at most %d active API keys per account", maxAPIKeysPerUser)
+	ErrAPIKeyNoScopes   = errors.New("an API key needs at least one scope")
+	ErrAPIKeyTTLTooLong = fmt.Errorf("API keys expire after at most %d days", int(MaxAPIKeyTTL/(24*time.Hour)))
+)
+
+// ScopeNotAllowedError names a scope the owner's role cannot grant
+type ScopeNotAllowedError struct {
+	Scope string
+}
+
+func (e *ScopeNotAllowedError) Error() string {
+	return fmt.Sprintf("scope %q is unknown or not available to your role", e.Scope)
+}
+
+// CreateAPIKey issues a key for user. The plaintext key is returned only here.
+func CreateAPIKey(user *models.Person, name string, scopes []string, ttl time.Duration) (*models.APIKey, string, error) {
+	if ttl <= 0 {
+		ttl = DefaultAPIKeyTTL
+	}
+	if ttl > MaxAPIKeyTTL {
+		return nil, "", ErrAPIKeyTTLTooLong
+	}
+
+	role := models.NormalizeRole(string(user.Role))
+	granted := map[models.Scope]bool{}
+	for _, s := range scopes {
+		scope, ok := models.ParseScope(s)
+		if !ok || !role.CanGrant(scope) {
+			return nil, "", &ScopeNotAllowedError{Scope: s}
+		}
+		granted[scope] = true
+	}
+	if len(granted) == 0 {
+		return nil, "", ErrAPIKeyNoScopes
+	}
+	list := make([]string, 0, len(granted))
+	for scope := range granted {
+		list = append(list, string(scope))
+	}
+	sort.Strings(list)
+
+	var active int64
+	database.DB.Model(&models.APIKey{}).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", user.ID, time.Now()).
+		Count(&active)
+	if active >= maxAPIKeysPerUser {
+		return nil, "", ErrTooManyAPIKeys
+	}
+
+	id := make([]byte, 4)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	prefix := APIKeyPrefix + hex.EncodeToString(id)
+	plaintext := prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+
+	now := time.Now()
+	key := models.APIKey{
+		UserID:    user.ID,
+		Name:      strings.TrimSpace(name),
+		Prefix:    prefix,
+		KeyHash:   hashToken(plaintext),
+		Scopes:    strings.Join(list, " "),
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	if err := database.DB.Create(&key).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to create API key: %w", err)
+	}
+	return &key, plaintext, nil
+}
+
+// ListAPIKeys returns the user's keys, newest first, including revoked and expired ones
+func ListAPIKeys(userID uint) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := database.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+// RevokeAPIKey stops one of the user's keys from working
+func RevokeAPIKey(userID, keyID uint) error {
+	res := database.DB.Model(&models.APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", keyID, userID).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// AuthenticateAPIKey resolves a presented key to the key and its owner, and
+// records when and where it was last used
+func AuthenticateAPIKey(plaintext, ip string) (*models.APIKey, *models.Person, error) {
+	var key models.APIKey
+	if err := database.DB.Where("key_hash = ?", hashToken(plaintext)).First(&key).Error; err != nil {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	now := time.Now()
+	if key.RevokedAt != nil || now.After(key.ExpiresAt) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	var user models.Person
+	if err := database.DB.First(&user, key.UserID).Error; err != nil {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	if err := CheckAccountActive(&user); err != nil {
+		return nil, nil, err
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > apiKeyTouchInterval || key.LastUsedIP != ip {
+		database.DB.Model(&models.APIKey{}).Where("id = ?", key.ID).
+			UpdateColumns(map[string]interface{}{"last_used_at": now, "last_used_ip": ip})
+		key.LastUsedAt = &now
+		key.LastUsedIP = ip
+	}
+	return &key, &user, nil
+}
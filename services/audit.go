@@ -0,0 +1,269 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"Api/database"
+	"Api/models"
+)
+
+// auditLockKey serialises appends to the chain across every API instance
+const auditLockKey = 7_301_424
+
+// AuditRecord is what the audit middleware knows about a finished request.
+// Before and After are AuditSnapshot results, nil when there is nothing to show.
+type AuditRecord struct {
+	ActorID    uint
+	ActorEmail string
+	ActorRole  models.Role
+	APIKeyID   *uint
+	Action     string
+	Method     string
+	Path       string
+	TargetType string
+	TargetID   string
+	Before     map[string]interface{}
+	After      map[string]interface{}
+	Status     int
+	IP         string
+	RequestID  string
+}
+
+// AuditSnapshot captures v as it is now, in its JSON form, so fields hidden
+// from API responses stay out of the log too
+func AuditSnapshot(v interface{}) map[string]interface{} {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		var value interface{}
+		json.Unmarshal(raw, &value)
+		return map[string]interface{}{"value": value}
+	}
+	return fields
+}
+
+type auditChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// auditDiff keeps the fields that differ between two snapshots. A missing
+// snapshot means the target was created (no before) or deleted (no after).
+func auditDiff(before, after map[string]interface{}) (models.AuditDiff, error) {
+	if before == nil && after == nil {
+		return "", nil
+	}
+	changes := map[string]auditChange{}
+	for field, from := range before {
+		to, ok := after[field]
+		if ok && reflect.DeepEqual(from, to) {
+			continue
+		}
+		changes[field] = auditChange{From: redactAuditValue(field, from), To: redactAuditValue(field, to)}
+	}
+	for field, to := range after {
+		if _, seen := before[field]; !seen {
+			changes[field] = auditChange{To: redactAuditValue(field, to)}
+		}
+	}
+	if len(changes) == 0 {
+		return "", nil
+	}
+	raw, err := json.Marshal(changes) // map keys come out sorted, so the bytes are stable
+	return models.AuditDiff(raw), err
+}
+
+// redactAuditValue hides credentials that a snapshot might still carry; the
+// diff still shows that they changed
+func redactAuditValue(field string, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	name := strings.ToLower(field)
+	for _, secret := range []string{"password", "secret", "token", "hash"} {
+		if strings.Contains(name, secret) {
+			return "[redacted]"
+		}
+	}
+	return v
+}
+
+// auditHash covers every column apart from the hash itself, chained to the
+// previous entry through PrevHash
+func auditHash(e *models.AuditEntry) string {
+	raw, _ := json.Marshal([]interface{}{
+		e.Seq, e.PrevHash, e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		e.ActorID, e.ActorEmail, e.ActorRole, e.APIKeyID,
+		e.Action, e.Method, e.Path, e.TargetType, e.TargetID, string(e.Changes),
+		e.Status, e.IP, e.RequestID,
+	})
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// RecordAudit appends an entry to the audit log
+func RecordAudit(rec AuditRecord) (*models.AuditEntry, error) {
+	changes, err := auditDiff(rec.Before, rec.After)
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff audit snapshots: %w", err)
+	}
+	entry := models.AuditEntry{
+		ActorID:    rec.ActorID,
+		ActorEmail: rec.ActorEmail,
+		ActorRole:  rec.ActorRole,
+		APIKeyID:   rec.APIKeyID,
+		Action:     rec.Action,
+		Method:     rec.Method,
+		Path:       rec.Path,
+		TargetType: rec.TargetType,
+		TargetID:   rec.TargetID,
+		Changes:    changes,
+		Status:     rec.Status,
+		IP:         rec.IP,
+		RequestID:  rec.RequestID,
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", auditLockKey).Error; err != nil {
+			return err
+		}
+		var last models.AuditEntry
+		if err := tx.Order("seq DESC").Limit(1).Find(&last).Error; err != nil {
+			return err
+		}
+		entry.Seq = last.Seq + 1
+		entry.PrevHash = last.Hash
+		// Postgres keeps microseconds, so hash the time as it will be read back
+		entry.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+		entry.Hash = auditHash(&entry)
+		return tx.Create(&entry).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to append audit entry: %w", err)
+	}
+	return &entry, nil
+}
+
+// AuditFilter narrows a search of the audit log. Zero values match everything.
+type AuditFilter struct {
+	ActorID    uint
+	Action     string // exact, or a prefix ending in "*" such as "user.*"
+	TargetType string
+	TargetID   string
+	RequestID  string
+	Search     string // part of the actor's email or the request path
+	From       time.Time
+	To         time.Time
+}
+
+func auditQuery(f AuditFilter) *gorm.DB {
+	q := database.DB.Model(&models.AuditEntry{})
+	if f.ActorID != 0 {
+		q = q.Where("actor_id = ?", f.ActorID)
+	}
+	if prefix, ok := strings.CutSuffix(f.Action, "*"); ok {
+		q = q.Where("action LIKE ?", prefix+"%")
+	} else if f.Action != "" {
+		q = q.Where("action = ?", f.Action)
+	}
+	if f.TargetType != "" {
+		q = q.Where("target_type = ?", f.TargetType)
+	}
+	if f.TargetID != "" {
+		q = q.Where("target_id = ?", f.TargetID)
+	}
+	if f.RequestID != "" {
+		q = q.Where("request_id = ?", f.RequestID)
+	}
+	if f.Search != "" {
+		like := "%" + strings.ToLower(f.Search) + "%"
+		q = q.Where("(LOWER(actor_email) LIKE ? OR LOWER(path) LIKE ?)", like, like)
+	}
+	if !f.From.IsZero() {
+		q = q.Where("created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		q = q.Where("created_at < ?", f.To)
+	}
+	return q
+}
+
+// SearchAudit returns one page of matching entries, newest first, and the total
+func SearchAudit(f AuditFilter, page, limit int) ([]models.AuditEntry, int64, error) {
+	var total int64
+	if err := auditQuery(f).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var entries []models.AuditEntry
+	err := auditQuery(f).Order("seq DESC").Limit(limit).Offset((page - 1) * limit).Find(&entries).Error
+	return entries, total, err
+}
+
+// EachAuditEntry calls fn for every matching entry, oldest first, for exports.
+// Batches follow the primary key, which grows with seq because appends are serialised.
+func EachAuditEntry(f AuditFilter, fn func(*models.AuditEntry) error) error {
+	var batch []models.AuditEntry
+	return auditQuery(f).FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			if err := fn(&batch[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// AuditVerification is the outcome of walking the hash chain
+type AuditVerification struct {
+	Valid     bool   `json:"valid"`
+	Checked   int64  `json:"checked"`
+	BrokenSeq uint64 `json:"broken_seq,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// VerifyAuditChain recomputes every hash and checks that no entry is missing,
+// altered or out of order
+func VerifyAuditChain() (*AuditVerification, error) {
+	result := &AuditVerification{Valid: true}
+	var prev models.AuditEntry
+	var batch []models.AuditEntry
+	err := database.DB.FindInBatches(&batch, 1000, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			e := &batch[i]
+			switch {
+			case e.Seq != prev.Seq+1:
+				result.Reason = fmt.Sprintf("expected entry %d, found %d", prev.Seq+1, e.Seq)
+			case e.PrevHash != prev.Hash:
+				result.Reason = "previous hash does not match the entry before it"
+			case auditHash(e) != e.Hash:
+				result.Reason = "entry was modified after it was written"
+			}
+			if result.Reason != "" {
+				result.Valid = false
+				result.BrokenSeq = e.Seq
+				return errAuditChainBroken
+			}
+			result.Checked++
+			prev = *e
+		}
+		return nil
+	}).Error
+	if err != nil && !errors.Is(err, errAuditChainBroken) {
+		return nil, err
+	}
+	return result, nil
+}
+
+var errAuditChainBroken = errors.New("audit chain broken")
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"Api/database"
+	"Api/models"
+	"Api/payments"
+)
+
+var (
+	ErrCreatorNotFound    = errors.New("admin not found")
+	ErrAlreadyPurchased   = errors.New("you already purchased this bot")
+	ErrSubaccountFailed   = errors.New("failed to create creator subaccount")
+	ErrInvalidPaymentType = errors.New("invalid payment type, must be 'purchase' or 'rent'")
+)
+
+// PendingCheckoutError is returned when the buyer already has an unpaid checkout for the bot
+type PendingCheckoutError struct {
+	Reference string
+}
+
+func (e *PendingCheckoutError) Error() string {
+	return "A pending transaction already exists for this bot"
+}
+
+// CheckoutInput describes a bot purchase or rental about to be paid for
+type CheckoutInput struct {
+	UserID      uint
+	BotID       uint
+	PaymentType string
+	PlanID      uint
+	CouponCode  string
+	Currency    string // currency the buyer is charged in, defaults to the bot's
+	Channel     string // channel that takes the payment
+	Description string
+	AutoRenew   *bool // rentals renew automatically unless set to false
+	// Paid is set when the provider has already taken the money, so neither an unpaid
+	// checkout nor an earlier purchase of the same bot may stop it being recorded
+	Paid bool
+}
+
+// Checkout is a priced order ready to be charged. Every purchase entry point goes
+// through PrepareCheckout and Record, so pricing, tax, escrow and the revenue split
+// are worked out the same way whichever channel takes the payment.
+type Checkout struct {
+	Buyer      models.Person
+	Bot        models.Bot
+	Admin      models.Admin
+	Plan       *models.RentalPlan
+	Quote      *PriceQuote
+	Subaccount string // creator subaccount the payment is split to, empty when we keep it all
+
+	in             CheckoutInput
+	companyPercent float64
+}
+
+// PrepareCheckout checks the buyer may pay for the bot and prices the order,
+// applying the coupon, currency and tax
+func PrepareCheckout(in CheckoutInput) (*Checkout, error) {
+	c := &Checkout{in: in}
+	if err := database.DB.First(&c.Buyer, in.UserID).Error; err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	if err := database.DB.First(&c.Bot, in.BotID).Error; err != nil {
+		return nil, ErrBotNotFound
+	}
+	if err := CheckSellerActive(&c.Bot); err != nil {
+		return nil, err
+	}
+	if err := database.DB.Where("person_id = ?", c.Bot.OwnerID).First(&c.Admin).Error; err != nil {
+		return nil, ErrCreatorNotFound
+	}
+	var err error
+	if c.companyPercent, err = PlatformCommission(in.PaymentType); err != nil {
+		return nil, ErrInvalidPaymentType
+	}
+
+	if !in.Paid {
+		var existing models.Transaction
+		if err := database.DB.
+			Where("user_id = ? AND bot_id = ? AND payment_type = ? AND status = ?", in.UserID, in.BotID, in.PaymentType, "pending").
+			First(&existing).Error; err == nil {
+			return nil, &PendingCheckoutError{Reference: existing.Reference}
+		}
+	}
+
+	price := c.Bot.Price
+	if in.PaymentType == "rent" {
+		if c.Plan, err = RentalPlanFor(c.Bot.ID, in.PlanID); err != nil {
+			return nil, err
+		}
+		price = c.Plan.Price
+	} else if !in.Paid {
+		var owned int64
+		database.DB.Model(&models.Transaction{}).
+			Where("user_id = ? AND bot_id = ? AND payment_type = ? AND status = ?", in.UserID, in.BotID, "purchase", "success").
+			Count(&owned)
+		if owned > 0 {
+			return nil, ErrAlreadyPurchased
+		}
+	}
+	if price <= 0 {
+		return nil, fmt.Errorf("this bot is not available for %s", in.PaymentType)
+	}
+
+	if c.Quote, err = ApplyCoupon(in.CouponCode, in.UserID, &c.Bot, in.PaymentType, price); err != nil {
+		return nil, err
+	}
+	currency, err := NormalizeCurrency(in.Currency, BotCurrency(&c.Bot))
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Quote.ConvertTo(currency); err != nil {
+		return nil, err
+	}
+	c.Quote.AddTax(&c.Buyer)
+
+	if c.Subaccount, err = creatorSubaccount(&c.Admin, in.Channel, in.PaymentType); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// CheckAmount refuses an amount below the quoted price
+func (c *Checkout) CheckAmount(amount float64) error {
+	if amount < c.Quote.Amount {
+		return fmt.Errorf("Amount must be at least %s %.2f", c.Quote.Currency, c.Quote.Amount)
+	}
+	return nil
+}
+
+// PlatformCharge is the part of amount a split payment leaves with us: the platform's
+// commission plus the tax, which is ours to remit and is not shared with the creator
+func (c *Checkout) PlatformCharge(amount float64) int64 {
+	tax := c.Quote.TaxAmount()
+	platform, _ := SplitShares(amount-tax, c.companyPercent)
+	return payments.ToMinor(platform + tax)
+}
+
+// Record saves the pending transaction for amount charged under reference
+func (c *Checkout) Record(reference string, amount float64) (*models.Transaction, error) {
+	if err := c.CheckAmount(amount); err != nil {
+		return nil, err
+	}
+	companyShare, adminShare := SplitShares(amount-c.Quote.TaxAmount(), c.companyPercent)
+	description := c.in.Description
+	if description == "" {
+		description = fmt.Sprintf("Payment for bot %d (%s)", c.Bot.ID, c.in.PaymentType)
+	}
+	transaction := models.Transaction{
+		UserID:         c.in.UserID,
+		AdminID:        c.Admin.ID,
+		BotID:          c.Bot.ID,
+		Amount:         amount,
+		CompanyShare:   companyShare,
+		AdminShare:     adminShare,
+		Status:         "pending",
+		Reference:      reference,
+		PaymentChannel: c.in.Channel,
+		PaymentType:    c.in.PaymentType,
+		Description:    description,
+		AutoRenew:      c.in.PaymentType == "rent" && (c.in.AutoRenew == nil || *c.in.AutoRenew),
+		SplitAtSource:  c.Subaccount != "",
+		CreatedAt:      time.Now(),
+	}
+	if c.Plan != nil {
+		transaction.RentalPlanID = &c.Plan.ID
+		transaction.RentalDays = c.Plan.DurationDays
+	}
+	c.Quote.ApplyTo(&transaction)
+	if err := database.DB.Create(&transaction).Error; err != nil {
+		return nil, fmt.Errorf("failed to save transaction: %w", err)
+	}
+	return &transaction, nil
+}
+
+// creatorSubaccount returns the subaccount a payment through channel is split to at
+// source, creating it from the creator's bank details on first use. Escrowed
+// purchases are paid to us in full and their creator share is released later, and
+// creators without bank details have their share accrue for payout.
+func creatorSubaccount(admin *models.Admin, channel, paymentType string) (string, error) {
+	if paymentType == "purchase" && EscrowWindow() > 0 {
+		return "", nil
+	}
+	provider, err := payments.Get(channel)
+	if err != nil {
+		return "", nil
+	}
+	creator, ok := provider.(payments.SubaccountCreator)
+	if !ok {
+		return "", nil
+	}
+	if admin.PaystackSubaccountCode != "" {
+		return admin.PaystackSubaccountCode, nil
+	}
+	if admin.BankCode == "" || admin.AccountNumber == "" || admin.AccountName == "" {
+		log.Printf("No subaccount or bank details for admin ID %d, creator share accrues for payout", admin.ID)
+		return "", nil
+	}
+
+	log.Printf("Creating subaccount for admin ID %d", admin.ID)
+	code, err := creator.CreateSubaccount(payments.SubaccountRequest{
+		BusinessName:     admin.AccountName,
+		BankCode:         admin.BankCode,
+		AccountNumber:    admin.AccountNumber,
+		PercentageCharge: 10,
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrSubaccountFailed, err)
+	}
+	admin.PaystackSubaccountCode = code
+	if err := database.DB.Save(admin).Error; err != nil {
+		return "", fmt.Errorf("%w: %v", ErrSubaccountFailed, err)
+	}
+	return code, nil
+}
@@ -0,0 +1,28 @@
+package services
+
+import (
+	"fmt"
+	"math"
+
+	"Api/payments"
+)
+
+// PlatformCommission returns the fraction of a payment the platform keeps
+func PlatformCommission(paymentType string) (float64, error) {
+	switch paymentType {
+	case "purchase":
+		return 0.30, nil
+	case "rent":
+		return 0.20, nil
+	default:
+		return 0, fmt.Errorf("invalid payment type %q", paymentType)
+	}
+}
+
+// SplitShares divides amount between the platform and the creator in whole subunits,
+// so the two shares always add up to exactly amount
+func SplitShares(amount, platformPercent float64) (platform, creator float64) {
+	total := payments.ToMinor(amount)
+	cut := int64(math.Round(float64(total) * platformPercent))
+	return payments.FromMinor(cut), payments.FromMinor(total - cut)
+}
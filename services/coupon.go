@@ -0,0 +1,318 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"Api/database"
+	"Api/models"
+	"Api/payments"
+)
+
+var (
+	ErrCouponNotFound      = errors.New("coupon not found")
+	ErrCouponInactive      = errors.New("coupon is not active")
+	ErrCouponExpired       = errors.New("coupon has expired")
+	ErrCouponNotStarted    = errors.New("coupon is not valid yet")
+	ErrCouponUsedUp        = errors.New("coupon has reached its usage limit")
+	ErrCouponUserLimit     = errors.New("you have already used this coupon")
+	ErrCouponFirstOnly     = errors.New("coupon is only valid on your first purchase")
+	ErrCouponNotApplicable = errors.New("coupon does not apply to this bot")
+)
+
+// couponReservationTTL is how long an unpaid checkout holds one use of its coupon
+const couponReservationTTL = time.Hour
+
+// couponPaidStatuses are the transaction statuses that used up a coupon for good
+var couponPaidStatuses = []string{"success", "refunding", "refunded", "disputed"}
+
+// couponUses counts paid uses of a coupon, plus checkouts still waiting for payment
+// when reserved is set. A nil userID counts every buyer.
+func couponUses(tx *gorm.DB, couponID uint, userID *uint, reserved bool, excludeID uint) (int64, error) {
+	q := tx.Model(&models.Transaction{}).Where("coupon_id = ? AND id <> ?", couponID, excludeID)
+	if userID != nil {
+		q = q.Where("user_id = ?", *userID)
+	}
+	if reserved {
+		q = q.Where("(status IN ? OR (status = ? AND created_at > ?))", couponPaidStatuses, "pending", time.Now().Add(-couponReservationTTL))
+	} else {
+		q = q.Where("status IN ?", couponPaidStatuses)
+	}
+	var count int64
+	err := q.Count(&count).Error
+	return count, err
+}
+
+// CouponInput is what a creator or superadmin sends to create or change a coupon
+type CouponInput struct {
+	Code              string     `json:"code" binding:"required"`
+	Description       string     `json:"description"`
+	Type              string     `json:"type" binding:"required"`
+	Value             float64    `json:"value" binding:"required"`
+	Currency          string     `json:"currency"` // for "fixed" coupons, defaults to KES
+	BotID             *uint      `json:"bot_id"`
+	CreatorID         *uint      `json:"creator_id"` // superadmin only; creators always scope to themselves
+	PaymentType       string     `json:"payment_type"`
+	MaxUses           int        `json:"max_uses"`
+	PerUserLimit      int        `json:"per_user_limit"`
+	FirstPurchaseOnly bool       `json:"first_purchase_only"`
+	StartsAt          *time.Time `json:"starts_at"`
+	ExpiresAt         *time.Time `json:"expires_at"`
+	IsActive          *bool      `json:"is_active"`
+}
+
+// Validate normalises the code and checks the discount makes sense
+func (in *CouponInput) Validate() error {
+	in.Code = NormalizeCouponCode(in.Code)
+	if in.Code == "" {
+		return fmt.Errorf("code is required")
+	}
+	switch in.Type {
+	case "percent":
+		if in.Value <= 0 || in.Value >= 100 {
+			return fmt.Errorf("percent coupons must be between 0 and 100")
+		}
+	case "fixed":
+		if in.Value <= 0 {
+			return fmt.Errorf("value must be greater than zero")
+		}
+	default:
+		return fmt.Errorf("type must be 'percent' or 'fixed'")
+	}
+	currency, err := NormalizeCurrency(in.Currency, "KES")
+	if err != nil {
+		return err
+	}
+	in.Currency = currency
+	if in.PaymentType != "" && in.PaymentType != "purchase" && in.PaymentType != "rent" {
+		return fmt.Errorf("payment_type must be 'purchase', 'rent' or empty")
+	}
+	if in.MaxUses < 0 || in.PerUserLimit < 0 {
+		return fmt.Errorf("usage limits cannot be negative")
+	}
+	if in.StartsAt != nil && in.ExpiresAt != nil && !in.ExpiresAt.After(*in.StartsAt) {
+		return fmt.Errorf("expires_at must be after starts_at")
+	}
+	return nil
+}
+
+// Apply copies the input onto a coupon, including its scope
+func (in *CouponInput) Apply(coupon *models.Coupon) {
+	coupon.Code = in.Code
+	coupon.Description = in.Description
+	coupon.Type = in.Type
+	coupon.Value = in.Value
+	coupon.Currency = in.Currency
+	coupon.BotID = in.BotID
+	coupon.CreatorID = in.CreatorID
+	coupon.PaymentType = in.PaymentType
+	coupon.MaxUses = in.MaxUses
+	coupon.PerUserLimit = in.PerUserLimit
+	coupon.FirstPurchaseOnly = in.FirstPurchaseOnly
+	coupon.StartsAt = in.StartsAt
+	coupon.ExpiresAt = in.ExpiresAt
+	if in.IsActive != nil {
+		coupon.IsActive = *in.IsActive
+	}
+}
+
+// NormalizeCouponCode makes codes case-insensitive
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// PriceQuote is the price a buyer pays after any coupon, in the currency they pay in
+type PriceQuote struct {
+	Coupon             *models.Coupon `json:"-"`
+	Code               string         `json:"code,omitempty"`
+	Currency           string         `json:"currency"`
+	ListPrice          float64        `json:"list_price"`
+	Discount           float64        `json:"discount"`
+	Amount             float64        `json:"amount"`
+	SettlementCurrency string         `json:"settlement_currency"` // the bot's price currency
+	SettlementAmount   float64        `json:"settlement_amount"`
+	ExchangeRate       float64        `json:"exchange_rate"` // units of Currency per unit of SettlementCurrency
+	Tax                *TaxQuote      `json:"tax,omitempty"`
+}
+
+// ApplyCoupon checks code against the buyer, bot and payment type and returns the
+// discounted price in the bot's currency. An empty code returns the list price unchanged.
+func ApplyCoupon(code string, userID uint, bot *models.Bot, paymentType string, price float64) (*PriceQuote, error) {
+	currency := BotCurrency(bot)
+	quote := &PriceQuote{
+		Currency:           currency,
+		ListPrice:          price,
+		Amount:             price,
+		SettlementCurrency: currency,
+		SettlementAmount:   price,
+		ExchangeRate:       1,
+	}
+	code = NormalizeCouponCode(code)
+	if code == "" {
+		return quote, nil
+	}
+
+	var coupon models.Coupon
+	if err := database.DB.Where("code = ?", code).First(&coupon).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCouponNotFound
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	switch {
+	case !coupon.IsActive:
+		return nil, ErrCouponInactive
+	case coupon.StartsAt != nil && now.Before(*coupon.StartsAt):
+		return nil, ErrCouponNotStarted
+	case coupon.ExpiresAt != nil && now.After(*coupon.ExpiresAt):
+		return nil, ErrCouponExpired
+	case coupon.BotID != nil && *coupon.BotID != bot.ID,
+		coupon.CreatorID != nil && *coupon.CreatorID != bot.OwnerID,
+		coupon.PaymentType != "" && coupon.PaymentType != paymentType:
+		return nil, ErrCouponNotApplicable
+	case coupon.MaxUses > 0 && coupon.UsedCount >= coupon.MaxUses:
+		return nil, ErrCouponUsedUp
+	}
+
+	// Checkouts waiting for payment hold a use each, so a limited coupon cannot be
+	// handed to more buyers than it has uses left
+	if coupon.MaxUses > 0 {
+		var pending int64
+		if err := database.DB.Model(&models.Transaction{}).
+			Where("coupon_id = ? AND status = ? AND created_at > ?", coupon.ID, "pending", now.Add(-couponReservationTTL)).
+			Count(&pending).Error; err != nil {
+			return nil, err
+		}
+		if int64(coupon.UsedCount)+pending >= int64(coupon.MaxUses) {
+			return nil, ErrCouponUsedUp
+		}
+	}
+	if coupon.PerUserLimit > 0 {
+		used, err := couponUses(database.DB, coupon.ID, &userID, true, 0)
+		if err != nil {
+			return nil, err
+		}
+		if used >= int64(coupon.PerUserLimit) {
+			return nil, ErrCouponUserLimit
+		}
+	}
+	if coupon.FirstPurchaseOnly {
+		var paid int64
+		if err := database.DB.Model(&models.Transaction{}).
+			Where("user_id = ? AND status IN ?", userID, couponPaidStatuses).
+			Count(&paid).Error; err != nil {
+			return nil, err
+		}
+		if paid > 0 {
+			return nil, ErrCouponFirstOnly
+		}
+	}
+
+	discount := price * coupon.Value / 100
+	if coupon.Type == "fixed" {
+		var err error
+		if discount, _, err = Convert(coupon.Value, couponCurrency(&coupon), currency); err != nil {
+			return nil, err
+		}
+	}
+	discount = payments.RoundMinor(discount)
+	if discount >= price {
+		return nil, fmt.Errorf("coupon %s cannot cover the full price", coupon.Code)
+	}
+
+	quote.Coupon = &coupon
+	quote.Code = coupon.Code
+	quote.Discount = discount
+	quote.Amount = payments.FromMinor(payments.ToMinor(price) - payments.ToMinor(discount))
+	quote.SettlementAmount = quote.Amount
+	return quote, nil
+}
+
+// ConvertTo restates the quote in the currency the buyer pays in
+func (q *PriceQuote) ConvertTo(currency string) error {
+	if currency == q.Currency {
+		return nil
+	}
+	rate, err := ExchangeRateBetween(q.SettlementCurrency, currency)
+	if err != nil {
+		return err
+	}
+	round := func(v float64) float64 { return payments.RoundMinor(v * rate) }
+	q.Currency = currency
+	q.ExchangeRate = rate
+	q.ListPrice = round(q.ListPrice)
+	q.Discount = round(q.Discount)
+	q.Amount = round(q.SettlementAmount)
+	return nil
+}
+
+// ApplyTo records the currencies, tax and any coupon on a transaction before it is
+// saved. The transaction's Amount must already be set.
+func (q *PriceQuote) ApplyTo(transaction *models.Transaction) {
+	transaction.Currency = q.Currency
+	transaction.SettlementCurrency = q.SettlementCurrency
+	transaction.ExchangeRate = q.ExchangeRate
+	transaction.SettlementAmount = payments.RoundMinor(transaction.Amount / q.ExchangeRate)
+	if q.Tax != nil && q.Tax.Country != "" {
+		transaction.TaxCountry = q.Tax.Country
+		transaction.TaxName = q.Tax.Name
+		transaction.TaxRate = q.Tax.Rate
+		transaction.Tax = q.Tax.Tax
+		transaction.TaxInclusive = q.Tax.Inclusive
+		transaction.ReverseCharge = q.Tax.ReverseCharge
+		transaction.BuyerTaxID = q.Tax.BuyerTaxID
+	}
+	if q.Coupon == nil {
+		return
+	}
+	transaction.CouponID = &q.Coupon.ID
+	transaction.CouponCode = q.Code
+	transaction.ListPrice = q.ListPrice
+	transaction.Discount = q.Discount
+}
+
+func couponCurrency(coupon *models.Coupon) string {
+	if coupon.Currency == "" {
+		return "KES"
+	}
+	return coupon.Currency
+}
+
+// redeemCoupon counts a use of the transaction's coupon when its payment succeeds.
+// The limits were checked when the checkout started, but other checkouts may have
+// been paid since, so they are checked again here with the coupon row locked.
+func redeemCoupon(tx *gorm.DB, transaction *models.Transaction) error {
+	if transaction.CouponID == nil {
+		return nil
+	}
+	var coupon models.Coupon
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&coupon, *transaction.CouponID).Error; err != nil {
+		return err
+	}
+	if coupon.PerUserLimit > 0 {
+		used, err := couponUses(tx, coupon.ID, &transaction.UserID, false, transaction.ID)
+		if err != nil {
+			return err
+		}
+		if used >= int64(coupon.PerUserLimit) {
+			return ErrCouponUserLimit
+		}
+	}
+	res := tx.Model(&models.Coupon{}).
+		Where("id = ? AND (max_uses = 0 OR used_count < max_uses)", coupon.ID).
+		UpdateColumn("used_count", gorm.Expr("used_count + 1"))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrCouponUsedUp
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm/clause"
+
+	"Api/database"
+	"Api/internal/config"
+	"Api/models"
+	"Api/payments"
+)
+
+// SupportedCurrencies are the currencies bots can be priced and paid in
+var SupportedCurrencies = []string{"KES", "NGN", "GHS", "ZAR", "USD"}
+
+var (
+	ErrUnsupportedCurrency = errors.New("currency is not supported")
+	ErrNoExchangeRate      = errors.New("no exchange rate for this currency")
+)
+
+// NormalizeCurrency upper-cases a currency code and checks it is supported.
+// An empty code is returned as fallback.
+func NormalizeCurrency(currency, fallback string) (string, error) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		return fallback, nil
+	}
+	for _, c := range SupportedCurrencies {
+		if c == currency {
+			return currency, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnsupportedCurrency, currency)
+}
+
+// BotCurrency is the currency a bot is priced in
+func BotCurrency(bot *models.Bot) string {
+	if bot.Currency == "" {
+		return "KES"
+	}
+	return bot.Currency
+}
+
+// unitsPerUSD looks up the stored rate for currency
+func unitsPerUSD(currency string) (float64, error) {
+	if currency == "USD" {
+		return 1, nil
+	}
+	var rate models.ExchangeRate
+	if err := database.DB.Where("currency = ?", currency).First(&rate).Error; err != nil || rate.UnitsPerUSD <= 0 {
+		return 0, fmt.Errorf("%w: %s", ErrNoExchangeRate, currency)
+	}
+	return rate.UnitsPerUSD, nil
+}
+
+// ExchangeRateBetween returns how many units of to one unit of from buys
+func ExchangeRateBetween(from, to string) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+	fromRate, err := unitsPerUSD(from)
+	if err != nil {
+		return 0, err
+	}
+	toRate, err := unitsPerUSD(to)
+	if err != nil {
+		return 0, err
+	}
+	return toRate / fromRate, nil
+}
+
+// Convert converts amount between currencies, rounded to cents, and returns the rate used
+func Convert(amount float64, from, to string) (float64, float64, error) {
+	rate, err := ExchangeRateBetween(from, to)
+	if err != nil {
+		return 0, 0, err
+	}
+	return payments.RoundMinor(amount * rate), rate, nil
+}
+
+// SetExchangeRate stores the rate for currency, replacing any earlier one
+func SetExchangeRate(currency string, unitsPerUSD float64, source string, updatedBy *uint) error {
+	if unitsPerUSD <= 0 {
+		return fmt.Errorf("rate must be greater than zero")
+	}
+	rate := models.ExchangeRate{
+		Currency:    currency,
+		UnitsPerUSD: unitsPerUSD,
+		Source:      source,
+		UpdatedBy:   updatedBy,
+		UpdatedAt:   time.Now(),
+	}
+	return database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "currency"}},
+		DoUpdates: clause.AssignmentColumns([]string{"units_per_usd", "source", "updated_by", "updated_at"}),
+	}).Create(&rate).Error
+}
+
+// ImportExchangeRates fetches USD rates for the supported currencies from
+// EXCHANGE_RATES_URL, which must answer {"rates": {"KES": 129.1, ...}} with USD as base.
+func ImportExchangeRates() error {
+	url := config.Get().ExchangeRatesURL
+	if url == "" {
+		return fmt.Errorf("EXCHANGE_RATES_URL is not set")
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch exchange rates: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("exchange rate source returned %s", resp.Status)
+	}
+
+	var body struct {
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("invalid exchange rate response: %w", err)
+	}
+
+	imported := 0
+	for _, currency := range SupportedCurrencies {
+		rate, ok := body.Rates[currency]
+		if currency == "USD" || !ok {
+			continue
+		}
+		if err := SetExchangeRate(currency, rate, "import", nil); err != nil {
+			return err
+		}
+		imported++
+	}
+	log.Printf("Imported %d exchange rates", imported)
+	return nil
+}
+
+// PriceIn converts a price for display in another currency. Without a rate the
+// price is returned unchanged in its own currency.
+func PriceIn(amount float64, from, to string) (float64, string) {
+	converted, _, err := Convert(amount, from, to)
+	if err != nil {
+		return amount, from
+	}
+	return converted, to
+}
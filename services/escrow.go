@@ -0,0 +1,190 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"Api/database"
+	"Api/internal/config"
+	"Api/ledger"
+	"Api/models"
+)
+
+var (
+	ErrNotInEscrow         = errors.New("this purchase is not held in escrow")
+	ErrDisputeWindowClosed = errors.New("the dispute window for this purchase has closed")
+	ErrDisputeExists       = errors.New("a dispute is already open for this purchase")
+	ErrDisputeNotFound     = errors.New("dispute not found")
+	ErrDisputeClosed       = errors.New("dispute has already been resolved")
+)
+
+// EscrowWindow is how long a purchase's creator share is held so the buyer can
+// dispute it, from ESCROW_DISPUTE_DAYS. Zero, the default, turns escrow off.
+func EscrowWindow() time.Duration {
+	days := config.Get().EscrowDisputeDays
+	if days <= 0 {
+		return 0
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// holdInEscrow marks a purchase being fulfilled as held. Payments already split to
+// the creator's subaccount have left our hands and cannot be held.
+func holdInEscrow(transaction *models.Transaction) {
+	window := EscrowWindow()
+	if window == 0 || transaction.PaymentType != "purchase" || transaction.SplitAtSource {
+		return
+	}
+	releaseAt := time.Now().Add(window)
+	transaction.EscrowStatus = "held"
+	transaction.EscrowReleaseAt = &releaseAt
+}
+
+// ReleaseEscrow makes a held purchase's creator share available for payout.
+// Releasing an already released purchase changes nothing.
+func ReleaseEscrow(reference string) error {
+	unlock := referenceLocks.Lock(reference)
+	defer unlock()
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var transaction models.Transaction
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("reference = ?", reference).
+			First(&transaction).Error; err != nil {
+			return ErrTransactionNotFound
+		}
+		if transaction.EscrowStatus != "held" {
+			return nil
+		}
+		if transaction.Status != "success" {
+			return ErrNotInEscrow
+		}
+
+		now := time.Now()
+		transaction.EscrowStatus = "released"
+		transaction.EscrowReleasedAt = &now
+		transaction.UpdatedAt = now
+		if err := tx.Save(&transaction).Error; err != nil {
+			return fmt.Errorf("failed to update transaction: %w", err)
+		}
+		if err := ledger.PostEscrowRelease(tx, &transaction); err != nil {
+			return fmt.Errorf("failed to post escrow release to ledger: %w", err)
+		}
+		log.Printf("Escrow on %s released", reference)
+		return nil
+	})
+}
+
+// ReleaseDueEscrows releases every held purchase whose dispute window has passed
+// without an open dispute
+func ReleaseDueEscrows() error {
+	var due []models.Transaction
+	err := database.DB.
+		Where("escrow_status = ? AND status = ? AND escrow_release_at <= ?", "held", "success", time.Now()).
+		Where("NOT EXISTS (SELECT 1 FROM disputes WHERE disputes.transaction_id = transactions.id AND disputes.status = ?)", "open").
+		Find(&due).Error
+	if err != nil {
+		return err
+	}
+	for _, t := range due {
+		if err := ReleaseEscrow(t.Reference); err != nil {
+			log.Printf("Failed to release escrow on %s: %v", t.Reference, err)
+		}
+	}
+	return nil
+}
+
+// OpenBuyerDispute lets a buyer contest an escrowed purchase before its window closes.
+// The creator's share stays held until a superadmin rules on it.
+func OpenBuyerDispute(transactionID, buyerID uint, reason, evidence, attachmentPath string) (*models.Dispute, error) {
+	var transaction models.Transaction
+	if err := database.DB.Where("id = ? AND user_id = ?", transactionID, buyerID).First(&transaction).Error; err != nil {
+		return nil, ErrTransactionNotFound
+	}
+
+	unlock := referenceLocks.Lock(transaction.Reference)
+	defer unlock()
+
+	if err := database.DB.First(&transaction, transactionID).Error; err != nil {
+		return nil, ErrTransactionNotFound
+	}
+	if transaction.EscrowStatus != "held" || transaction.Status != "success" {
+		return nil, ErrNotInEscrow
+	}
+	if transaction.EscrowReleaseAt != nil && time.Now().After(*transaction.EscrowReleaseAt) {
+		return nil, ErrDisputeWindowClosed
+	}
+	var open int64
+	database.DB.Model(&models.Dispute{}).Where("transaction_id = ? AND status = ?", transactionID, "open").Count(&open)
+	if open > 0 {
+		return nil, ErrDisputeExists
+	}
+
+	now := time.Now()
+	dispute := models.Dispute{
+		TransactionID:  transaction.ID,
+		BuyerID:        buyerID,
+		CreatorID:      transaction.PreviousOwnerID,
+		Reason:         reason,
+		Evidence:       evidence,
+		AttachmentPath: attachmentPath,
+		Status:         "open",
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if err := database.DB.Create(&dispute).Error; err != nil {
+		return nil, fmt.Errorf("failed to open dispute: %w", err)
+	}
+	log.Printf("Buyer %d opened dispute %d on %s", buyerID, dispute.ID, transaction.Reference)
+	return &dispute, nil
+}
+
+// ResolveBuyerDispute rules on an open dispute. A refund returns the payment and
+// the bot to its previous owner; otherwise the escrow is released to the creator.
+func ResolveBuyerDispute(disputeID, reviewerID uint, refund bool, ruling string) (*models.Dispute, error) {
+	var dispute models.Dispute
+	if err := database.DB.First(&dispute, disputeID).Error; err != nil {
+		return nil, ErrDisputeNotFound
+	}
+	if dispute.Status != "open" {
+		return nil, ErrDisputeClosed
+	}
+
+	var transaction models.Transaction
+	if err := database.DB.First(&transaction, dispute.TransactionID).Error; err != nil {
+		return nil, ErrTransactionNotFound
+	}
+
+	outcome := "released"
+	if refund {
+		outcome = "refunded"
+		if _, err := RefundOrder(transaction.ID, "dispute upheld: "+ruling, false); err != nil {
+			return nil, err
+		}
+	} else if err := ReleaseEscrow(transaction.Reference); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	res := database.DB.Model(&dispute).Where("status = ?", "open").Updates(map[string]interface{}{
+		"status":      outcome,
+		"ruling":      ruling,
+		"resolved_by": reviewerID,
+		"resolved_at": now,
+		"updated_at":  now,
+	})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrDisputeClosed
+	}
+	database.DB.First(&dispute, disputeID)
+	log.Printf("Superadmin %d resolved dispute %d on %s: %s", reviewerID, dispute.ID, transaction.Reference, outcome)
+	return &dispute, nil
+}
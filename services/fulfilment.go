@@ -0,0 +1,353 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"Api/database"
+	"Api/ledger"
+	"Api/models"
+	"Api/payments"
+)
+
+var (
+	ErrTransactionNotFound = errors.New("transaction not found")
+	ErrBotNotFound         = errors.New("bot not found")
+	ErrTransactionClosed   = errors.New("transaction can no longer be fulfilled")
+)
+
+// AmountTooLowError is returned when the provider reports less money than the transaction
+// asked for, or money in a different currency
+type AmountTooLowError struct {
+	Paid             float64
+	Expected         float64
+	PaidCurrency     string
+	ExpectedCurrency string
+}
+
+func (e *AmountTooLowError) Error() string {
+	return fmt.Sprintf("Payment amount (%s %.2f) is less than expected (%s %.2f)", e.PaidCurrency, e.Paid, e.ExpectedCurrency, e.Expected)
+}
+
+// FulfilmentResult describes the access granted for a paid transaction
+type FulfilmentResult struct {
+	Reference     string     `json:"reference"`
+	TransactionID uint       `json:"transaction_id"`
+	BotID         uint       `json:"bot_id"`
+	UserID        uint       `json:"user_id"`
+	PaymentType   string     `json:"payment_type"`
+	UserBotID     uint       `json:"user_bot_id"`
+	ExpiryDate    *time.Time `json:"expiry_date,omitempty"`
+}
+
+// referenceLocks serialises fulfilment of the same reference inside this process;
+// the row lock taken in FulfilOrder covers other instances.
+var referenceLocks = newKeyedMutex()
+
+// FulfilOrder marks a transaction as paid and grants the bot to the buyer. currency is
+// what the provider reports the payment in; empty means the transaction's own currency.
+// It is safe to call any number of times for the same reference: the first call
+// does the work and every later call returns the same result without side effects.
+func FulfilOrder(reference string, amountPaid float64, currency string) (*FulfilmentResult, error) {
+	unlock := referenceLocks.Lock(reference)
+	defer unlock()
+
+	var result *FulfilmentResult
+	fulfilled := false
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var transaction models.Transaction
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("reference = ?", reference).
+			First(&transaction).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrTransactionNotFound
+			}
+			return err
+		}
+
+		if transaction.Status == "success" {
+			log.Printf("Transaction %s already fulfilled, returning existing result", reference)
+			var err error
+			result, err = existingResult(tx, &transaction)
+			return err
+		}
+		if transaction.Status != "pending" && transaction.Status != "failed" {
+			return ErrTransactionClosed
+		}
+
+		expectedCurrency := ledger.CurrencyOf(&transaction)
+		if currency == "" {
+			currency = expectedCurrency
+		}
+		if amountPaid < transaction.Amount || !strings.EqualFold(currency, expectedCurrency) {
+			return &AmountTooLowError{Paid: amountPaid, Expected: transaction.Amount, PaidCurrency: currency, ExpectedCurrency: expectedCurrency}
+		}
+
+		if transaction.PaymentType == "topup" {
+			var err error
+			result, err = fulfilTopUp(tx, &transaction)
+			return err
+		}
+
+		var bot models.Bot
+		if err := tx.First(&bot, transaction.BotID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrBotNotFound
+			}
+			return err
+		}
+
+		transaction.Status = "success"
+		transaction.UpdatedAt = time.Now()
+		holdInEscrow(&transaction)
+		if err := tx.Save(&transaction).Error; err != nil {
+			return fmt.Errorf("failed to update transaction: %w", err)
+		}
+		if err := redeemCoupon(tx, &transaction); err != nil {
+			// A coupon over its limit leaves the order unfulfilled for review, like
+			// a payment that came up short
+			log.Printf("Coupon on %s can no longer be redeemed, order needs review: %v", reference, err)
+			return fmt.Errorf("failed to redeem coupon: %w", err)
+		}
+
+		var userBot *models.UserBot
+		var err error
+		switch transaction.PaymentType {
+		case "purchase":
+			userBot, err = grantPurchase(tx, &transaction, &bot)
+		case "rent":
+			userBot, err = grantRental(tx, &transaction, &bot)
+		default:
+			err = fmt.Errorf("unknown payment type %q", transaction.PaymentType)
+		}
+		if err != nil {
+			return err
+		}
+		if err := ledger.PostPayment(tx, &transaction); err != nil {
+			return fmt.Errorf("failed to post payment to ledger: %w", err)
+		}
+		if transaction.PaymentChannel == payments.ChannelWallet {
+			if err := captureWalletHold(tx, &transaction); err != nil {
+				return err
+			}
+		}
+
+		result = newResult(&transaction, userBot)
+		fulfilled = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if fulfilled {
+		log.Printf("Transaction %s fulfilled: user_bot=%d", reference, result.UserBotID)
+		go issueReceipt(result.TransactionID)
+	}
+	return result, nil
+}
+
+// grantPurchase transfers ownership of the bot to the buyer
+func grantPurchase(tx *gorm.DB, transaction *models.Transaction, bot *models.Bot) (*models.UserBot, error) {
+	now := time.Now()
+	originalOwnerID := bot.OwnerID
+	bot.OwnerID = transaction.UserID
+	if err := tx.Save(bot).Error; err != nil {
+		return nil, fmt.Errorf("failed to update bot ownership: %w", err)
+	}
+
+	transaction.PreviousOwnerID = originalOwnerID
+	if err := tx.Model(transaction).Update("previous_owner_id", originalOwnerID).Error; err != nil {
+		return nil, fmt.Errorf("failed to update transaction: %w", err)
+	}
+
+	sale := models.Sale{
+		BotID:     transaction.BotID,
+		SellerID:  originalOwnerID,
+		BuyerID:   transaction.UserID,
+		Amount:    transaction.Amount,
+		SaleType:  "purchase",
+		SaleDate:  now,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := tx.Create(&sale).Error; err != nil {
+		return nil, fmt.Errorf("failed to record sale: %w", err)
+	}
+
+	if originalOwnerID != transaction.UserID {
+		if err := tx.Where("user_id = ? AND bot_id = ?", originalOwnerID, bot.ID).Delete(&models.UserBot{}).Error; err != nil {
+			return nil, fmt.Errorf("failed to remove old owner access: %w", err)
+		}
+	}
+
+	var userBot models.UserBot
+	err := tx.Where("user_id = ? AND bot_id = ?", transaction.UserID, transaction.BotID).First(&userBot).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	userBot.UserID = transaction.UserID
+	userBot.BotID = transaction.BotID
+	userBot.AccessType = "purchase"
+	userBot.IsActive = true
+	userBot.TransactionID = &transaction.ID
+	userBot.Price = transaction.Amount
+	userBot.ExpiryDate = nil
+	userBot.PurchaseDate = now
+	userBot.UpdatedAt = now
+	if err := tx.Save(&userBot).Error; err != nil {
+		return nil, fmt.Errorf("failed to create user_bot entry: %w", err)
+	}
+	return &userBot, nil
+}
+
+// grantRental gives the renter access for the period of the plan paid for, extending any active rental
+func grantRental(tx *gorm.DB, transaction *models.Transaction, bot *models.Bot) (*models.UserBot, error) {
+	now := time.Now()
+	sale := models.Sale{
+		BotID:     transaction.BotID,
+		SellerID:  bot.OwnerID,
+		BuyerID:   transaction.UserID,
+		Amount:    transaction.Amount,
+		SaleType:  "rent",
+		SaleDate:  now,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := tx.Create(&sale).Error; err != nil {
+		return nil, fmt.Errorf("failed to record sale: %w", err)
+	}
+
+	var userBot models.UserBot
+	err := tx.Where("user_id = ? AND bot_id = ?", transaction.UserID, transaction.BotID).First(&userBot).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if err == nil && userBot.AccessType == "purchase" {
+		// Owners already have permanent access, nothing to extend
+		return &userBot, nil
+	}
+
+	// A renewal charged during the grace period continues from the old expiry,
+	// so the grace days are paid for rather than given away
+	start := now
+	if userBot.IsActive && userBot.ExpiryDate != nil && userBot.ExpiryDate.After(now.Add(-RenewalGracePeriod)) {
+		start = *userBot.ExpiryDate
+	}
+	expiry := start.Add(rentalPeriod(transaction))
+
+	userBot.UserID = transaction.UserID
+	userBot.BotID = transaction.BotID
+	userBot.AccessType = "rent"
+	userBot.IsActive = true
+	userBot.TransactionID = &transaction.ID
+	userBot.Price = transaction.Amount
+	userBot.ExpiryDate = &expiry
+	if userBot.ID == 0 {
+		userBot.PurchaseDate = now
+	}
+	userBot.UpdatedAt = now
+	if err := tx.Save(&userBot).Error; err != nil {
+		return nil, fmt.Errorf("failed to create user_bot entry for rent: %w", err)
+	}
+	return &userBot, nil
+}
+
+// existingResult rebuilds the result of an earlier fulfilment
+func existingResult(tx *gorm.DB, transaction *models.Transaction) (*FulfilmentResult, error) {
+	var userBot models.UserBot
+	err := tx.Where("transaction_id = ?", transaction.ID).First(&userBot).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = tx.Where("user_id = ? AND bot_id = ?", transaction.UserID, transaction.BotID).First(&userBot).Error
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return newResult(transaction, &userBot), nil
+}
+
+func newResult(transaction *models.Transaction, userBot *models.UserBot) *FulfilmentResult {
+	return &FulfilmentResult{
+		Reference:     transaction.Reference,
+		TransactionID: transaction.ID,
+		BotID:         transaction.BotID,
+		UserID:        transaction.UserID,
+		PaymentType:   transaction.PaymentType,
+		UserBotID:     userBot.ID,
+		ExpiryDate:    userBot.ExpiryDate,
+	}
+}
+
+// keyedMutex hands out one mutex per key and forgets keys nobody holds
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*refCountedMutex
+}
+
+type refCountedMutex struct {
+	sync.Mutex
+	refs int
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: map[string]*refCountedMutex{}}
+}
+
+// Lock blocks until key is free and returns the matching unlock function
+func (k *keyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	m, ok := k.locks[key]
+	if !ok {
+		m = &refCountedMutex{}
+		k.locks[key] = m
+	}
+	m.refs++
+	k.mu.Unlock()
+
+	m.Lock()
+	return func() {
+		m.Unlock()
+		k.mu.Lock()
+		m.refs--
+		if m.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// FailOrder marks a pending transaction as failed; fulfilled transactions are left alone
+func FailOrder(reference, reason string) error {
+	res := database.DB.Model(&models.Transaction{}).
+		Where("reference = ? AND status = ?", reference, "pending").
+		Updates(map[string]interface{}{"status": "failed", "updated_at": time.Now()})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		log.Printf("Transaction %s marked failed: %s", reference, reason)
+	}
+	return nil
+}
+
+// RecordProviderFee books the fee a provider kept from a fulfilled payment
+func RecordProviderFee(reference string, fee int64) error {
+	if fee <= 0 {
+		return nil
+	}
+	var transaction models.Transaction
+	if err := database.DB.Where("reference = ?", reference).First(&transaction).Error; err != nil {
+		return ErrTransactionNotFound
+	}
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		return ledger.PostProviderFee(tx, &transaction, fee)
+	})
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"Api/database"
+	"Api/invoice"
+	"Api/ledger"
+	"Api/mailer"
+	"Api/models"
+)
+
+var ErrInvoiceNotFound = errors.New("invoice not found")
+
+// IssueInvoice numbers and stores the receipt for a successful transaction.
+// Calling it again returns the invoice already issued.
+func IssueInvoice(transactionID uint) (*models.Invoice, error) {
+	var existing models.Invoice
+	if err := database.DB.Where("transaction_id = ?", transactionID).First(&existing).Error; err == nil {
+		return &existing, nil
+	}
+
+	var transaction models.Transaction
+	if err := database.DB.First(&transaction, transactionID).Error; err != nil {
+		return nil, ErrTransactionNotFound
+	}
+	if transaction.Status != "success" {
+		return nil, fmt.Errorf("transaction %s is %s, not paid", transaction.Reference, transaction.Status)
+	}
+
+	var buyer models.Person
+	database.DB.First(&buyer, transaction.UserID)
+	var bot models.Bot
+	database.DB.First(&bot, transaction.BotID)
+	creatorID := bot.OwnerID
+	if transaction.PaymentType == "purchase" && transaction.PreviousOwnerID != 0 {
+		creatorID = transaction.PreviousOwnerID
+	}
+	var creator models.Person
+	database.DB.First(&creator, creatorID)
+
+	listPrice := transaction.ListPrice
+	if listPrice == 0 {
+		listPrice = transaction.Amount
+	}
+	now := time.Now()
+	inv := models.Invoice{
+		Number:         "PENDING-" + transaction.Reference,
+		TransactionID:  transaction.ID,
+		UserID:         transaction.UserID,
+		CreatorID:      creatorID,
+		BuyerName:      buyer.Name,
+		BuyerEmail:     buyer.Email,
+		CreatorName:    creator.Name,
+		BotID:          bot.ID,
+		BotName:        bot.Name,
+		PaymentType:    transaction.PaymentType,
+		RentalDays:     transaction.RentalDays,
+		Currency:       ledger.CurrencyOf(&transaction),
+		ListPrice:      listPrice,
+		Discount:       transaction.Discount,
+		CouponCode:     transaction.CouponCode,
+		TaxRate:        transaction.TaxRate,
+		Tax:            transaction.Tax,
+		Total:          transaction.Amount,
+		CompanyShare:   transaction.CompanyShare,
+		CreatorShare:   transaction.AdminShare,
+		PaymentChannel: transaction.PaymentChannel,
+		Reference:      transaction.Reference,
+		ProviderRef:    transaction.ProviderRef,
+		PaidAt:         transaction.UpdatedAt,
+		IssuedAt:       now,
+
+		TaxName:       transaction.TaxName,
+		TaxCountry:    transaction.TaxCountry,
+		TaxInclusive:  transaction.TaxInclusive,
+		ReverseCharge: transaction.ReverseCharge,
+		BuyerTaxID:    transaction.BuyerTaxID,
+	}
+	if transaction.RentalPlanID != nil {
+		var plan models.RentalPlan
+		if err := database.DB.First(&plan, *transaction.RentalPlanID).Error; err == nil {
+			inv.PlanName = plan.Name
+		}
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&inv)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			// Issued by a concurrent call
+			return tx.Where("transaction_id = ?", transactionID).First(&inv).Error
+		}
+		// Invoice numbers follow the row ID so they are sequential and never reused
+		inv.Number = fmt.Sprintf("INV-%d-%06d", now.Year(), inv.ID)
+		return tx.Model(&inv).Update("number", inv.Number).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue invoice for %s: %w", transaction.Reference, err)
+	}
+	return &inv, nil
+}
+
+// SendReceipt emails the receipt to the buyer, and a copy to the creator, with the
+// PDF attached
+func SendReceipt(inv *models.Invoice) error {
+	attachment := mailer.Attachment{
+		Filename:    invoice.Filename(inv, "pdf"),
+		ContentType: "application/pdf",
+		Data:        invoice.RenderPDF(inv),
+	}
+	data := mailer.Data{
+		"Number":      inv.Number,
+		"BotName":     inv.BotName,
+		"BuyerName":   inv.BuyerName,
+		"Amount":      invoice.Money(inv.Currency, inv.Total),
+		"Share":       invoice.Money(inv.Currency, inv.CreatorShare),
+		"Description": invoice.Description(inv),
+	}
+
+	if inv.BuyerEmail != "" {
+		data["Name"] = inv.BuyerName
+		msg, err := mailer.Render(mailer.TemplateReceipt, data)
+		if err != nil {
+			return err
+		}
+		msg.To = []string{inv.BuyerEmail}
+		msg.Attachments = []mailer.Attachment{attachment}
+		if err := mailer.Send(msg); err != nil {
+			return err
+		}
+	}
+
+	var creator models.Person
+	if err := database.DB.First(&creator, inv.CreatorID).Error; err == nil && creator.Email != "" {
+		data["Name"] = creator.Name
+		msg, err := mailer.Render(mailer.TemplateSaleNotice, data)
+		if err == nil {
+			msg.To = []string{creator.Email}
+			msg.Attachments = []mailer.Attachment{attachment}
+			err = mailer.Send(msg)
+		}
+		if err != nil {
+			log.Printf("Failed to email sale notice for %s to creator %d: %v", inv.Number, inv.CreatorID, err)
+		}
+	}
+
+	now := time.Now()
+	inv.EmailedAt = &now
+	return database.DB.Model(inv).Update("emailed_at", now).Error
+}
+
+// issueReceipt issues and emails the receipt for a fulfilled transaction. It runs
+// after the fulfilment commits, so a mail failure never undoes a payment.
+func issueReceipt(transactionID uint) {
+	inv, err := IssueInvoice(transactionID)
+	if err != nil {
+		log.Printf("Failed to issue invoice for transaction %d: %v", transactionID, err)
+		return
+	}
+	if inv.EmailedAt != nil {
+		return
+	}
+	if err := SendReceipt(inv); err != nil {
+		log.Printf("Failed to email receipt %s: %v", inv.Number, err)
+	}
+}
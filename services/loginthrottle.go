@@ -0,0 +1,341 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"Api/database"
+	"Api/internal/config"
+	"Api/mailer"
+	"Api/models"
+	"Api/utils"
+)
+
+// Login throttling. Every failed login counts against the client IP and against
+// the email that was tried. Past a number of free attempts each further failure
+// doubles the wait before the next try; an account that keeps failing is locked
+// for AccountLockoutDuration and its owner is emailed a link to unlock it.
+const (
+	accountFreeAttempts = 3
+	ipFreeAttempts      = 10
+	maxLoginBackoff     = 15 * time.Minute
+
+	// AccountLockoutThreshold failures in a row lock the account
+	AccountLockoutThreshold = 10
+	AccountLockoutDuration  = 30 * time.Minute
+
+	// loginAttemptWindow is how long a failure counts; counters start over after it
+	loginAttemptWindow = time.Hour
+	unlockLinkTTL      = 24 * time.Hour
+)
+
+var ErrInvalidUnlockToken = errors.New("invalid or expired unlock link")
+
+// LoginThrottledError means the caller must wait before trying to log in again
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool // the account is locked, not just slowed down
+}
+
+func (e *LoginThrottledError) Error() string {
+	wait := e.RetryAfter.Round(time.Second)
+	if wait < time.Second {
+		wait = time.Second
+	}
+	if e.Locked {
+		return fmt.Sprintf("too many failed logins, this account is locked for %s; check your email to unlock it", wait)
+	}
+	return fmt.Sprintf("too many failed logins, try again in %s", wait)
+}
+
+// LoginAttemptStore keeps failure counters. Failures older than the window no
+// longer count.
+type LoginAttemptStore interface {
+	Get(key string) (models.LoginAttempt, error)
+	RecordFailure(key string, now time.Time) (models.LoginAttempt, error)
+	Reset(key string) error
+	// List returns counters with at least min failures since the given time
+	List(prefix string, min int, since time.Time) ([]models.LoginAttempt, error)
+	Prune(before time.Time) error
+}
+
+var loginAttempts LoginAttemptStore = NewMemoryLoginAttemptStore()
+
+// InitLoginThrottle picks the counter store named by LOGIN_ATTEMPT_STORE
+func InitLoginThrottle() {
+	if config.Get().LoginAttemptStore == "memory" {
+		log.Printf("Login attempts are counted in memory; use the database store when running more than one instance")
+		loginAttempts = NewMemoryLoginAttemptStore()
+		return
+	}
+	loginAttempts = DBLoginAttemptStore{}
+}
+
+func ipKey(ip string) string         { return "ip:" + ip }
+func accountKey(email string) string { return "account:" + strings.ToLower(strings.TrimSpace(email)) }
+
+// loginBackoff is the wait after the given number of failures
+func loginBackoff(failures, free int) time.Duration {
+	if failures < free {
+		return 0
+	}
+	delay := time.Second
+	for i := free; i < failures && delay < maxLoginBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxLoginBackoff {
+		delay = maxLoginBackoff
+	}
+	return delay
+}
+
+// accountWait is how long the account key must wait, and whether it is a lockout
+func accountWait(a models.LoginAttempt, now time.Time) (time.Duration, bool) {
+	if a.Failures >= AccountLockoutThreshold {
+		return a.LastFailureAt.Add(AccountLockoutDuration).Sub(now), true
+	}
+	return a.LastFailureAt.Add(loginBackoff(a.Failures, accountFreeAttempts)).Sub(now), false
+}
+
+// CheckLoginAllowed returns a *LoginThrottledError while the IP or the email has
+// to wait. Store errors let the login through rather than lock everyone out.
+func CheckLoginAllowed(ip, email string) error {
+	now := time.Now()
+	if a, err := loginAttempts.Get(accountKey(email)); err == nil {
+		if wait, locked := accountWait(a, now); wait > 0 {
+			return &LoginThrottledError{RetryAfter: wait, Locked: locked}
+		}
+	}
+	if a, err := loginAttempts.Get(ipKey(ip)); err == nil {
+		if wait := a.LastFailureAt.Add(loginBackoff(a.Failures, ipFreeAttempts)).Sub(now); wait > 0 {
+			return &LoginThrottledError{RetryAfter: wait}
+		}
+	}
+	return nil
+}
+
+// RecordLoginFailure counts a wrong password, or an email with no account. Each
+// failure that locks an existing account, at the threshold or once a lockout has
+// run out, sends the owner an unlock link.
+func RecordLoginFailure(ip, email string) {
+	now := time.Now()
+	if _, err := loginAttempts.RecordFailure(ipKey(ip), now); err != nil {
+		log.Printf("Failed to record login failure for IP %s: %v", ip, err)
+	}
+	a, err := loginAttempts.RecordFailure(accountKey(email), now)
+	if err != nil {
+		log.Printf("Failed to record login failure for %s: %v", email, err)
+		return
+	}
+	if a.Failures >= AccountLockoutThreshold {
+		go sendUnlockEmail(strings.ToLower(strings.TrimSpace(email)), ip)
+	}
+}
+
+// RecordLoginSuccess clears the account's counter. The IP counter is left alone
+// so logging in to one account does not buy more guesses at others.
+func RecordLoginSuccess(email string) {
+	if err := loginAttempts.Reset(accountKey(email)); err != nil {
+		log.Printf("Failed to reset login failures for %s: %v", email, err)
+	}
+}
+
+func sendUnlockEmail(email, ip string) {
+	var user models.Person
+	if database.DB.Where("email = ?", email).First(&user).Error != nil {
+		return
+	}
+	log.Printf("Account %d locked after %d failed logins, last from %s", user.ID, AccountLockoutThreshold, ip)
+
+	token := utils.GenerateResetToken()
+	if err := database.DB.Model(&models.Person{}).Where("id = ?", user.ID).UpdateColumns(map[string]interface{}{
+		"unlock_token":  token,
+		"unlock_expiry": time.Now().Add(unlockLinkTTL),
+	}).Error; err != nil {
+		log.Printf("Failed to save unlock token for user %d: %v", user.ID, err)
+		return
+	}
+	err := mailer.SendTemplate(user.Email, mailer.TemplateAccountLocked, mailer.Data{
+		"Name":      user.Name,
+		"IP":        ip,
+		"LockedFor": "30 minutes",
+		"Link":      config.Get().PublicURL + "/api/auth/unlock?token=" + url.QueryEscape(token),
+	})
+	if err != nil {
+		log.Printf("Failed to send unlock email to user %d: %v", user.ID, err)
+	}
+}
+
+// UnlockAccountWithToken lifts a lockout from the link in the lockout email
+func UnlockAccountWithToken(token string) (*models.Person, error) {
+	var user models.Person
+	if token == "" || database.DB.Where("unlock_token = ?", token).First(&user).Error != nil {
+		return nil, ErrInvalidUnlockToken
+	}
+	if time.Now().After(user.UnlockExpiry) {
+		return nil, ErrInvalidUnlockToken
+	}
+	if err := UnlockAccount(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UnlockAccount clears the account's failed logins and any unlock link
+func UnlockAccount(user *models.Person) error {
+	if err := loginAttempts.Reset(accountKey(user.Email)); err != nil {
+		return fmt.Errorf("failed to reset login failures: %w", err)
+	}
+	return database.DB.Model(&models.Person{}).Where("id = ?", user.ID).
+		UpdateColumn("unlock_token", "").Error
+}
+
+// LockedAccount is an account that is locked out right now
+type LockedAccount struct {
+	UserID        uint      `json:"user_id"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email"`
+	Role          string    `json:"role"`
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+	LockedUntil   time.Time `json:"locked_until"`
+}
+
+// LockedAccounts lists existing accounts that are locked out, most recent first.
+// Locked emails without an account are left out.
+func LockedAccounts() ([]LockedAccount, error) {
+	now := time.Now()
+	attempts, err := loginAttempts.List("account:", AccountLockoutThreshold, now.Add(-AccountLockoutDuration))
+	if err != nil {
+		return nil, err
+	}
+	locked := []LockedAccount{}
+	for _, a := range attempts {
+		var user models.Person
+		if database.DB.Where("email = ?", strings.TrimPrefix(a.Key, "account:")).First(&user).Error != nil {
+			continue
+		}
+		locked = append(locked, LockedAccount{
+			UserID:        user.ID,
+			Name:          user.Name,
+			Email:         user.Email,
+			Role:          string(user.Role),
+			Failures:      a.Failures,
+			LastFailureAt: a.LastFailureAt,
+			LockedUntil:   a.LastFailureAt.Add(AccountLockoutDuration),
+		})
+	}
+	return locked, nil
+}
+
+// PruneLoginAttempts drops counters that fell out of the window
+func PruneLoginAttempts() error {
+	return loginAttempts.Prune(time.Now().Add(-loginAttemptWindow))
+}
+
+// DBLoginAttemptStore keeps counters in the login_attempts table, shared by every instance
+type DBLoginAttemptStore struct{}
+
+func (DBLoginAttemptStore) Get(key string) (models.LoginAttempt, error) {
+	var a models.LoginAttempt
+	err := database.DB.Where("key = ? AND last_failure_at > ?", key, time.Now().Add(-loginAttemptWindow)).
+		Limit(1).Find(&a).Error
+	return a, err
+}
+
+func (DBLoginAttemptStore) RecordFailure(key string, now time.Time) (models.LoginAttempt, error) {
+	var a models.LoginAttempt
+	// One statement, so concurrent failures all count
+	err := database.DB.Raw(`INSERT INTO login_attempts (key, failures, last_failure_at) VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure_at <= ? THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure_at = EXCLUDED.last_failure_at
+		RETURNING key, failures, last_failure_at`, key, now, now.Add(-loginAttemptWindow)).Scan(&a).Error
+	return a, err
+}
+
+func (DBLoginAttemptStore) Reset(key string) error {
+	return database.DB.Where("key = ?", key).Delete(&models.LoginAttempt{}).Error
+}
+
+func (DBLoginAttemptStore) List(prefix string, min int, since time.Time) ([]models.LoginAttempt, error) {
+	var attempts []models.LoginAttempt
+	err := database.DB.Where("key LIKE ? AND failures >= ? AND last_failure_at > ?", prefix+"%", min, since).
+		Order("last_failure_at DESC").
+		Find(&attempts).Error
+	return attempts, err
+}
+
+func (DBLoginAttemptStore) Prune(before time.Time) error {
+	return database.DB.Where("last_failure_at <= ?", before).Delete(&models.LoginAttempt{}).Error
+}
+
+// MemoryLoginAttemptStore keeps counters in this process only
+type MemoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]models.LoginAttempt
+}
+
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{attempts: map[string]models.LoginAttempt{}}
+}
+
+func (m *MemoryLoginAttemptStore) Get(key string) (models.LoginAttempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.attempts[key]
+	if !ok || !a.LastFailureAt.After(time.Now().Add(-loginAttemptWindow)) {
+		return models.LoginAttempt{}, nil
+	}
+	return a, nil
+}
+
+func (m *MemoryLoginAttemptStore) RecordFailure(key string, now time.Time) (models.LoginAttempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.attempts[key]
+	if !ok || !a.LastFailureAt.After(now.Add(-loginAttemptWindow)) {
+		a = models.LoginAttempt{Key: key}
+	}
+	a.Failures++
+	a.LastFailureAt = now
+	m.attempts[key] = a
+	return a, nil
+}
+
+func (m *MemoryLoginAttemptStore) Reset(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.attempts, key)
+	return nil
+}
+
+func (m *MemoryLoginAttemptStore) List(prefix string, min int, since time.Time) ([]models.LoginAttempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var attempts []models.LoginAttempt
+	for key, a := range m.attempts {
+		if strings.HasPrefix(key, prefix) && a.Failures >= min && a.LastFailureAt.After(since) {
+			attempts = append(attempts, a)
+		}
+	}
+	sort.Slice(attempts, func(i, j int) bool { return attempts[i].LastFailureAt.After(attempts[j].LastFailureAt) })
+	return attempts, nil
+}
+
+func (m *MemoryLoginAttemptStore) Prune(before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, a := range m.attempts {
+		if !a.LastFailureAt.After(before) {
+			delete(m.attempts, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"Api/database"
+	"Api/internal/config"
+	"Api/ledger"
+	"Api/models"
+	"Api/payments"
+)
+
+// DefaultPayoutMinimum is the smallest withdrawal in cents of a shilling (KES 1,000)
+const DefaultPayoutMinimum int64 = 100000
+
+var (
+	ErrPayoutBelowMinimum   = errors.New("amount is below the minimum payout")
+	ErrInsufficientBalance  = errors.New("amount is more than your available balance")
+	ErrPayoutDetailsMissing = errors.New("add your bank details or M-Pesa number before requesting a payout")
+	ErrPayoutNotFound       = errors.New("payout not found")
+	ErrPayoutState          = errors.New("payout can no longer be changed")
+	ErrTransferUnconfirmed  = errors.New("the provider did not confirm the transfer; the payout stays processing until it reports the outcome")
+)
+
+// openPayoutStatuses hold money that is on its way to a creator
+var openPayoutStatuses = []string{"requested", "processing"}
+
+// PayoutMinimum returns the minimum payout in subunits of currency. PAYOUT_MINIMUM
+// sets it in shillings and other currencies use the equivalent at today's rate.
+func PayoutMinimum(currency string) int64 {
+	minimum := DefaultPayoutMinimum
+	if shillings := config.Get().PayoutMinimum; shillings > 0 {
+		minimum = payments.ToMinor(shillings)
+	}
+	if converted, _, err := Convert(payments.FromMinor(minimum), "KES", currency); err == nil {
+		return payments.ToMinor(converted)
+	}
+	return minimum
+}
+
+// AvailableForPayout is what a creator has earned in currency less payouts still in flight
+func AvailableForPayout(creatorID uint, currency string) (int64, error) {
+	balance, err := ledger.CreatorBalance(creatorID, currency)
+	if err != nil {
+		return 0, err
+	}
+	var open int64
+	if err := database.DB.Model(&models.Payout{}).
+		Select("COALESCE(SUM(amount), 0)").
+		Where("creator_id = ? AND currency = ? AND status IN ?", creatorID, currency, openPayoutStatuses).
+		Scan(&open).Error; err != nil {
+		return 0, err
+	}
+	return balance - open, nil
+}
+
+// RequestPayout queues a withdrawal of earnings in currency for superadmin approval.
+// The money goes to the creator's M-Pesa number or bank account, whichever payout
+// method they chose.
+func RequestPayout(creatorID uint, amount int64, currency string) (*models.Payout, error) {
+	unlock := referenceLocks.Lock(fmt.Sprintf("payout:%d", creatorID))
+	defer unlock()
+
+	if amount < PayoutMinimum(currency) {
+		return nil, ErrPayoutBelowMinimum
+	}
+	var admin models.Admin
+	if err := database.DB.Where("person_id = ?", creatorID).First(&admin).Error; err != nil {
+		return nil, ErrPayoutDetailsMissing
+	}
+
+	payout := models.Payout{
+		CreatorID: creatorID,
+		Amount:    amount,
+		Currency:  currency,
+		Reference: fmt.Sprintf("PO_%d_%d", creatorID, time.Now().Unix()),
+		Status:    "requested",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if admin.PayoutMethod == "mpesa" {
+		if admin.PayoutPhone == "" {
+			return nil, ErrPayoutDetailsMissing
+		}
+		if currency != "KES" {
+			return nil, fmt.Errorf("M-Pesa payouts are only made in KES")
+		}
+		if amount%100 != 0 {
+			return nil, fmt.Errorf("M-Pesa payouts must be whole shillings")
+		}
+		payout.Channel = "M-Pesa"
+		payout.Destination = mask(admin.PayoutPhone)
+	} else {
+		if admin.BankCode == "" || admin.AccountNumber == "" || admin.AccountName == "" {
+			return nil, ErrPayoutDetailsMissing
+		}
+		payout.Channel = payments.Default().Name()
+		payout.Destination = fmt.Sprintf("%s %s", admin.BankCode, mask(admin.AccountNumber))
+	}
+
+	available, err := AvailableForPayout(creatorID, currency)
+	if err != nil {
+		return nil, err
+	}
+	if amount > available {
+		return nil, ErrInsufficientBalance
+	}
+
+	if err := database.DB.Create(&payout).Error; err != nil {
+		return nil, fmt.Errorf("failed to save payout: %w", err)
+	}
+	log.Printf("Payout %s of %d requested by creator %d", payout.Reference, amount, creatorID)
+	return &payout, nil
+}
+
+// ApprovePayout sends a requested payout through the provider's transfer API.
+// Most transfers settle later and are completed by CompletePayout.
+func ApprovePayout(payoutID, reviewerID uint) (*models.Payout, error) {
+	var payout models.Payout
+	if err := database.DB.First(&payout, payoutID).Error; err != nil {
+		return nil, ErrPayoutNotFound
+	}
+	unlock := referenceLocks.Lock(payout.Reference)
+	defer unlock()
+
+	// Claim the payout so a second approval cannot send the money twice
+	now := time.Now()
+	claim := database.DB.Model(&models.Payout{}).
+		Where("id = ? AND status = ?", payout.ID, "requested").
+		Updates(map[string]interface{}{"status": "processing", "reviewed_by": reviewerID, "reviewed_at": now, "updated_at": now})
+	if claim.Error != nil {
+		return nil, claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		return nil, ErrPayoutState
+	}
+	payout.Status = "processing"
+	payout.ReviewedBy = &reviewerID
+	payout.ReviewedAt = &now
+
+	var admin models.Admin
+	if err := database.DB.Where("person_id = ?", payout.CreatorID).First(&admin).Error; err != nil {
+		return &payout, failPayout(&payout, "creator has no payout details")
+	}
+	provider, err := payments.Get(payout.Channel)
+	if err != nil {
+		return &payout, failPayout(&payout, err.Error())
+	}
+	transferer, ok := provider.(payments.Transferer)
+	if !ok {
+		return &payout, failPayout(&payout, payout.Channel+" cannot send transfers")
+	}
+
+	request := payments.TransferRequest{
+		Reference: payout.Reference,
+		Amount:    payout.Amount,
+		Currency:  payout.Currency,
+		Reason:    "Creator payout " + payout.Reference,
+		Name:      admin.AccountName,
+	}
+	if payout.Channel == "M-Pesa" {
+		request.Phone = admin.PayoutPhone
+	} else {
+		request.BankCode = admin.BankCode
+		request.AccountNumber = admin.AccountNumber
+	}
+
+	result, err := transferer.Transfer(request)
+	if errors.Is(err, payments.ErrTransferRejected) {
+		log.Printf("Transfer for payout %s failed: %v", payout.Reference, err)
+		return &payout, failPayout(&payout, err.Error())
+	}
+	if err != nil {
+		// The provider may have taken the transfer before the answer was lost, so the
+		// amount stays held until CompletePayout hears how it went
+		log.Printf("Transfer for payout %s has an unknown outcome, leaving it processing: %v", payout.Reference, err)
+		return &payout, fmt.Errorf("%w: %v", ErrTransferUnconfirmed, err)
+	}
+	payout.ProviderRef = result.ID
+	payout.UpdatedAt = time.Now()
+	if err := database.DB.Save(&payout).Error; err != nil {
+		return nil, err
+	}
+
+	switch result.Status {
+	case "success":
+		return &payout, completePayout(&payout, true, "")
+	case "failed":
+		return &payout, failPayout(&payout, result.Message)
+	}
+	log.Printf("Payout %s sent to %s, awaiting confirmation", payout.Reference, payout.Channel)
+	return &payout, nil
+}
+
+// RejectPayout declines a requested payout and releases the amount back to the balance
+func RejectPayout(payoutID, reviewerID uint, reason string) (*models.Payout, error) {
+	var payout models.Payout
+	if err := database.DB.First(&payout, payoutID).Error; err != nil {
+		return nil, ErrPayoutNotFound
+	}
+	unlock := referenceLocks.Lock(payout.Reference)
+	defer unlock()
+
+	now := time.Now()
+	result := database.DB.Model(&models.Payout{}).
+		Where("id = ? AND status = ?", payout.ID, "requested").
+		Updates(map[string]interface{}{"status": "rejected", "failure_reason": reason, "reviewed_by": reviewerID, "reviewed_at": now, "updated_at": now})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrPayoutState
+	}
+	database.DB.First(&payout, payout.ID)
+	log.Printf("Payout %s rejected by %d: %s", payout.Reference, reviewerID, reason)
+	return &payout, nil
+}
+
+// CompletePayout records the provider's final word on a transfer. ref is either our
+// reference or the provider's. Repeated notifications change nothing.
+func CompletePayout(ref string, success bool, reason string) error {
+	var payout models.Payout
+	if err := database.DB.Where("reference = ? OR (provider_ref = ? AND provider_ref <> '')", ref, ref).
+		First(&payout).Error; err != nil {
+		return ErrPayoutNotFound
+	}
+	unlock := referenceLocks.Lock(payout.Reference)
+	defer unlock()
+	return completePayout(&payout, success, reason)
+}
+
+func completePayout(payout *models.Payout, success bool, reason string) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(payout, payout.ID).Error; err != nil {
+			return ErrPayoutNotFound
+		}
+		now := time.Now()
+		switch {
+		case success && payout.Status == "paid", !success && payout.Status == "failed":
+			return nil
+		case !success && payout.Status == "paid":
+			// The provider reversed a transfer it had confirmed, so the creator is owed again
+			payout.Status = "failed"
+			payout.FailureReason = reason
+			payout.UpdatedAt = now
+			if err := tx.Save(payout).Error; err != nil {
+				return err
+			}
+			log.Printf("Payout %s reversed: %s", payout.Reference, reason)
+			return ledger.PostPayoutReversal(tx, payout.Reference, payout.CreatorID, payout.Channel, payout.Currency, payout.Amount,
+				fmt.Sprintf("reversal of payout %s: %s", payout.Reference, reason))
+		case payout.Status != "processing":
+			return ErrPayoutState
+		case !success:
+			payout.Status = "failed"
+			payout.FailureReason = reason
+			payout.UpdatedAt = now
+			log.Printf("Payout %s failed: %s", payout.Reference, reason)
+			return tx.Save(payout).Error
+		}
+
+		payout.Status = "paid"
+		payout.PaidAt = &now
+		payout.UpdatedAt = now
+		if err := tx.Save(payout).Error; err != nil {
+			return err
+		}
+		log.Printf("Payout %s of %d paid to creator %d", payout.Reference, payout.Amount, payout.CreatorID)
+		return ledger.PostPayout(tx, payout.Reference, payout.CreatorID, payout.Channel, payout.Currency, payout.Amount,
+			fmt.Sprintf("payout %s to %s", payout.Reference, payout.Destination))
+	})
+}
+
+// failPayout marks a transfer that did not go through; the amount is available again
+func failPayout(payout *models.Payout, reason string) error {
+	return completePayout(payout, false, reason)
+}
+
+// mask hides all but the last four characters of an account number or phone
+func mask(s string) string {
+	if len(s) <= 4 {
+		return s
+	}
+	return fmt.Sprintf("****%s", s[len(s)-4:])
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"Api/database"
+	"Api/ledger"
+	"Api/models"
+	"Api/payments"
+)
+
+// Reconcile compares the provider's transactions between from and to with ours.
+// Payments stuck in pending are fulfilled or failed according to the provider, and
+// anything that disagrees in status, amount or currency is flagged in the report.
+func Reconcile(provider payments.PaymentProvider, from, to time.Time) (*models.ReconciliationReport, error) {
+	lister, ok := provider.(payments.TransactionLister)
+	if !ok {
+		return nil, fmt.Errorf("%s cannot list transactions", provider.Name())
+	}
+
+	report := &models.ReconciliationReport{
+		Channel:   provider.Name(),
+		From:      from,
+		To:        to,
+		Status:    "running",
+		CreatedAt: time.Now(),
+	}
+	if err := database.DB.Create(report).Error; err != nil {
+		return nil, err
+	}
+
+	remote, err := lister.ListTransactions(from, to)
+	if err != nil {
+		return finishReport(report, err)
+	}
+
+	// Local rows for every remote reference, plus our own rows from the same window
+	refs := make([]string, 0, len(remote))
+	for _, r := range remote {
+		refs = append(refs, r.Reference)
+	}
+	var local []models.Transaction
+	q := database.DB.Where("payment_channel = ? AND created_at >= ? AND created_at < ?", provider.Name(), from, to)
+	if len(refs) > 0 {
+		q = q.Or("reference IN ?", refs)
+	}
+	if err := q.Find(&local).Error; err != nil {
+		return finishReport(report, err)
+	}
+	byRef := make(map[string]*models.Transaction, len(local))
+	for i := range local {
+		byRef[local[i].Reference] = &local[i]
+	}
+
+	seen := make(map[string]bool, len(remote))
+	for i := range remote {
+		r := &remote[i]
+		seen[r.Reference] = true
+		item := reconcileOne(byRef[r.Reference], r)
+		addItem(report, item)
+	}
+
+	// Rows the provider has never heard of
+	for _, t := range local {
+		if seen[t.Reference] {
+			continue
+		}
+		item := &models.ReconciliationItem{
+			Reference:     t.Reference,
+			TransactionID: &t.ID,
+			Issue:         "missing_at_provider",
+			Action:        "flagged",
+			LocalStatus:   t.Status,
+			LocalAmount:   payments.ToMinor(t.Amount),
+			LocalCurrency: ledger.CurrencyOf(&t),
+		}
+		if t.Status == "pending" {
+			if t.CreatedAt.After(time.Now().Add(-time.Hour)) {
+				// The buyer may still be on the checkout page
+				continue
+			}
+			if err := FailOrder(t.Reference, "not found at provider during reconciliation"); err == nil {
+				item.Action = "failed"
+			} else {
+				item.Detail = err.Error()
+			}
+		} else if t.Status != "success" {
+			continue
+		}
+		addItem(report, item)
+	}
+
+	return finishReport(report, nil)
+}
+
+// reconcileOne decides what to do about one provider transaction; nil means it matched
+func reconcileOne(t *models.Transaction, r *payments.VerifyResult) *models.ReconciliationItem {
+	item := &models.ReconciliationItem{
+		Reference:        r.Reference,
+		ProviderStatus:   r.Status,
+		ProviderAmount:   r.Amount,
+		ProviderCurrency: r.Currency,
+		Action:           "flagged",
+	}
+	if t == nil {
+		item.Issue = "missing_local"
+		return item
+	}
+	item.TransactionID = &t.ID
+	item.LocalStatus = t.Status
+	item.LocalAmount = payments.ToMinor(t.Amount)
+	item.LocalCurrency = ledger.CurrencyOf(t)
+
+	if r.Currency != "" && r.Currency != item.LocalCurrency {
+		item.Issue = "currency_mismatch"
+		return item
+	}
+
+	switch r.Status {
+	case "success":
+		if r.Amount < item.LocalAmount {
+			item.Issue = "amount_mismatch"
+			return item
+		}
+		if t.Status == "pending" || t.Status == "failed" {
+			item.Issue = "stuck_" + t.Status
+			if _, err := FulfilOrder(t.Reference, payments.FromMinor(r.Amount), r.Currency); err != nil {
+				item.Detail = err.Error()
+				return item
+			}
+			if err := RecordProviderFee(t.Reference, r.Fees); err != nil {
+				log.Printf("Failed to record fee on %s: %v", t.Reference, err)
+			}
+			item.Action = "fulfilled"
+			return item
+		}
+		if r.Amount != item.LocalAmount {
+			item.Issue = "amount_mismatch"
+			return item
+		}
+		return nil
+	case "failed", "abandoned", "reversed":
+		switch t.Status {
+		case "pending":
+			item.Issue = "stuck_pending"
+			if err := FailOrder(t.Reference, "provider reports "+r.Status); err != nil {
+				item.Detail = err.Error()
+				return item
+			}
+			item.Action = "failed"
+			return item
+		case "success":
+			item.Issue = "status_mismatch"
+			item.Detail = "access granted but provider reports " + r.Status
+			return item
+		}
+		return nil
+	default:
+		// Still in progress at the provider, the next run will look again
+		return nil
+	}
+}
+
+func addItem(report *models.ReconciliationReport, item *models.ReconciliationItem) {
+	if item == nil {
+		report.Matched++
+		return
+	}
+	item.ReportID = report.ID
+	if err := database.DB.Create(item).Error; err != nil {
+		log.Printf("Failed to save reconciliation item %s: %v", item.Reference, err)
+	}
+	switch item.Action {
+	case "fulfilled":
+		report.Fulfilled++
+	case "failed":
+		report.Failed++
+	default:
+		report.Mismatches++
+	}
+}
+
+func finishReport(report *models.ReconciliationReport, runErr error) (*models.ReconciliationReport, error) {
+	now := time.Now()
+	report.FinishedAt = &now
+	report.Status = "completed"
+	if runErr != nil {
+		report.Status = "failed"
+		report.Error = runErr.Error()
+	}
+	if err := database.DB.Save(report).Error; err != nil {
+		return nil, err
+	}
+	log.Printf("Reconciliation %d of %s (%s to %s): %s, matched=%d fulfilled=%d failed=%d mismatches=%d",
+		report.ID, report.Channel, report.From.Format(time.RFC3339), report.To.Format(time.RFC3339),
+		report.Status, report.Matched, report.Fulfilled, report.Failed, report.Mismatches)
+	return report, runErr
+}
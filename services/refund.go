@@ -0,0 +1,302 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"Api/database"
+	"Api/ledger"
+	"Api/models"
+	"Api/payments"
+)
+
+var (
+	ErrNotRefundable    = errors.New("only successful or disputed transactions can be refunded")
+	ErrRefundInProgress = errors.New("a refund for this transaction is already in progress")
+)
+
+// RefundOrder asks the payment provider to return a payment and then reverses the
+// access it granted. manual skips the provider call for money returned outside the
+// platform, e.g. an M-Pesa reversal done on the Safaricom portal.
+func RefundOrder(transactionID uint, reason string, manual bool) (*models.Transaction, error) {
+	var transaction models.Transaction
+	if err := database.DB.First(&transaction, transactionID).Error; err != nil {
+		return nil, ErrTransactionNotFound
+	}
+
+	unlock := referenceLocks.Lock(transaction.Reference)
+	defer unlock()
+
+	// The lock only covers this process; read the status again and claim the
+	// transaction so a second refund, here or on another instance, cannot send
+	// the money back twice
+	if err := database.DB.First(&transaction, transactionID).Error; err != nil {
+		return nil, ErrTransactionNotFound
+	}
+	if transaction.Status == "refunded" {
+		return &transaction, nil
+	}
+	if transaction.Status != "success" && transaction.Status != "disputed" {
+		if transaction.Status == "refunding" {
+			return nil, ErrRefundInProgress
+		}
+		return nil, ErrNotRefundable
+	}
+	if transaction.PaymentType == "topup" {
+		return nil, ErrNotRefundable
+	}
+	previousStatus := transaction.Status
+	claim := database.DB.Model(&models.Transaction{}).
+		Where("id = ? AND status = ?", transaction.ID, previousStatus).
+		Updates(map[string]interface{}{"status": "refunding", "updated_at": time.Now()})
+	if claim.Error != nil {
+		return nil, claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		return nil, ErrRefundInProgress
+	}
+
+	refundRef := "manual"
+	if transaction.PaymentChannel == payments.ChannelWallet {
+		refundRef = "wallet"
+	} else if !manual {
+		provider, err := payments.Get(transaction.PaymentChannel)
+		if err != nil {
+			releaseRefundClaim(&transaction, previousStatus)
+			return nil, err
+		}
+		result, err := provider.Refund(transaction.Reference, 0)
+		if err != nil {
+			releaseRefundClaim(&transaction, previousStatus)
+			return nil, fmt.Errorf("provider refund failed: %w", err)
+		}
+		refundRef = result.ID
+	}
+
+	// If the reversal fails now the money is already on its way back, so the
+	// transaction stays "refunding" for the provider's refund webhook to finish
+	return reverseOrder(transaction.Reference, refundRef, reason)
+}
+
+// releaseRefundClaim puts back the status a refund claimed when the provider
+// did not take the refund
+func releaseRefundClaim(transaction *models.Transaction, previousStatus string) {
+	err := database.DB.Model(&models.Transaction{}).
+		Where("id = ? AND status = ?", transaction.ID, "refunding").
+		Updates(map[string]interface{}{"status": previousStatus, "updated_at": time.Now()}).Error
+	if err != nil {
+		log.Printf("Failed to release refund claim on %s: %v", transaction.Reference, err)
+	}
+}
+
+// ReverseOrder undoes a fulfilled transaction once its money has gone back to the buyer.
+// Calling it again for an already refunded transaction changes nothing.
+func ReverseOrder(reference, refundRef, reason string) (*models.Transaction, error) {
+	unlock := referenceLocks.Lock(reference)
+	defer unlock()
+	return reverseOrder(reference, refundRef, reason)
+}
+
+func reverseOrder(reference, refundRef, reason string) (*models.Transaction, error) {
+	var transaction models.Transaction
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("reference = ?", reference).
+			First(&transaction).Error; err != nil {
+			return ErrTransactionNotFound
+		}
+		if transaction.Status == "refunded" {
+			return nil
+		}
+		if transaction.Status != "success" && transaction.Status != "disputed" && transaction.Status != "refunding" {
+			return ErrNotRefundable
+		}
+		if transaction.PaymentType == "topup" {
+			return ErrNotRefundable
+		}
+
+		var bot models.Bot
+		if err := tx.First(&bot, transaction.BotID).Error; err != nil {
+			return ErrBotNotFound
+		}
+
+		sellerID := bot.OwnerID
+		switch transaction.PaymentType {
+		case "purchase":
+			sellerID = transaction.PreviousOwnerID
+			if err := revokePurchase(tx, &transaction, &bot); err != nil {
+				return err
+			}
+		case "rent":
+			if err := shortenRental(tx, &transaction); err != nil {
+				return err
+			}
+			if err := stopRenewals(tx, &transaction); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		sale := models.Sale{
+			BotID:     transaction.BotID,
+			SellerID:  sellerID,
+			BuyerID:   transaction.UserID,
+			Amount:    -transaction.Amount,
+			SaleType:  "refund",
+			SaleDate:  now,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if err := tx.Create(&sale).Error; err != nil {
+			return fmt.Errorf("failed to record refund sale: %w", err)
+		}
+
+		transaction.Status = "refunded"
+		if transaction.EscrowStatus == "held" {
+			// The creator was never paid, the refund comes out of escrow
+			transaction.EscrowStatus = "refunded"
+		}
+		transaction.RefundedAmount = transaction.Amount
+		transaction.RefundReference = refundRef
+		transaction.RefundReason = reason
+		transaction.RefundedAt = &now
+		transaction.UpdatedAt = now
+		if err := tx.Save(&transaction).Error; err != nil {
+			return fmt.Errorf("failed to update transaction: %w", err)
+		}
+		if err := ledger.PostRefund(tx, &transaction); err != nil {
+			return fmt.Errorf("failed to post refund to ledger: %w", err)
+		}
+		if transaction.PaymentChannel == payments.ChannelWallet {
+			return refundToWallet(tx, &transaction)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Transaction %s refunded (%s): %s", reference, refundRef, reason)
+	return &transaction, nil
+}
+
+// revokePurchase hands the bot back to its previous owner and removes the buyer's access
+func revokePurchase(tx *gorm.DB, transaction *models.Transaction, bot *models.Bot) error {
+	if bot.OwnerID == transaction.UserID && transaction.PreviousOwnerID != 0 {
+		bot.OwnerID = transaction.PreviousOwnerID
+		if err := tx.Save(bot).Error; err != nil {
+			return fmt.Errorf("failed to restore bot ownership: %w", err)
+		}
+	}
+
+	if err := tx.Where("user_id = ? AND bot_id = ?", transaction.UserID, transaction.BotID).Delete(&models.UserBot{}).Error; err != nil {
+		return fmt.Errorf("failed to revoke bot access: %w", err)
+	}
+	return nil
+}
+
+// shortenRental removes the rental period this transaction paid for
+func shortenRental(tx *gorm.DB, transaction *models.Transaction) error {
+	var userBot models.UserBot
+	err := tx.Where("user_id = ? AND bot_id = ? AND access_type = ?", transaction.UserID, transaction.BotID, "rent").First(&userBot).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if userBot.ExpiryDate != nil {
+		expiry := userBot.ExpiryDate.Add(-rentalPeriod(transaction))
+		userBot.ExpiryDate = &expiry
+	}
+	if userBot.ExpiryDate == nil || !userBot.ExpiryDate.After(now) {
+		userBot.IsActive = false
+		userBot.ExpiryDate = &now
+	}
+	userBot.UpdatedAt = now
+	if err := tx.Save(&userBot).Error; err != nil {
+		return fmt.Errorf("failed to shorten rental: %w", err)
+	}
+	return nil
+}
+
+// stopRenewals cancels the subscription of a refunded rental so the card is not charged again
+func stopRenewals(tx *gorm.DB, transaction *models.Transaction) error {
+	now := time.Now()
+	err := tx.Model(&models.Subscription{}).
+		Where("user_id = ? AND bot_id = ? AND status IN ?", transaction.UserID, transaction.BotID, []string{"active", "past_due"}).
+		Updates(map[string]interface{}{"status": "cancelled", "next_charge_at": nil, "cancelled_at": now, "updated_at": now}).Error
+	if err != nil {
+		return fmt.Errorf("failed to cancel subscription: %w", err)
+	}
+	return nil
+}
+
+// OpenDispute suspends the buyer's access while a chargeback is investigated
+func OpenDispute(reference string) error {
+	unlock := referenceLocks.Lock(reference)
+	defer unlock()
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var transaction models.Transaction
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("reference = ?", reference).
+			First(&transaction).Error; err != nil {
+			return ErrTransactionNotFound
+		}
+		if transaction.Status != "success" {
+			return nil
+		}
+
+		transaction.Status = "disputed"
+		transaction.UpdatedAt = time.Now()
+		if err := tx.Save(&transaction).Error; err != nil {
+			return err
+		}
+		log.Printf("Transaction %s disputed, suspending access", reference)
+		return tx.Model(&models.UserBot{}).
+			Where("user_id = ? AND bot_id = ?", transaction.UserID, transaction.BotID).
+			Update("is_active", false).Error
+	})
+}
+
+// ResolveDispute closes a chargeback. When the buyer won, the order is reversed;
+// otherwise the transaction and the buyer's access are restored.
+func ResolveDispute(reference string, buyerWon bool) error {
+	if buyerWon {
+		_, err := ReverseOrder(reference, "chargeback", "chargeback lost")
+		return err
+	}
+
+	unlock := referenceLocks.Lock(reference)
+	defer unlock()
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var transaction models.Transaction
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("reference = ?", reference).
+			First(&transaction).Error; err != nil {
+			return ErrTransactionNotFound
+		}
+		if transaction.Status != "disputed" {
+			return nil
+		}
+
+		transaction.Status = "success"
+		transaction.UpdatedAt = time.Now()
+		if err := tx.Save(&transaction).Error; err != nil {
+			return err
+		}
+		log.Printf("Dispute on %s resolved in merchant's favour, restoring access", reference)
+		return tx.Model(&models.UserBot{}).
+			Where("user_id = ? AND bot_id = ? AND (expiry_date IS NULL OR expiry_date > ?)", transaction.UserID, transaction.BotID, time.Now()).
+			Update("is_active", true).Error
+	})
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"Api/database"
+	"Api/models"
+)
+
+// DefaultRentalPeriod applies to rentals paid before plans existed
+const DefaultRentalPeriod = 30 * 24 * time.Hour
+
+// StandardPlanDays fills in the duration when an owner names a plan without one
+var StandardPlanDays = map[string]int{
+	"daily":     1,
+	"weekly":    7,
+	"monthly":   30,
+	"quarterly": 90,
+}
+
+var (
+	ErrPlanNotFound = errors.New("rental plan not found")
+	ErrPlanRequired = errors.New("choose a rental plan for this bot")
+)
+
+// RentalPlanInput is what an owner sends to create or change a plan
+type RentalPlanInput struct {
+	Name         string  `json:"name" binding:"required"`
+	DurationDays int     `json:"duration_days"`
+	Price        float64 `json:"price" binding:"required"`
+}
+
+// Validate normalises the plan name and fills in standard durations
+func (in *RentalPlanInput) Validate() error {
+	in.Name = strings.ToLower(strings.TrimSpace(in.Name))
+	if in.Name == "" {
+		return fmt.Errorf("plan name is required")
+	}
+	if in.DurationDays == 0 {
+		in.DurationDays = StandardPlanDays[in.Name]
+	}
+	if in.DurationDays <= 0 {
+		return fmt.Errorf("duration_days is required for plan %q", in.Name)
+	}
+	if in.Price <= 0 {
+		return fmt.Errorf("price must be greater than zero")
+	}
+	return nil
+}
+
+// RentalPlanFor returns the active plan a renter picked. planID 0 is accepted
+// when the bot has exactly one plan, so older clients keep working.
+func RentalPlanFor(botID, planID uint) (*models.RentalPlan, error) {
+	if planID != 0 {
+		var plan models.RentalPlan
+		if err := database.DB.Where("id = ? AND bot_id = ? AND is_active = ?", planID, botID, true).First(&plan).Error; err != nil {
+			return nil, ErrPlanNotFound
+		}
+		return &plan, nil
+	}
+
+	var plans []models.RentalPlan
+	if err := database.DB.Where("bot_id = ? AND is_active = ?", botID, true).Limit(2).Find(&plans).Error; err != nil {
+		return nil, err
+	}
+	switch len(plans) {
+	case 0:
+		return nil, ErrPlanNotFound
+	case 1:
+		return &plans[0], nil
+	default:
+		return nil, ErrPlanRequired
+	}
+}
+
+// PlanPeriod converts a plan duration to a time.Duration
+func PlanPeriod(days int) time.Duration {
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// rentalPeriod is how long a rent transaction grants access for
+func rentalPeriod(transaction *models.Transaction) time.Duration {
+	if transaction.RentalDays > 0 {
+		return PlanPeriod(transaction.RentalDays)
+	}
+	return DefaultRentalPeriod
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"Api/database"
+	"Api/models"
+	"Api/utils"
+)
+
+// RefreshTokenTTL is how long a session may sit unused before it has to sign in again
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used, the session has been revoked")
+	ErrSessionNotFound     = errors.New("session not found")
+)
+
+// AuthTokens is what a client receives when it signs in or refreshes
+type AuthTokens struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // seconds until the access token expires
+	SessionID    uint   `json:"session_id"`
+}
+
+// hashToken is how opaque tokens (refresh tokens, login challenges) are stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// StartSession signs a user in on a new device. Accounts that are not active
+// get an *AccountStatusError.
+func StartSession(user *models.Person, userAgent, ip string) (*AuthTokens, error) {
+	if err := CheckAccountActive(user); err != nil {
+		return nil, err
+	}
+	refresh, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	now := time.Now()
+	session := models.Session{
+		UserID:     user.ID,
+		TokenHash:  hashToken(refresh),
+		UserAgent:  userAgent,
+		IP:         ip,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(RefreshTokenTTL),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := database.DB.Create(&session).Error; err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	return issueTokens(user, &session, refresh)
+}
+
+func issueTokens(user *models.Person, session *models.Session, refresh string) (*AuthTokens, error) {
+	access, err := utils.GenerateToken(user.ID, user.Email, string(models.NormalizeRole(string(user.Role))), session.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	return &AuthTokens{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(utils.AccessTokenTTL / time.Second),
+		SessionID:    session.ID,
+	}, nil
+}
+
+// RefreshSession trades a refresh token for a new access token and a new refresh
+// token. Presenting a token that was already rotated out means it leaked, so the
+// whole session is revoked.
+func RefreshSession(refreshToken, userAgent, ip string) (*AuthTokens, error) {
+	hash := hashToken(refreshToken)
+	var tokens *AuthTokens
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var session models.Session
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hash).
+			First(&session).Error; err != nil {
+			return ErrInvalidRefreshToken
+		}
+		now := time.Now()
+		if session.RevokedAt != nil || now.After(session.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		var user models.Person
+		if err := tx.First(&user, session.UserID).Error; err != nil {
+			return ErrInvalidRefreshToken
+		}
+		if err := CheckAccountActive(&user); err != nil {
+			return err
+		}
+
+		refresh, err := utils.GenerateRefreshToken()
+		if err != nil {
+			return fmt.Errorf("failed to generate refresh token: %w", err)
+		}
+		session.PreviousTokenHash = session.TokenHash
+		session.TokenHash = hashToken(refresh)
+		session.UserAgent = userAgent
+		session.IP = ip
+		session.LastUsedAt = now
+		session.ExpiresAt = now.Add(RefreshTokenTTL)
+		session.UpdatedAt = now
+		if err := tx.Save(&session).Error; err != nil {
+			return fmt.Errorf("failed to rotate refresh token: %w", err)
+		}
+		tokens, err = issueTokens(&user, &session, refresh)
+		return err
+	})
+	if errors.Is(err, ErrInvalidRefreshToken) {
+		var session models.Session
+		if database.DB.Where("previous_token_hash = ? AND revoked_at IS NULL", hash).First(&session).Error == nil {
+			revokeSessions(database.DB.Where("id = ?", session.ID), "refresh token reused")
+			log.Printf("Refresh token reuse on session %d of user %d, session revoked", session.ID, session.UserID)
+			return nil, ErrRefreshTokenReused
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// SessionActive reports whether an access token's session is still signed in
+func SessionActive(sessionID, userID uint) bool {
+	var count int64
+	database.DB.Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > ?", sessionID, userID, time.Now()).
+		Count(&count)
+	return count > 0
+}
+
+// ListSessions returns a user's signed-in devices, most recently used first
+func ListSessions(userID uint) ([]models.Session, error) {
+	var sessions []models.Session
+	err := database.DB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// RevokeSession signs one of the user's devices out
+func RevokeSession(userID, sessionID uint, reason string) error {
+	n, err := revokeSessions(database.DB.Where("id = ? AND user_id = ?", sessionID, userID), reason)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeOtherSessions signs the user out everywhere except keepID; pass 0 to sign out everywhere
+func RevokeOtherSessions(userID, keepID uint, reason string) (int64, error) {
+	return revokeSessions(database.DB.Where("user_id = ? AND id <> ?", userID, keepID), reason)
+}
+
+func revokeSessions(scope *gorm.DB, reason string) (int64, error) {
+	now := time.Now()
+	res := scope.Model(&models.Session{}).
+		Where("revoked_at IS NULL").
+		Updates(map[string]interface{}{"revoked_at": now, "revoked_reason": reason, "updated_at": now})
+	return res.RowsAffected, res.Error
+}
+
+// EndSession signs out the device holding refreshToken
+func EndSession(refreshToken string) error {
+	n, err := revokeSessions(database.DB.Where("token_hash = ?", hashToken(refreshToken)), "signed out")
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvalidRefreshToken
+	}
+	return nil
+}
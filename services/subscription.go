@@ -0,0 +1,336 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	"Api/database"
+	"Api/models"
+	"Api/payments"
+)
+
+const (
+	// RenewalLead is how long before the rental expires the saved card is charged;
+	// short plans are charged a quarter of their period ahead instead
+	RenewalLead = 24 * time.Hour
+	// RenewalGracePeriod keeps a past-due renter's bot running while charges are retried,
+	// capped at one plan period
+	RenewalGracePeriod = 3 * 24 * time.Hour
+	// pendingChargeRecheck is how soon an unsettled renewal charge is looked at again
+	pendingChargeRecheck = time.Hour
+)
+
+// renewalRetryBackoff is the wait before each retry of a failed renewal charge
+var renewalRetryBackoff = []time.Duration{6 * time.Hour, 12 * time.Hour, 24 * time.Hour}
+
+var (
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	ErrSubscriptionClosed   = errors.New("subscription is already cancelled or expired")
+)
+
+// StartSubscription saves the card used for a rental so it can be charged again before
+// the rental runs out; rentals paid from the wallet renew from the wallet instead.
+// It is called after every successful rental, including renewals, and leaves
+// purchases and rentals without a reusable card alone.
+func StartSubscription(fulfilment *FulfilmentResult, auth *payments.Authorization, email string) error {
+	if fulfilment == nil || fulfilment.PaymentType != "rent" || fulfilment.ExpiryDate == nil {
+		return nil
+	}
+
+	var transaction models.Transaction
+	if err := database.DB.First(&transaction, fulfilment.TransactionID).Error; err != nil {
+		return ErrTransactionNotFound
+	}
+	if !transaction.AutoRenew {
+		return nil
+	}
+
+	var sub models.Subscription
+	err := database.DB.Where("user_id = ? AND bot_id = ?", fulfilment.UserID, fulfilment.BotID).First(&sub).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if auth != nil && auth.Reusable {
+		sub.AuthorizationCode = auth.Code
+		sub.CardLast4 = auth.Last4
+		sub.CardBrand = auth.Brand
+	}
+	if sub.AuthorizationCode == "" && transaction.PaymentChannel != payments.ChannelWallet {
+		log.Printf("No reusable card on %s, rental will not renew automatically", fulfilment.Reference)
+		return nil
+	}
+
+	now := time.Now()
+	nextCharge := fulfilment.ExpiryDate.Add(-renewalLead(rentalPeriod(&transaction)))
+	sub.UserID = fulfilment.UserID
+	sub.BotID = fulfilment.BotID
+	if transaction.RentalPlanID != nil {
+		sub.RentalPlanID = *transaction.RentalPlanID
+	}
+	sub.PaymentChannel = transaction.PaymentChannel
+	if email != "" {
+		sub.Email = email
+	}
+	sub.Amount = transaction.Amount
+	sub.Currency = transaction.Currency
+	sub.Status = "active"
+	sub.CurrentPeriodEnd = *fulfilment.ExpiryDate
+	sub.NextChargeAt = &nextCharge
+	sub.FailedAttempts = 0
+	sub.GraceUntil = nil
+	sub.PendingReference = ""
+	sub.LastTransactionID = transaction.ID
+	sub.CancelledAt = nil
+	sub.UpdatedAt = now
+	if err := database.DB.Save(&sub).Error; err != nil {
+		return fmt.Errorf("failed to save subscription: %w", err)
+	}
+	log.Printf("Subscription %d active until %s, next charge at %s", sub.ID, sub.CurrentPeriodEnd.Format(time.RFC3339), nextCharge.Format(time.RFC3339))
+	return nil
+}
+
+// RenewSubscription charges the saved card for the next rental period.
+// Failed charges are retried on renewalRetryBackoff while the renter keeps access
+// until GraceUntil; after that the subscription expires.
+func RenewSubscription(sub *models.Subscription) error {
+	if sub.PendingReference != "" {
+		return settlePendingRenewal(sub)
+	}
+
+	var bot models.Bot
+	if err := database.DB.First(&bot, sub.BotID).Error; err != nil {
+		return ErrBotNotFound
+	}
+	// Nobody is charged for, or paid by, an account that was suspended, banned or closed
+	if err := CheckSellerActive(&bot); err != nil {
+		log.Printf("Creator of bot %d is not active, cancelling subscription %d", bot.ID, sub.ID)
+		return closeSubscription(sub, "cancelled")
+	}
+	var renter models.Person
+	if err := database.DB.First(&renter, sub.UserID).Error; err != nil || CheckAccountActive(&renter) != nil {
+		log.Printf("Renter %d is not active, cancelling subscription %d", sub.UserID, sub.ID)
+		return closeSubscription(sub, "cancelled")
+	}
+	var plan models.RentalPlan
+	if err := database.DB.Where("id = ? AND bot_id = ?", sub.RentalPlanID, sub.BotID).First(&plan).Error; err != nil || !plan.IsActive {
+		log.Printf("Rental plan %d of bot %d is no longer offered, ending subscription %d", sub.RentalPlanID, sub.BotID, sub.ID)
+		return closeSubscription(sub, "expired")
+	}
+
+	var admin models.Admin
+	if err := database.DB.Where("person_id = ?", bot.OwnerID).First(&admin).Error; err != nil {
+		return fmt.Errorf("admin not found for bot %d: %w", bot.ID, err)
+	}
+	companyPercent, _ := PlatformCommission("rent")
+
+	var charger payments.RecurringCharger
+	if sub.PaymentChannel != payments.ChannelWallet {
+		provider, err := payments.Get(sub.PaymentChannel)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		if charger, ok = provider.(payments.RecurringCharger); !ok {
+			log.Printf("%s cannot charge saved cards, cancelling subscription %d", sub.PaymentChannel, sub.ID)
+			return closeSubscription(sub, "cancelled")
+		}
+	}
+
+	// Renewals are charged in the currency the renter first paid in, at today's rate
+	currency := sub.Currency
+	if currency == "" {
+		currency = BotCurrency(&bot)
+	}
+	quote, err := ApplyCoupon("", sub.UserID, &bot, "rent", plan.Price)
+	if err != nil {
+		return err
+	}
+	if err := quote.ConvertTo(currency); err != nil {
+		return err
+	}
+	// Tax follows the renter's current country and tax ID
+	quote.AddTax(&renter)
+	amount := quote.Amount
+	companyShare, adminShare := SplitShares(amount-quote.TaxAmount(), companyPercent)
+	reference := fmt.Sprintf("ALG_%d_%d_R%d", sub.UserID, time.Now().Unix(), sub.ID)
+	transaction := models.Transaction{
+		UserID:         sub.UserID,
+		AdminID:        admin.ID,
+		BotID:          sub.BotID,
+		Amount:         amount,
+		CompanyShare:   companyShare,
+		AdminShare:     adminShare,
+		Status:         "pending",
+		Reference:      reference,
+		PaymentChannel: sub.PaymentChannel,
+		PaymentType:    "rent",
+		Description:    fmt.Sprintf("Automatic renewal of subscription %d (%s)", sub.ID, plan.Name),
+		AutoRenew:      true,
+		RentalPlanID:   &plan.ID,
+		RentalDays:     plan.DurationDays,
+		SplitAtSource:  admin.PaystackSubaccountCode != "" && sub.PaymentChannel != payments.ChannelWallet,
+		CreatedAt:      time.Now(),
+	}
+	quote.ApplyTo(&transaction)
+	if err := database.DB.Create(&transaction).Error; err != nil {
+		return fmt.Errorf("failed to save renewal transaction: %w", err)
+	}
+	if sub.PaymentChannel == payments.ChannelWallet {
+		return renewFromWallet(sub, &transaction)
+	}
+
+	result, err := charger.ChargeAuthorization(payments.ChargeRequest{
+		AuthorizationCode: sub.AuthorizationCode,
+		Email:             sub.Email,
+		Amount:            payments.ToMinor(amount),
+		Currency:          currency,
+		Reference:         reference,
+		Subaccount:        admin.PaystackSubaccountCode,
+		TransactionCharge: payments.ToMinor(companyShare + quote.TaxAmount()),
+	})
+	if err != nil {
+		FailOrder(reference, err.Error())
+		return renewalFailed(sub, err.Error())
+	}
+	return applyRenewalResult(sub, reference, result)
+}
+
+// settlePendingRenewal checks on a renewal charge the provider had not settled yet
+func settlePendingRenewal(sub *models.Subscription) error {
+	provider, err := payments.Get(sub.PaymentChannel)
+	if err != nil {
+		return err
+	}
+	result, err := provider.Verify(sub.PendingReference)
+	if err != nil {
+		return err
+	}
+	return applyRenewalResult(sub, sub.PendingReference, result)
+}
+
+func applyRenewalResult(sub *models.Subscription, reference string, result *payments.VerifyResult) error {
+	switch result.Status {
+	case "success":
+		fulfilment, err := FulfilOrder(reference, payments.FromMinor(result.Amount), result.Currency)
+		if err != nil {
+			return err
+		}
+		if err := RecordProviderFee(reference, result.Fees); err != nil {
+			log.Printf("Failed to record fee on %s: %v", reference, err)
+		}
+		// StartSubscription moves the period forward and clears any failure state
+		return StartSubscription(fulfilment, result.Authorization, sub.Email)
+	case "pending", "ongoing", "processing", "queued", "send_otp":
+		next := time.Now().Add(pendingChargeRecheck)
+		sub.PendingReference = reference
+		sub.NextChargeAt = &next
+		sub.UpdatedAt = time.Now()
+		return database.DB.Save(sub).Error
+	default:
+		FailOrder(reference, result.Message)
+		return renewalFailed(sub, result.Message)
+	}
+}
+
+// renewalFailed records a declined charge and schedules the next retry, if any
+func renewalFailed(sub *models.Subscription, reason string) error {
+	now := time.Now()
+	sub.FailedAttempts++
+	sub.PendingReference = ""
+	sub.Status = "past_due"
+	if sub.GraceUntil == nil {
+		grace := RenewalGracePeriod
+		var plan models.RentalPlan
+		if err := database.DB.First(&plan, sub.RentalPlanID).Error; err == nil && PlanPeriod(plan.DurationDays) < grace {
+			grace = PlanPeriod(plan.DurationDays)
+		}
+		graceUntil := sub.CurrentPeriodEnd.Add(grace)
+		sub.GraceUntil = &graceUntil
+	}
+
+	sub.NextChargeAt = nil
+	if sub.FailedAttempts <= len(renewalRetryBackoff) {
+		next := now.Add(renewalRetryBackoff[sub.FailedAttempts-1])
+		if next.Before(*sub.GraceUntil) {
+			sub.NextChargeAt = &next
+		}
+	}
+	sub.UpdatedAt = now
+	if err := database.DB.Save(sub).Error; err != nil {
+		return err
+	}
+
+	if sub.NextChargeAt != nil {
+		log.Printf("Renewal of subscription %d failed (%s), retrying at %s", sub.ID, reason, sub.NextChargeAt.Format(time.RFC3339))
+	} else {
+		log.Printf("Renewal of subscription %d failed (%s), no retries left before %s", sub.ID, reason, sub.GraceUntil.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// ExpireLapsedSubscriptions closes past-due subscriptions whose grace period is over
+func ExpireLapsedSubscriptions() error {
+	var lapsed []models.Subscription
+	if err := database.DB.Where("status = ? AND grace_until < ?", "past_due", time.Now()).Find(&lapsed).Error; err != nil {
+		return err
+	}
+	for i := range lapsed {
+		if err := closeSubscription(&lapsed[i], "expired"); err != nil {
+			return err
+		}
+		log.Printf("Subscription %d expired after failed renewals", lapsed[i].ID)
+	}
+	return nil
+}
+
+// CancelSubscription stops automatic renewal. The renter keeps access until the
+// end of the period already paid for.
+func CancelSubscription(subscriptionID, userID uint) (*models.Subscription, error) {
+	var sub models.Subscription
+	if err := database.DB.Where("id = ? AND user_id = ?", subscriptionID, userID).First(&sub).Error; err != nil {
+		return nil, ErrSubscriptionNotFound
+	}
+	if sub.Status == "cancelled" || sub.Status == "expired" {
+		return nil, ErrSubscriptionClosed
+	}
+	if err := closeSubscription(&sub, "cancelled"); err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func closeSubscription(sub *models.Subscription, status string) error {
+	now := time.Now()
+	sub.Status = status
+	sub.NextChargeAt = nil
+	if status == "cancelled" {
+		sub.CancelledAt = &now
+	}
+	sub.UpdatedAt = now
+	return database.DB.Save(sub).Error
+}
+
+// renewalLead is how long before the end of a period of the given length to charge
+func renewalLead(period time.Duration) time.Duration {
+	if lead := period / 4; lead < RenewalLead {
+		return lead
+	}
+	return RenewalLead
+}
+
+// InRenewalGrace reports whether a lapsed rental should stay active because its
+// subscription is still being renewed
+func InRenewalGrace(userID, botID uint) bool {
+	now := time.Now()
+	var count int64
+	database.DB.Model(&models.Subscription{}).
+		Where("user_id = ? AND bot_id = ?", userID, botID).
+		Where("(status = ? AND current_period_end > ?) OR (status = ? AND grace_until > ?)",
+			"active", now.Add(-RenewalGracePeriod), "past_due", now).
+		Count(&count)
+	return count > 0
+}
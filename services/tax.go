@@ -0,0 +1,150 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"Api/database"
+	"Api/models"
+	"Api/payments"
+)
+
+// TaxRuleInput is the superadmin form for creating or updating a country's tax rule
+type TaxRuleInput struct {
+	Country       string  `json:"country" binding:"required"`
+	Name          string  `json:"name"`
+	Rate          float64 `json:"rate"`
+	Inclusive     bool    `json:"inclusive"`
+	ReverseCharge bool    `json:"reverse_charge"`
+	IsActive      *bool   `json:"is_active"`
+}
+
+// Validate normalises the input and checks the rate
+func (in *TaxRuleInput) Validate() error {
+	in.Country = strings.TrimSpace(in.Country)
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Country == "" {
+		return fmt.Errorf("country is required")
+	}
+	if in.Rate < 0 || in.Rate >= 100 {
+		return fmt.Errorf("rate must be between 0 and 100")
+	}
+	if in.Name == "" {
+		in.Name = "VAT"
+	}
+	return nil
+}
+
+// Apply copies validated input onto rule
+func (in *TaxRuleInput) Apply(rule *models.TaxRule) {
+	rule.Country = in.Country
+	rule.Name = in.Name
+	rule.Rate = in.Rate
+	rule.Inclusive = in.Inclusive
+	rule.ReverseCharge = in.ReverseCharge
+	rule.IsActive = in.IsActive == nil || *in.IsActive
+}
+
+// TaxQuote is the tax on one checkout, in the currency the buyer pays in
+type TaxQuote struct {
+	Country       string  `json:"country,omitempty"`
+	Name          string  `json:"name,omitempty"`
+	Rate          float64 `json:"rate"` // percent
+	Inclusive     bool    `json:"inclusive"`
+	ReverseCharge bool    `json:"reverse_charge"`
+	BuyerTaxID    string  `json:"buyer_tax_id,omitempty"`
+	Net           float64 `json:"net"`
+	Tax           float64 `json:"tax"`
+	Total         float64 `json:"total"`
+}
+
+// TaxRuleFor returns the active rule for a buyer's country, or nil when sales
+// there are not taxed
+func TaxRuleFor(country string) *models.TaxRule {
+	country = strings.TrimSpace(country)
+	if country == "" {
+		return nil
+	}
+	var rule models.TaxRule
+	if err := database.DB.Where("LOWER(country) = LOWER(?) AND is_active = ?", country, true).First(&rule).Error; err != nil {
+		return nil
+	}
+	return &rule
+}
+
+// CalculateTax works out the tax a buyer owes on price. Exclusive rates are added
+// on top, inclusive ones are taken out of the price, and reverse-charge buyers
+// pay the net price with no tax.
+func CalculateTax(buyer *models.Person, price float64) *TaxQuote {
+	quote := &TaxQuote{Net: price, Total: price}
+	rule := TaxRuleFor(buyer.Country)
+	if rule == nil || rule.Rate <= 0 {
+		return quote
+	}
+	quote.Country = rule.Country
+	quote.Name = rule.Name
+	quote.Rate = rule.Rate
+	quote.Inclusive = rule.Inclusive
+
+	rate := rule.Rate / 100
+	if rule.Inclusive {
+		quote.Net = payments.RoundMinor(price / (1 + rate))
+	}
+	if rule.ReverseCharge && strings.TrimSpace(buyer.TaxID) != "" {
+		quote.ReverseCharge = true
+		quote.BuyerTaxID = strings.TrimSpace(buyer.TaxID)
+		quote.Total = quote.Net
+		return quote
+	}
+	if rule.Inclusive {
+		quote.Tax = payments.FromMinor(payments.ToMinor(price) - payments.ToMinor(quote.Net))
+	} else {
+		quote.Tax = payments.RoundMinor(price * rate)
+		quote.Total = payments.FromMinor(payments.ToMinor(price) + payments.ToMinor(quote.Tax))
+	}
+	return quote
+}
+
+// AddTax adds the buyer's tax to the quote. Amount becomes the total to charge.
+// Call it after ConvertTo so tax is rounded in the currency actually paid.
+func (q *PriceQuote) AddTax(buyer *models.Person) {
+	q.Tax = CalculateTax(buyer, q.Amount)
+	q.Amount = q.Tax.Total
+}
+
+// TaxAmount is the tax included in the quote's Amount
+func (q *PriceQuote) TaxAmount() float64 {
+	if q.Tax == nil {
+		return 0
+	}
+	return q.Tax.Tax
+}
+
+// TaxReportRow is the tax collected in one jurisdiction and currency
+type TaxReportRow struct {
+	Country       string  `json:"country"`
+	TaxName       string  `json:"tax_name"`
+	Currency      string  `json:"currency"`
+	Transactions  int64   `json:"transactions"`
+	ReverseCharge int64   `json:"reverse_charge"` // sales where the buyer accounts for the tax
+	Gross         float64 `json:"gross"`
+	Tax           float64 `json:"tax"`
+	Net           float64 `json:"net"`
+}
+
+// TaxReport sums tax on paid transactions in [from, to) by country and currency.
+// Refunded sales are left out because the tax was given back with them.
+func TaxReport(from, to time.Time) ([]TaxReportRow, error) {
+	var rows []TaxReportRow
+	err := database.DB.Model(&models.Transaction{}).
+		Select(`tax_country AS country, tax_name, COALESCE(NULLIF(currency, ''), 'KES') AS currency,
+			COUNT(*) AS transactions,
+			SUM(CASE WHEN reverse_charge THEN 1 ELSE 0 END) AS reverse_charge,
+			SUM(amount) AS gross, SUM(tax) AS tax, SUM(amount - tax) AS net`).
+		Where("status = ? AND tax_country <> '' AND updated_at >= ? AND updated_at < ?", "success", from, to).
+		Group("tax_country, tax_name, COALESCE(NULLIF(currency, ''), 'KES')").
+		Order("tax_country").
+		Scan(&rows).Error
+	return rows, err
+}
@@ -0,0 +1,411 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"Api/database"
+	"Api/models"
+	"Api/utils"
+)
+
+const (
+	// LoginChallengeTTL is how long a user has to enter their code after the password
+	LoginChallengeTTL = 5 * time.Minute
+
+	maxChallengeAttempts = 5
+	recoveryCodeCount    = 10
+	totpIssuer           = "AlgoCDK"
+)
+
+var (
+	ErrInvalidChallenge        = errors.New("invalid or expired login challenge, sign in again")
+	ErrInvalidTwoFactorCode    = errors.New("invalid two-factor code")
+	ErrTwoFactorNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrNoTOTPEnrolment         = errors.New("start two-factor setup first")
+	ErrTwoFactorRequired       = errors.New("two-factor authentication is required for your role")
+)
+
+// LoginChallengeToken is returned instead of a session when the password alone is
+// not enough
+type LoginChallengeToken struct {
+	Token         string `json:"challenge_token"`
+	ExpiresIn     int64  `json:"expires_in"`     // seconds until the challenge expires
+	SetupRequired bool   `json:"setup_required"` // enrol an authenticator before signing in
+}
+
+// TOTPEnrolment is what an authenticator app needs to add the account
+type TOTPEnrolment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"` // show as a QR code for the app to scan
+}
+
+// TwoFactorStatus describes a user's second factor
+type TwoFactorStatus struct {
+	Enabled           bool       `json:"enabled"`
+	EnabledAt         *time.Time `json:"enabled_at,omitempty"`
+	Required          bool       `json:"required"` // by the policy for the user's role
+	SetupPending      bool       `json:"setup_pending"`
+	RecoveryCodesLeft int64      `json:"recovery_codes_left"`
+}
+
+// TwoFactorRequired reports whether the policy makes the role sign in with a second factor
+func TwoFactorRequired(role models.Role) bool {
+	var policy models.TwoFactorPolicy
+	if database.DB.Where("role = ?", role).First(&policy).Error != nil {
+		return false
+	}
+	return policy.Required
+}
+
+// StartLoginChallenge is called once the password checks out. It returns nil when
+// the user can be signed in straight away and a challenge to answer otherwise.
+func StartLoginChallenge(user *models.Person) (*LoginChallengeToken, error) {
+	enabled := user.TOTPEnabledAt != nil
+	setup := !enabled && TwoFactorRequired(models.NormalizeRole(string(user.Role)))
+	if !enabled && !setup {
+		return nil, nil
+	}
+
+	token, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate challenge token: %w", err)
+	}
+	now := time.Now()
+	challenge := models.LoginChallenge{
+		UserID:        user.ID,
+		TokenHash:     hashToken(token),
+		SetupRequired: setup,
+		ExpiresAt:     now.Add(LoginChallengeTTL),
+		CreatedAt:     now,
+	}
+	if err := database.DB.Create(&challenge).Error; err != nil {
+		return nil, fmt.Errorf("failed to create login challenge: %w", err)
+	}
+	return &LoginChallengeToken{
+		Token:         token,
+		ExpiresIn:     int64(LoginChallengeTTL / time.Second),
+		SetupRequired: setup,
+	}, nil
+}
+
+// loadChallenge finds an open challenge of the given kind and its user. Answering
+// counts as an attempt, and a challenge takes only maxChallengeAttempts answers.
+func loadChallenge(token string, setup, answer bool) (*models.LoginChallenge, *models.Person, error) {
+	var challenge models.LoginChallenge
+	if token == "" || database.DB.Where("token_hash = ?", hashToken(token)).First(&challenge).Error != nil {
+		return nil, nil, ErrInvalidChallenge
+	}
+	if challenge.SetupRequired != setup || challenge.UsedAt != nil || time.Now().After(challenge.ExpiresAt) ||
+		challenge.Attempts >= maxChallengeAttempts {
+		return nil, nil, ErrInvalidChallenge
+	}
+	if answer {
+		res := database.DB.Model(&models.LoginChallenge{}).
+			Where("id = ? AND attempts < ?", challenge.ID, maxChallengeAttempts).
+			Update("attempts", gorm.Expr("attempts + 1"))
+		if res.Error != nil {
+			return nil, nil, res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil, nil, ErrInvalidChallenge
+		}
+	}
+	var user models.Person
+	if err := database.DB.First(&user, challenge.UserID).Error; err != nil {
+		return nil, nil, ErrInvalidChallenge
+	}
+	return &challenge, &user, nil
+}
+
+// finishChallenge uses up the challenge and signs the user in
+func finishChallenge(challenge *models.LoginChallenge, user *models.Person, userAgent, ip string) (*AuthTokens, error) {
+	res := database.DB.Model(&models.LoginChallenge{}).
+		Where("id = ? AND used_at IS NULL", challenge.ID).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrInvalidChallenge
+	}
+	return StartSession(user, userAgent, ip)
+}
+
+// CompleteLoginChallenge signs the user in once they answer the challenge with a
+// TOTP code or one of their recovery codes. Wrong codes count as failed logins,
+// so the login throttle also limits guesses at the second factor; the account's
+// counter is only cleared once both factors check out.
+func CompleteLoginChallenge(token, code, recoveryCode, userAgent, ip string) (*models.Person, *AuthTokens, error) {
+	challenge, user, err := loadChallenge(token, false, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := CheckLoginAllowed(ip, user.Email); err != nil {
+		return nil, nil, err
+	}
+	if err := checkSecondFactor(user, code, recoveryCode); err != nil {
+		if errors.Is(err, ErrInvalidTwoFactorCode) {
+			RecordLoginFailure(ip, user.Email)
+		}
+		return nil, nil, err
+	}
+	tokens, err := finishChallenge(challenge, user, userAgent, ip)
+	if err != nil {
+		return nil, nil, err
+	}
+	RecordLoginSuccess(user.Email)
+	return user, tokens, nil
+}
+
+// ChallengeEnrolment starts TOTP setup for a user whose role requires 2FA but who
+// has not enrolled yet, before they have a session
+func ChallengeEnrolment(token string) (*TOTPEnrolment, error) {
+	_, user, err := loadChallenge(token, true, false)
+	if err != nil {
+		return nil, err
+	}
+	return BeginTOTPEnrolment(user)
+}
+
+// ConfirmChallengeEnrolment finishes setup started with ChallengeEnrolment and
+// signs the user in. The recovery codes are only ever shown here.
+func ConfirmChallengeEnrolment(token, code, userAgent, ip string) (*models.Person, *AuthTokens, []string, error) {
+	challenge, user, err := loadChallenge(token, true, true)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := CheckLoginAllowed(ip, user.Email); err != nil {
+		return nil, nil, nil, err
+	}
+	codes, err := ConfirmTOTPEnrolment(user, code)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	tokens, err := finishChallenge(challenge, user, userAgent, ip)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	RecordLoginSuccess(user.Email)
+	return user, tokens, codes, nil
+}
+
+// BeginTOTPEnrolment generates a new secret. It only takes effect once a code from
+// it is confirmed, so an abandoned setup never locks the user out.
+func BeginTOTPEnrolment(user *models.Person) (*TOTPEnrolment, error) {
+	if user.TOTPEnabledAt != nil {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+	if err := database.DB.Model(&models.Person{}).Where("id = ?", user.ID).
+		UpdateColumn("totp_pending_secret", secret).Error; err != nil {
+		return nil, fmt.Errorf("failed to save TOTP secret: %w", err)
+	}
+	user.TOTPPendingSecret = secret
+	return &TOTPEnrolment{
+		Secret: secret,
+		URI:    utils.TOTPProvisioningURI(totpIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTPEnrolment turns 2FA on once the user proves their app has the pending
+// secret, and returns a fresh set of recovery codes
+func ConfirmTOTPEnrolment(user *models.Person, code string) ([]string, error) {
+	if user.TOTPEnabledAt != nil {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if user.TOTPPendingSecret == "" {
+		return nil, ErrNoTOTPEnrolment
+	}
+	step, ok := utils.ValidateTOTP(user.TOTPPendingSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	var codes []string
+	now := time.Now()
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Person{}).Where("id = ?", user.ID).UpdateColumns(map[string]interface{}{
+			"totp_secret":         user.TOTPPendingSecret,
+			"totp_pending_secret": "",
+			"totp_enabled_at":     now,
+			"totp_last_step":      step,
+		}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to enable two-factor authentication: %w", err)
+	}
+	user.TOTPSecret = user.TOTPPendingSecret
+	user.TOTPPendingSecret = ""
+	user.TOTPEnabledAt = &now
+	user.TOTPLastStep = step
+	return codes, nil
+}
+
+// DisableTOTP turns 2FA off after checking a current code or a recovery code.
+// Users whose role requires 2FA cannot turn it off.
+func DisableTOTP(user *models.Person, code, recoveryCode string) error {
+	if TwoFactorRequired(models.NormalizeRole(string(user.Role))) {
+		return ErrTwoFactorRequired
+	}
+	if err := checkSecondFactor(user, code, recoveryCode); err != nil {
+		return err
+	}
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Person{}).Where("id = ?", user.ID).UpdateColumns(map[string]interface{}{
+			"totp_secret":         "",
+			"totp_pending_secret": "",
+			"totp_enabled_at":     nil,
+			"totp_last_step":      0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking a
+// current TOTP code
+func RegenerateRecoveryCodes(user *models.Person, code string) ([]string, error) {
+	if err := checkSecondFactor(user, code, ""); err != nil {
+		return nil, err
+	}
+	var codes []string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	return codes, err
+}
+
+// GetTwoFactorStatus reports the user's 2FA state
+func GetTwoFactorStatus(user *models.Person) TwoFactorStatus {
+	status := TwoFactorStatus{
+		Enabled:      user.TOTPEnabledAt != nil,
+		EnabledAt:    user.TOTPEnabledAt,
+		Required:     TwoFactorRequired(models.NormalizeRole(string(user.Role))),
+		SetupPending: user.TOTPEnabledAt == nil && user.TOTPPendingSecret != "",
+	}
+	database.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", user.ID).
+		Count(&status.RecoveryCodesLeft)
+	return status
+}
+
+// checkSecondFactor accepts a TOTP code, each time step only once, or an unused
+// recovery code
+func checkSecondFactor(user *models.Person, code, recoveryCode string) error {
+	if user.TOTPEnabledAt == nil || user.TOTPSecret == "" {
+		return ErrTwoFactorNotEnabled
+	}
+	if recoveryCode != "" {
+		res := database.DB.Model(&models.RecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashToken(normalizeRecoveryCode(recoveryCode))).
+			Update("used_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+
+	step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+	// Moving the last step forward only once makes a replayed code fail
+	res := database.DB.Model(&models.Person{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		UpdateColumn("totp_last_step", step)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	user.TOTPLastStep = step
+	return nil
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	codes := make([]string, recoveryCodeCount)
+	rows := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+		rows[i] = models.RecoveryCode{UserID: userID, CodeHash: hashToken(raw), CreatedAt: now}
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+}
+
+// TwoFactorPolicies lists every role with whether it must use 2FA
+func TwoFactorPolicies() ([]models.TwoFactorPolicy, error) {
+	var stored []models.TwoFactorPolicy
+	if err := database.DB.Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	byRole := map[models.Role]models.TwoFactorPolicy{}
+	for _, p := range stored {
+		byRole[p.Role] = p
+	}
+	policies := make([]models.TwoFactorPolicy, 0, len(models.Roles))
+	for _, role := range models.Roles {
+		p, ok := byRole[role]
+		if !ok {
+			p = models.TwoFactorPolicy{Role: role}
+		}
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+// SetTwoFactorPolicy makes the role sign in with a second factor, or stops
+// requiring it. Users of the role who have not enrolled set it up at their next
+// sign-in.
+func SetTwoFactorPolicy(role models.Role, required bool, updatedBy uint) (*models.TwoFactorPolicy, error) {
+	policy := models.TwoFactorPolicy{Role: role, Required: required, UpdatedBy: updatedBy, UpdatedAt: time.Now()}
+	err := database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "role"}},
+		DoUpdates: clause.AssignmentColumns([]string{"required", "updated_by", "updated_at"}),
+	}).Create(&policy).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to save two-factor policy: %w", err)
+	}
+	return &policy, nil
+}
@@ -0,0 +1,365 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"Api/database"
+	"Api/internal/config"
+	"Api/ledger"
+	"Api/models"
+	"Api/payments"
+)
+
+// walletHoldTTL is how long funds stay reserved for a payment that never settles
+const walletHoldTTL = 15 * time.Minute
+
+var (
+	ErrInsufficientFunds  = errors.New("insufficient wallet balance")
+	ErrInvalidTopUp       = errors.New("top-ups must be a whole amount greater than zero")
+	ErrWalletHoldNotFound = errors.New("wallet hold not found")
+)
+
+// WalletCheckout is a bot purchase or rental paid from the wallet
+type WalletCheckout struct {
+	BotID       uint   `json:"bot_id" binding:"required"`
+	PaymentType string `json:"payment_type" binding:"required"`
+	PlanID      uint   `json:"plan_id"`
+	CouponCode  string `json:"coupon_code"`
+	Currency    string `json:"currency"`   // wallet to pay from, defaults to the bot's currency
+	AutoRenew   *bool  `json:"auto_renew"` // rentals renew from the wallet unless set to false
+}
+
+// Available is what the wallet can still spend
+func Available(wallet *models.Wallet) int64 {
+	return wallet.Balance - wallet.Held
+}
+
+// GetWallets returns the user's wallets, one per currency they have used
+func GetWallets(userID uint) ([]models.Wallet, error) {
+	var wallets []models.Wallet
+	err := database.DB.Where("user_id = ?", userID).Order("currency").Find(&wallets).Error
+	return wallets, err
+}
+
+// lockWallet returns the user's wallet in currency, created empty on first use,
+// locked for the rest of tx
+func lockWallet(tx *gorm.DB, userID uint, currency string) (*models.Wallet, error) {
+	now := time.Now()
+	wallet := models.Wallet{UserID: userID, Currency: currency, CreatedAt: now, UpdatedAt: now}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&wallet).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND currency = ?", userID, currency).
+		First(&wallet).Error; err != nil {
+		return nil, err
+	}
+	return &wallet, nil
+}
+
+// creditWallet adds amount to the wallet and records it in the history
+func creditWallet(tx *gorm.DB, transaction *models.Transaction, amount int64, kind, description string) error {
+	wallet, err := lockWallet(tx, transaction.UserID, ledger.CurrencyOf(transaction))
+	if err != nil {
+		return err
+	}
+	wallet.Balance += amount
+	wallet.UpdatedAt = time.Now()
+	if err := tx.Save(wallet).Error; err != nil {
+		return fmt.Errorf("failed to credit wallet: %w", err)
+	}
+	return tx.Create(&models.WalletEntry{
+		WalletID:      wallet.ID,
+		UserID:        wallet.UserID,
+		Kind:          kind,
+		Amount:        amount,
+		BalanceAfter:  wallet.Balance,
+		Reference:     transaction.Reference,
+		TransactionID: &transaction.ID,
+		Description:   description,
+		CreatedAt:     wallet.UpdatedAt,
+	}).Error
+}
+
+// HoldFunds reserves amount for a payment so it cannot be spent twice. The wallet
+// is never allowed to promise more than its balance.
+func HoldFunds(userID uint, currency string, amount int64, reference string) (*models.WalletHold, error) {
+	var hold models.WalletHold
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		wallet, err := lockWallet(tx, userID, currency)
+		if err != nil {
+			return err
+		}
+		if amount <= 0 || Available(wallet) < amount {
+			return ErrInsufficientFunds
+		}
+		now := time.Now()
+		wallet.Held += amount
+		wallet.UpdatedAt = now
+		if err := tx.Save(wallet).Error; err != nil {
+			return err
+		}
+		hold = models.WalletHold{
+			WalletID:  wallet.ID,
+			UserID:    userID,
+			Amount:    amount,
+			Reference: reference,
+			Status:    "held",
+			ExpiresAt: now.Add(walletHoldTTL),
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		return tx.Create(&hold).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+// captureWalletHold spends the funds held for a wallet payment being fulfilled in tx
+func captureWalletHold(tx *gorm.DB, transaction *models.Transaction) error {
+	var hold models.WalletHold
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("reference = ? AND status = ?", transaction.Reference, "held").
+		First(&hold).Error; err != nil {
+		return ErrWalletHoldNotFound
+	}
+	var wallet models.Wallet
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&wallet, hold.WalletID).Error; err != nil {
+		return err
+	}
+	if wallet.Balance < hold.Amount || wallet.Held < hold.Amount {
+		return ErrInsufficientFunds
+	}
+
+	now := time.Now()
+	wallet.Balance -= hold.Amount
+	wallet.Held -= hold.Amount
+	wallet.UpdatedAt = now
+	if err := tx.Save(&wallet).Error; err != nil {
+		return fmt.Errorf("failed to debit wallet: %w", err)
+	}
+	hold.Status = "captured"
+	hold.UpdatedAt = now
+	if err := tx.Save(&hold).Error; err != nil {
+		return err
+	}
+	if err := tx.Create(&models.WalletEntry{
+		WalletID:      wallet.ID,
+		UserID:        wallet.UserID,
+		Kind:          transaction.PaymentType,
+		Amount:        -hold.Amount,
+		BalanceAfter:  wallet.Balance,
+		Reference:     transaction.Reference,
+		TransactionID: &transaction.ID,
+		Description:   transaction.Description,
+		CreatedAt:     now,
+	}).Error; err != nil {
+		return err
+	}
+	return ledger.PostWalletSpend(tx, transaction, hold.Amount)
+}
+
+// ReleaseHold gives held funds back to the wallet when their payment did not go through
+func ReleaseHold(reference string) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var hold models.WalletHold
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("reference = ?", reference).
+			First(&hold).Error; err != nil {
+			return ErrWalletHoldNotFound
+		}
+		if hold.Status != "held" {
+			return nil
+		}
+		var wallet models.Wallet
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&wallet, hold.WalletID).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		wallet.Held -= hold.Amount
+		if wallet.Held < 0 {
+			wallet.Held = 0
+		}
+		wallet.UpdatedAt = now
+		if err := tx.Save(&wallet).Error; err != nil {
+			return err
+		}
+		hold.Status = "released"
+		hold.UpdatedAt = now
+		return tx.Save(&hold).Error
+	})
+}
+
+// ReleaseExpiredHolds frees funds held for wallet payments that never settled
+func ReleaseExpiredHolds() error {
+	var expired []models.WalletHold
+	if err := database.DB.Where("status = ? AND expires_at < ?", "held", time.Now()).Find(&expired).Error; err != nil {
+		return err
+	}
+	for _, hold := range expired {
+		unlock := referenceLocks.Lock(hold.Reference)
+		// Keep the funds held unless the order is closed first, so it cannot still
+		// be fulfilled after they are spent elsewhere; the next run tries again
+		if err := FailOrder(hold.Reference, "wallet hold expired"); err != nil {
+			log.Printf("Failed to fail order %s, keeping its wallet hold: %v", hold.Reference, err)
+		} else if err := ReleaseHold(hold.Reference); err != nil {
+			log.Printf("Failed to release wallet hold %s: %v", hold.Reference, err)
+		}
+		unlock()
+	}
+	return nil
+}
+
+// settleWalletPayment fulfils a transaction whose funds are already held and gives
+// the funds back if fulfilment fails
+func settleWalletPayment(transaction *models.Transaction) (*FulfilmentResult, error) {
+	fulfilment, err := FulfilOrder(transaction.Reference, transaction.Amount, ledger.CurrencyOf(transaction))
+	if err != nil {
+		FailOrder(transaction.Reference, err.Error())
+		if releaseErr := ReleaseHold(transaction.Reference); releaseErr != nil {
+			log.Printf("Failed to release wallet hold %s: %v", transaction.Reference, releaseErr)
+		}
+		return nil, err
+	}
+	return fulfilment, nil
+}
+
+// fulfilTopUp credits a paid top-up to the wallet
+func fulfilTopUp(tx *gorm.DB, transaction *models.Transaction) (*FulfilmentResult, error) {
+	transaction.Status = "success"
+	transaction.UpdatedAt = time.Now()
+	if err := tx.Save(transaction).Error; err != nil {
+		return nil, fmt.Errorf("failed to update transaction: %w", err)
+	}
+	if err := creditWallet(tx, transaction, payments.ToMinor(transaction.Amount), "topup", transaction.Description); err != nil {
+		return nil, err
+	}
+	if err := ledger.PostTopUp(tx, transaction); err != nil {
+		return nil, fmt.Errorf("failed to post top-up to ledger: %w", err)
+	}
+	log.Printf("Wallet top-up %s credited to user %d", transaction.Reference, transaction.UserID)
+	return newResult(transaction, &models.UserBot{}), nil
+}
+
+// refundToWallet puts a refunded wallet payment back in the wallet
+func refundToWallet(tx *gorm.DB, transaction *models.Transaction) error {
+	amount := payments.ToMinor(transaction.RefundedAmount)
+	if err := creditWallet(tx, transaction, amount, "refund", "Refund of "+transaction.Reference); err != nil {
+		return err
+	}
+	return ledger.PostWalletRefund(tx, transaction, amount)
+}
+
+// StartWalletTopUp starts a provider payment that tops up the user's wallet once
+// it succeeds. channel is a registered provider, the default one when empty.
+func StartWalletTopUp(user *models.Person, amount float64, currency, channel, phone string) (*payments.InitializeResponse, error) {
+	minor := payments.ToMinor(amount)
+	if minor <= 0 || minor%100 != 0 {
+		return nil, ErrInvalidTopUp
+	}
+	provider := payments.Default()
+	if channel != "" {
+		var err error
+		if provider, err = payments.Get(channel); err != nil {
+			return nil, err
+		}
+	}
+	if provider.Name() == payments.ChannelMpesa && currency != "KES" {
+		return nil, fmt.Errorf("M-Pesa top-ups are only made in KES")
+	}
+
+	reference := fmt.Sprintf("TOP_%d_%d", user.ID, time.Now().UnixNano())
+	resp, err := provider.Initialize(payments.InitializeRequest{
+		Email:       user.Email,
+		Phone:       phone,
+		Amount:      minor,
+		Currency:    currency,
+		Reference:   reference,
+		CallbackURL: config.Get().Paystack.CallbackURL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start top-up: %w", err)
+	}
+	if resp.Reference != "" {
+		// M-Pesa answers with its own CheckoutRequestID
+		reference = resp.Reference
+	}
+
+	transaction := models.Transaction{
+		UserID:             user.ID,
+		Amount:             amount,
+		Status:             "pending",
+		Reference:          reference,
+		PaymentChannel:     provider.Name(),
+		PaymentType:        "topup",
+		Description:        "Wallet top-up",
+		CreatedAt:          time.Now(),
+		Currency:           currency,
+		SettlementCurrency: currency,
+		SettlementAmount:   amount,
+		ExchangeRate:       1,
+	}
+	if err := database.DB.Create(&transaction).Error; err != nil {
+		return nil, fmt.Errorf("failed to save top-up: %w", err)
+	}
+	return resp, nil
+}
+
+// PayWithWallet buys or rents a bot with the user's wallet balance. Rentals paid
+// this way renew from the wallet unless AutoRenew is false.
+func PayWithWallet(userID uint, in WalletCheckout) (*FulfilmentResult, error) {
+	checkout, err := PrepareCheckout(CheckoutInput{
+		UserID:      userID,
+		BotID:       in.BotID,
+		PaymentType: in.PaymentType,
+		PlanID:      in.PlanID,
+		CouponCode:  in.CouponCode,
+		Currency:    in.Currency,
+		Channel:     payments.ChannelWallet,
+		Description: fmt.Sprintf("Payment for bot %d (%s) from wallet", in.BotID, in.PaymentType),
+		AutoRenew:   in.AutoRenew,
+	})
+	if err != nil {
+		return nil, err
+	}
+	reference := fmt.Sprintf("WAL_%d_%d", userID, time.Now().UnixNano())
+	transaction, err := checkout.Record(reference, checkout.Quote.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := HoldFunds(userID, transaction.Currency, payments.ToMinor(transaction.Amount), transaction.Reference); err != nil {
+		FailOrder(transaction.Reference, err.Error())
+		return nil, err
+	}
+	fulfilment, err := settleWalletPayment(transaction)
+	if err != nil {
+		return nil, err
+	}
+	if err := StartSubscription(fulfilment, nil, checkout.Buyer.Email); err != nil {
+		log.Printf("Failed to start subscription for %s: %v", transaction.Reference, err)
+	}
+	return fulfilment, nil
+}
+
+// renewFromWallet pays a subscription renewal from the renter's wallet
+func renewFromWallet(sub *models.Subscription, transaction *models.Transaction) error {
+	if _, err := HoldFunds(sub.UserID, ledger.CurrencyOf(transaction), payments.ToMinor(transaction.Amount), transaction.Reference); err != nil {
+		FailOrder(transaction.Reference, err.Error())
+		return renewalFailed(sub, err.Error())
+	}
+	fulfilment, err := settleWalletPayment(transaction)
+	if err != nil {
+		return renewalFailed(sub, err.Error())
+	}
+	// StartSubscription moves the period forward and clears any failure state
+	return StartSubscription(fulfilment, nil, sub.Email)
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm/clause"
+
+	"Api/database"
+	"Api/models"
+)
+
+// Webhook retry schedule: the first retry waits webhookRetryBase and each later one
+// twice as long, up to webhookRetryMax, until webhookMaxAttempts is reached.
+const (
+	webhookRetryBase   = time.Minute
+	webhookRetryMax    = 6 * time.Hour
+	webhookMaxAttempts = 10
+)
+
+var (
+	ErrWebhookNotFound = errors.New("webhook event not found")
+	ErrWebhookUnsigned = errors.New("webhook event failed signature verification and cannot be replayed")
+)
+
+// WebhookProcessor applies an authenticated event body. It must be safe to call
+// more than once for the same body.
+type WebhookProcessor func(body []byte) error
+
+var (
+	webhookProcessorsMu sync.RWMutex
+	webhookProcessors   = map[string]WebhookProcessor{}
+	webhookLocks        = newKeyedMutex()
+)
+
+// RegisterWebhookProcessor sets the processor for events stored with the given source
+func RegisterWebhookProcessor(source string, processor WebhookProcessor) {
+	webhookProcessorsMu.Lock()
+	defer webhookProcessorsMu.Unlock()
+	webhookProcessors[source] = processor
+}
+
+func webhookProcessor(source string) (WebhookProcessor, bool) {
+	webhookProcessorsMu.RLock()
+	defer webhookProcessorsMu.RUnlock()
+	p, ok := webhookProcessors[source]
+	return p, ok
+}
+
+// WebhookDelivery describes an incoming provider notification to store
+type WebhookDelivery struct {
+	Provider       string
+	Source         string
+	EventID        string // empty when the provider sends none, the body hash is used instead
+	Type           string
+	Reference      string
+	Body           []byte
+	SignatureValid bool
+}
+
+// RecordWebhook stores a delivery unless the provider already sent it. It returns
+// the stored event and whether this delivery created it.
+func RecordWebhook(d WebhookDelivery) (*models.WebhookEvent, bool, error) {
+	eventID := d.EventID
+	if eventID == "" || !d.SignatureValid {
+		// Keep unsigned bodies apart so a forgery cannot shadow the real event
+		prefix := "sha256:"
+		if !d.SignatureValid {
+			prefix = "unsigned:"
+		}
+		sum := sha256.Sum256(d.Body)
+		eventID = prefix + hex.EncodeToString(sum[:])
+	}
+
+	now := time.Now()
+	event := models.WebhookEvent{
+		Provider:       d.Provider,
+		EventID:        eventID,
+		Source:         d.Source,
+		Type:           d.Type,
+		Reference:      d.Reference,
+		Payload:        string(d.Body),
+		SignatureValid: d.SignatureValid,
+		Status:         "received",
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if !d.SignatureValid {
+		event.Status = "rejected"
+	}
+
+	res := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&event)
+	if res.Error != nil {
+		return nil, false, fmt.Errorf("failed to store webhook event: %w", res.Error)
+	}
+	if res.RowsAffected == 1 {
+		return &event, true, nil
+	}
+
+	var existing models.WebhookEvent
+	if err := database.DB.Where("provider = ? AND event_id = ?", d.Provider, eventID).First(&existing).Error; err != nil {
+		return nil, false, fmt.Errorf("failed to load webhook event: %w", err)
+	}
+	return &existing, false, nil
+}
+
+// ProcessWebhook runs a stored event through its processor and records the outcome.
+// Processed events are skipped unless force is set; a failure schedules a retry
+// with exponential backoff.
+func ProcessWebhook(eventID uint, force bool) (*models.WebhookEvent, error) {
+	unlock := webhookLocks.Lock(strconv.FormatUint(uint64(eventID), 10))
+	defer unlock()
+
+	var event models.WebhookEvent
+	if err := database.DB.First(&event, eventID).Error; err != nil {
+		return nil, ErrWebhookNotFound
+	}
+	if !event.SignatureValid {
+		return &event, ErrWebhookUnsigned
+	}
+	if event.Status == "processed" && !force {
+		return &event, nil
+	}
+
+	var procErr error
+	if processor, ok := webhookProcessor(event.Source); ok {
+		procErr = processor([]byte(event.Payload))
+	} else {
+		procErr = fmt.Errorf("no processor registered for %q events", event.Source)
+	}
+
+	now := time.Now()
+	event.Attempts++
+	event.UpdatedAt = now
+	if procErr == nil {
+		event.Status = "processed"
+		event.LastError = ""
+		event.NextAttemptAt = nil
+		event.ProcessedAt = &now
+	} else {
+		event.Status = "failed"
+		event.LastError = procErr.Error()
+		event.NextAttemptAt = nil
+		if event.Attempts < webhookMaxAttempts {
+			next := now.Add(webhookBackoff(event.Attempts))
+			event.NextAttemptAt = &next
+		}
+		log.Printf("Webhook event %d (%s %s) failed on attempt %d: %v", event.ID, event.Provider, event.Type, event.Attempts, procErr)
+	}
+	if err := database.DB.Save(&event).Error; err != nil {
+		return &event, fmt.Errorf("failed to update webhook event: %w", err)
+	}
+	return &event, procErr
+}
+
+// webhookBackoff is the wait before the retry that follows the given attempt
+func webhookBackoff(attempts int) time.Duration {
+	delay := webhookRetryBase
+	for i := 1; i < attempts && delay < webhookRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > webhookRetryMax {
+		delay = webhookRetryMax
+	}
+	return delay
+}
+
+// RetryDueWebhooks reprocesses failed events whose backoff has passed, and events
+// that were stored but never processed, e.g. because the server stopped mid-request.
+func RetryDueWebhooks() error {
+	now := time.Now()
+	var due []models.WebhookEvent
+	err := database.DB.Select("id").
+		Where("(status = ? AND next_attempt_at <= ?) OR (status = ? AND created_at <= ?)",
+			"failed", now, "received", now.Add(-5*time.Minute)).
+		Order("id").
+		Limit(100).
+		Find(&due).Error
+	if err != nil {
+		return err
+	}
+	for _, e := range due {
+		// Errors are recorded on the event itself
+		ProcessWebhook(e.ID, false)
+	}
+	return nil
+}
+
+// ReplayWebhook processes a stored event again, even if it already succeeded.
+// Processors are idempotent, so replaying a processed event only repeats work
+// that a bug fix may have changed.
+func ReplayWebhook(eventID, replayedBy uint) (*models.WebhookEvent, error) {
+	if err := database.DB.Model(&models.WebhookEvent{}).Where("id = ?", eventID).
+		Update("replayed_by", replayedBy).Error; err != nil {
+		return nil, err
+	}
+	event, err := ProcessWebhook(eventID, true)
+	if event != nil {
+		log.Printf("Superadmin %d replayed webhook event %d: status=%s", replayedBy, eventID, event.Status)
+	}
+	return event, err
+}
@@ -0,0 +1,14 @@
+package tasks
+
+import (
+	"log"
+
+	"Api/services"
+)
+
+// LiftExpiredSuspensions reactivates accounts whose suspension has run out.
+func LiftExpiredSuspensions() {
+	if err := services.LiftExpiredSuspensions(); err != nil {
+		log.Printf("[Scheduler] Lifting expired suspensions failed: %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package tasks
 import (
 	"Api/database"
 	"Api/models"
+	"Api/services"
 	"log"
 	"time"
 )
@@ -25,6 +26,9 @@ func DeactivateExpiredBots() {
 	}
 
 	for _, bot := range expiredBots {
+		if services.InRenewalGrace(bot.UserID, bot.BotID) {
+			continue
+		}
 		bot.IsActive = false
 		database.DB.Save(&bot)
 		log.Printf("[Scheduler] Deactivated bot ID %d (UserID: %d)\n", bot.BotID, bot.UserID)
@@ -0,0 +1,14 @@
+package tasks
+
+import (
+	"log"
+
+	"Api/services"
+)
+
+// ReleaseEscrows pays creators the escrowed share of purchases whose dispute window has closed.
+func ReleaseEscrows() {
+	if err := services.ReleaseDueEscrows(); err != nil {
+		log.Printf("[Scheduler] Escrow release failed: %v", err)
+	}
+}
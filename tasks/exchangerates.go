@@ -0,0 +1,14 @@
+package tasks
+
+import (
+	"log"
+
+	"Api/services"
+)
+
+// ImportExchangeRates refreshes the stored exchange rates from EXCHANGE_RATES_URL.
+func ImportExchangeRates() {
+	if err := services.ImportExchangeRates(); err != nil {
+		log.Printf("[Scheduler] Exchange rate import failed: %v", err)
+	}
+}
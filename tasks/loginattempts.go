@@ -0,0 +1,14 @@
+package tasks
+
+import (
+	"log"
+
+	"Api/services"
+)
+
+// PruneLoginAttempts drops failed login counters that no longer count.
+func PruneLoginAttempts() {
+	if err := services.PruneLoginAttempts(); err != nil {
+		log.Printf("[Scheduler] Pruning login attempts failed: %v", err)
+	}
+}
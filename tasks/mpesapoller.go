@@ -0,0 +1,65 @@
+package tasks
+
+import (
+	"log"
+	"time"
+
+	"Api/database"
+	"Api/models"
+	"Api/mpesa"
+	"Api/payments"
+	"Api/services"
+)
+
+const (
+	// stkCallbackGrace gives Daraja time to deliver the callback before we query
+	stkCallbackGrace = 2 * time.Minute
+	// stkGiveUpAfter is when an unanswered STK push is treated as abandoned
+	stkGiveUpAfter = 24 * time.Hour
+)
+
+// ResolvePendingSTKPushes queries Daraja for M-Pesa payments whose callback never arrived.
+func ResolvePendingSTKPushes() {
+	var pending []models.Transaction
+	database.DB.Where("payment_channel = ? AND status = ? AND created_at < ?", mpesa.Channel, "pending", time.Now().Add(-stkCallbackGrace)).
+		Find(&pending)
+	if len(pending) == 0 {
+		return
+	}
+
+	provider, err := payments.Get(mpesa.Channel)
+	if err != nil {
+		log.Printf("[Scheduler] %v", err)
+		return
+	}
+
+	log.Printf("[Scheduler] Resolving %d pending M-Pesa payments...", len(pending))
+	for _, t := range pending {
+		result, err := provider.Verify(t.Reference)
+		if err != nil {
+			log.Printf("[Scheduler] STK query for %s failed: %v", t.Reference, err)
+			continue
+		}
+
+		switch result.Status {
+		case "success":
+			// Daraja's STK query does not say how much was paid, so only the callback,
+			// which does, may fulfil the order. Until it arrives the payment stays pending.
+			if result.Amount == 0 {
+				if time.Since(t.CreatedAt) > stkGiveUpAfter {
+					services.FailOrder(t.Reference, "M-Pesa confirmed the payment but never reported the amount")
+				}
+				continue
+			}
+			if _, err := services.FulfilOrder(t.Reference, payments.FromMinor(result.Amount), result.Currency); err != nil {
+				log.Printf("[Scheduler] Failed to fulfil %s: %v", t.Reference, err)
+			}
+		case "pending":
+			if time.Since(t.CreatedAt) > stkGiveUpAfter {
+				services.FailOrder(t.Reference, "no response from M-Pesa")
+			}
+		default:
+			services.FailOrder(t.Reference, result.Message)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package tasks
+
+import (
+	"log"
+	"time"
+
+	"Api/payments"
+	"Api/services"
+)
+
+// ReconcileYesterday reconciles yesterday's payments with every provider that can list them.
+func ReconcileYesterday() {
+	now := time.Now()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	from := to.AddDate(0, 0, -1)
+
+	for _, provider := range payments.All() {
+		if _, ok := provider.(payments.TransactionLister); !ok {
+			continue
+		}
+		log.Printf("[Scheduler] Reconciling %s payments from %s...", provider.Name(), from.Format("2006-01-02"))
+		if _, err := services.Reconcile(provider, from, to); err != nil {
+			log.Printf("[Scheduler] Reconciliation of %s failed: %v", provider.Name(), err)
+		}
+	}
+}
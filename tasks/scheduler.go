@@ -0,0 +1,46 @@
+package tasks
+
+import (
+	"log"
+	"time"
+)
+
+// Every runs job immediately and then on every tick of interval. Call it in a goroutine.
+func Every(interval time.Duration, name string, job func()) {
+	run := func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[Scheduler] %s panicked: %v", name, r)
+			}
+		}()
+		job()
+	}
+
+	run()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		run()
+	}
+}
+
+// Daily runs job every day at the given hour (local time). Call it in a goroutine.
+func Daily(hour int, name string, job func()) {
+	for {
+		now := time.Now()
+		next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, now.Location())
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		time.Sleep(time.Until(next))
+
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("[Scheduler] %s panicked: %v", name, r)
+				}
+			}()
+			job()
+		}()
+	}
+}
@@ -0,0 +1,31 @@
+package tasks
+
+import (
+	"log"
+	"time"
+
+	"Api/database"
+	"Api/models"
+	"Api/services"
+)
+
+// RenewSubscriptions charges every subscription that is due and expires the ones
+// whose grace period ran out.
+func RenewSubscriptions() {
+	var due []models.Subscription
+	database.DB.Where("status IN ? AND next_charge_at <= ?", []string{"active", "past_due"}, time.Now()).
+		Find(&due)
+
+	if len(due) > 0 {
+		log.Printf("[Scheduler] Renewing %d subscriptions...", len(due))
+	}
+	for i := range due {
+		if err := services.RenewSubscription(&due[i]); err != nil {
+			log.Printf("[Scheduler] Renewal of subscription %d failed: %v", due[i].ID, err)
+		}
+	}
+
+	if err := services.ExpireLapsedSubscriptions(); err != nil {
+		log.Printf("[Scheduler] Failed to expire subscriptions: %v", err)
+	}
+}
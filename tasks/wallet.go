@@ -0,0 +1,14 @@
+package tasks
+
+import (
+	"log"
+
+	"Api/services"
+)
+
+// ReleaseWalletHolds frees wallet funds held for payments that never completed.
+func ReleaseWalletHolds() {
+	if err := services.ReleaseExpiredHolds(); err != nil {
+		log.Printf("[Scheduler] Releasing wallet holds failed: %v", err)
+	}
+}
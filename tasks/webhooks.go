@@ -0,0 +1,14 @@
+package tasks
+
+import (
+	"log"
+
+	"Api/services"
+)
+
+// RetryWebhooks reprocesses stored provider events whose retry is due.
+func RetryWebhooks() {
+	if err := services.RetryDueWebhooks(); err != nil {
+		log.Printf("[Scheduler] Retrying webhooks failed: %v", err)
+	}
+}
@@ -1,17 +1,36 @@
 package utils
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func GenerateToken(userID uint, email string) (string, error) {
+// AccessTokenTTL is how long an access token is accepted; clients renew it with their refresh token
+const AccessTokenTTL = 15 * time.Minute
+
+// GenerateToken issues a short-lived access token for the given login session. The
+// role is read again on every refresh, so role changes apply within AccessTokenTTL.
+func GenerateToken(userID uint, email, role string, sessionID uint) (string, error) {
 	claims := jwt.MapClaims{
 		"user_id": userID,
 		"email":   email,
-		"exp":     time.Now().Add(time.Hour * 24 * 7).Unix(),
+		"role":    role,
+		"sid":     sessionID,
+		"iat":     time.Now().Unix(),
+		"exp":     time.Now().Add(AccessTokenTTL).Unix(),
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(JwtKey)
+	return token.SignedString(JwtKey())
+}
+
+// GenerateRefreshToken returns a random opaque refresh token
+func GenerateRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
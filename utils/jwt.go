@@ -1,3 +1,8 @@
 package utils
 
-var JwtKey = []byte("algo")
+import "Api/internal/config"
+
+// JwtKey signs and verifies access tokens, from JWT_SECRET
+func JwtKey() []byte {
+	return []byte(config.Get().JWTSecret)
+}